@@ -0,0 +1,330 @@
+// Package app build the application from its dependencies.
+// The app installs its config, databases and validator into the globals of the packages
+// for the code which still uses them, the apps created WithoutGlobals don't share state
+// so several of them can run in one process
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/controllers"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/health"
+	"github.com/frullah/gin-boilerplate/logging"
+	"github.com/frullah/gin-boilerplate/metrics"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/ratelimit"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/seed"
+	"github.com/frullah/gin-boilerplate/storage"
+	"github.com/frullah/gin-boilerplate/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultPort = 8080
+
+// App owns the config, the databases, the file system, the storage, the logger, the tracer provider and the router
+type App struct {
+	Config    *config.Config
+	FS        afero.Fs
+	Databases *db.Databases
+	Storage   storage.Blob
+	Logger    *logrus.Logger
+	Router    *gin.Engine
+	// Health checkers of /readyz, the subsystems register their checkers into it
+	Health *health.Registry
+	// Metrics of /metrics, the subsystems register their collectors into it
+	Metrics *prometheus.Registry
+	// TracerProvider of the spans, nil when the tracing is disabled
+	TracerProvider trace.TracerProvider
+	// RateLimiter store of the rate limits of the route groups
+	RateLimiter ratelimit.Store
+	// Environment such as "development" or "test", APP_ENV by default
+	Environment string
+	// Stderr of the default logger and the bootstrap token, os.Stderr by default
+	Stderr io.Writer
+
+	// ownDatabases is true when the databases are opened by the app, so they are closed by it
+	ownDatabases bool
+	// ownTracerProvider is created from the config, it is shut down by the app
+	ownTracerProvider *sdktrace.TracerProvider
+	// ownRateLimiter is created from the config, it is closed by the app when it is a closer
+	ownRateLimiter bool
+	// routes are the dependencies of the controllers, they share the route metrics
+	routes controllers.Dependencies
+	// withoutGlobals keep the app out of the globals of the packages
+	withoutGlobals bool
+	// shuttingDown is set to 1 once the shutdown starts, the app isn't ready anymore
+	shuttingDown int32
+}
+
+// Option of the app
+type Option func(app *App)
+
+// WithConfig use the config instead of loading config.toml
+func WithConfig(cnf *config.Config) Option {
+	return func(app *App) { app.Config = cnf }
+}
+
+// WithFS use the file system, the OS file system by default
+func WithFS(fs afero.Fs) Option {
+	return func(app *App) { app.FS = fs }
+}
+
+// WithDatabases use the opened databases, they are not closed by the app
+func WithDatabases(databases *db.Databases) Option {
+	return func(app *App) { app.Databases = databases }
+}
+
+// WithStorage use the storage instead of the configured one
+func WithStorage(blob storage.Blob) Option {
+	return func(app *App) { app.Storage = blob }
+}
+
+// WithLogger use the logger, it is created from the config and writes to stderr by default
+func WithLogger(logger *logrus.Logger) Option {
+	return func(app *App) { app.Logger = logger }
+}
+
+// WithTracerProvider use the tracer provider instead of the configured exporter, it is not shut down by the app
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(app *App) { app.TracerProvider = provider }
+}
+
+// WithRateLimiter use the store instead of the configured one, it is not closed by the app
+func WithRateLimiter(store ratelimit.Store) Option {
+	return func(app *App) { app.RateLimiter = store }
+}
+
+// WithEnvironment use the environment instead of APP_ENV
+func WithEnvironment(env string) Option {
+	return func(app *App) { app.Environment = env }
+}
+
+// WithStderr write the default logger and the bootstrap token to w instead of os.Stderr
+func WithStderr(w io.Writer) Option {
+	return func(app *App) { app.Stderr = w }
+}
+
+// WithRouter load the routes into the router
+func WithRouter(router *gin.Engine) Option {
+	return func(app *App) { app.Router = router }
+}
+
+// WithoutGlobals don't install the config, the databases and the validator of the app
+// into the globals of the packages, so the apps of the parallel tests don't share them
+func WithoutGlobals() Option {
+	return func(app *App) { app.withoutGlobals = true }
+}
+
+// New app, the config is validated then the dependencies which are not given by the options are created from it
+func New(options ...Option) (*App, error) {
+	app := &App{}
+	for _, option := range options {
+		option(app)
+	}
+
+	if app.FS == nil {
+		app.FS = afero.NewOsFs()
+	}
+	if app.Environment == "" {
+		app.Environment = os.Getenv("APP_ENV")
+	}
+	if app.Stderr == nil {
+		app.Stderr = os.Stderr
+	}
+	if app.Config == nil {
+		cnf, err := config.Load(app.FS)
+		if err != nil {
+			return nil, err
+		}
+		app.Config = cnf
+	}
+	// the invalid config is reported before any dependency is built from it
+	if err := app.Config.Validate(); err != nil {
+		return nil, err
+	}
+	if app.Logger == nil {
+		logger, err := logging.New(app.Config.Log, app.Environment, app.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		app.Logger = logger
+	}
+	if app.TracerProvider == nil {
+		provider, err := tracing.New(app.Config.Tracing, os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		if provider != nil {
+			app.TracerProvider = provider
+			app.ownTracerProvider = provider
+		}
+	}
+	if app.Databases == nil {
+		databases, err := db.Open(app.Config.DB)
+		if err != nil {
+			app.Close()
+			return nil, err
+		}
+		databases.SetLogger(logging.GormLogger{Entry: logrus.NewEntry(app.Logger)})
+		app.Databases = databases
+		app.ownDatabases = true
+	}
+	if app.Storage == nil {
+		blob, err := storage.New(app.FS, app.Config.Storage)
+		if err != nil {
+			app.Close()
+			return nil, err
+		}
+		app.Storage = blob
+	}
+	if app.RateLimiter == nil {
+		store, err := ratelimit.New(app.Config.RateLimit)
+		if err != nil {
+			app.Close()
+			return nil, err
+		}
+		app.RateLimiter = store
+		app.ownRateLimiter = true
+	}
+	app.Health = health.NewRegistry(app.Config.Health.Timeout.Duration)
+	app.Health.RegisterDatabases(app.Databases)
+	if pinger, ok := app.RateLimiter.(interface{ Ping(context.Context) error }); ok {
+		// the shared buckets of the instances are unavailable without the store
+		app.Health.Register("ratelimit", health.CheckerFunc(pinger.Ping))
+	}
+	app.Health.Register("shutdown", health.CheckerFunc(func(ctx context.Context) error {
+		if !app.Ready() {
+			return health.ErrShuttingDown
+		}
+		return nil
+	}))
+	app.Metrics = prometheus.NewRegistry()
+	if err := metrics.RegisterDatabases(app.Metrics, app.Databases); err != nil {
+		app.Close()
+		return nil, err
+	}
+	if app.Router == nil {
+		app.Router = gin.New()
+		app.Router.Use(logging.Middleware(app.Logger))
+	}
+
+	if err := app.loadRoutes(); err != nil {
+		app.Close()
+		return nil, err
+	}
+	if !app.withoutGlobals {
+		app.installGlobals()
+	}
+	return app, nil
+}
+
+// installGlobals of the app, config.Get, db.Get and the binding of gin use the dependencies of the app
+func (app *App) installGlobals() {
+	config.Set(app.Config)
+	db.Set(app.Databases)
+	binding.Validator = controllers.NewBindingValidator()
+}
+
+func (app *App) loadRoutes() error {
+	router := app.Router
+
+	if middleware := corsMiddleware(app.Config.CORS.Policy(app.Environment)); middleware != nil {
+		router.Use(middleware)
+	}
+
+	routeMetrics, err := controllers.NewMetrics(app.Metrics)
+	if err != nil {
+		return err
+	}
+	app.routes = controllers.Dependencies{
+		Config:         app.Config,
+		Databases:      app.Databases,
+		Storage:        app.Storage,
+		Logger:         app.Logger,
+		Health:         app.Health,
+		Metrics:        routeMetrics,
+		TracerProvider: app.TracerProvider,
+		RateLimiter:    app.RateLimiter,
+	}
+	if err := controllers.LoadRoutes(router, app.routes); err != nil {
+		return err
+	}
+
+	// serve presigned URLs of the local storage
+	if local, ok := app.Storage.(*storage.Local); ok {
+		baseURL := local.BaseURL()
+		router.GET(baseURL+"/*key", gin.WrapH(http.StripPrefix(baseURL, local)))
+	}
+
+	if app.Environment == "test" {
+		// reset db handler for the test environment
+		router.POST("/db/user/reset", func(ctx *gin.Context) {
+			app.Databases.Get(db.Default).Delete(&models.User{})
+		})
+	}
+	return nil
+}
+
+// Addr of the server from the config
+func (app *App) Addr() string {
+	port := app.Config.Server.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	return fmt.Sprintf("%s:%d", app.Config.Server.Host, port)
+}
+
+// bootstrap write the one-time token which create the first administrator to stderr,
+// when it is enabled and there is no administrator
+func (app *App) bootstrap() error {
+	if !app.Config.Server.Bootstrap {
+		return nil
+	}
+	exists, err := seed.HasAdmin(repositories.NewGormStore(app.Databases.Primary(db.Default)))
+	if err != nil || exists {
+		return err
+	}
+
+	bootstrap, err := controllers.NewBootstrapController(app.routes, controllers.RequestStore(app.Databases))
+	if err != nil {
+		return err
+	}
+	bootstrap.LoadRoutes(app.Router)
+	// the token is kept out of the logs, which are often shipped and stored
+	app.Logger.WithField("header", controllers.BootstrapTokenHeader).
+		Warn("no administrator, create it with POST /bootstrap and the token written to stderr")
+	_, err = fmt.Fprintf(app.Stderr, "bootstrap token: %s\n", bootstrap.Token())
+	return err
+}
+
+// Close the databases and the rate limiter store opened by the app and flush its spans,
+// closing again does nothing
+func (app *App) Close() {
+	if app.ownDatabases && app.Databases != nil {
+		app.Databases.Close()
+		app.ownDatabases = false
+	}
+	if app.ownTracerProvider != nil {
+		if err := app.ownTracerProvider.Shutdown(context.Background()); err != nil {
+			app.Logger.WithError(err).Error("tracing shutdown failed")
+		}
+		app.ownTracerProvider = nil
+	}
+	if closer, ok := app.RateLimiter.(io.Closer); ok && app.ownRateLimiter {
+		closer.Close()
+		app.ownRateLimiter = false
+	}
+}
@@ -0,0 +1,526 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/controllers"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/ratelimit"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/seed"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestFS with the config of an in-memory database
+func newTestFS(t *testing.T) afero.Fs {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "config.toml", []byte(`
+[[db]]
+name = "default"
+type = "sqlite3"
+dsn = ":memory:"
+maxOpenConns = 1
+
+[storage]
+secret = "storage-secret"
+`), 0750))
+	return fs
+}
+
+func newTestApp(t *testing.T, usernames ...string) *App {
+	t.Helper()
+	app, err := New(WithFS(newTestFS(t)), WithoutGlobals())
+	require.NoError(t, err)
+
+	database := app.Databases.Get(db.Default)
+	require.NoError(t, database.AutoMigrate(&models.User{}).Error)
+	for _, username := range usernames {
+		require.NoError(t, database.Create(&models.User{Email: username + "@example.com", Username: username}).Error)
+	}
+	return app
+}
+
+func TestNew(t *testing.T) {
+	t.Run("missing config", func(t *testing.T) {
+		_, err := New(WithFS(afero.NewMemMapFs()))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		cnf := &config.Config{}
+		cnf.Log.Level = "loud"
+		_, err := New(WithConfig(cnf), WithDatabases(db.Current()))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `log: unknown level "loud"`)
+	})
+
+	t.Run("unknown storage", func(t *testing.T) {
+		cnf := &config.Config{}
+		cnf.Storage.Type = "unknown"
+		_, err := New(WithConfig(cnf), WithDatabases(db.Current()))
+		assert.Error(t, err)
+	})
+
+	t.Run("independent apps", func(t *testing.T) {
+		first := newTestApp(t, "alice")
+		defer first.Close()
+		second := newTestApp(t)
+		defer second.Close()
+
+		cases := []struct {
+			app          *App
+			expectedBody string
+		}{
+			{first, `{"status":"success","data":{"available":false}}`},
+			{second, `{"status":"success","data":{"available":true}}`},
+		}
+		for _, c := range cases {
+			request := httptest.NewRequest(http.MethodGet, "/user-availibility?context=username&value=alice", nil)
+			response := httptest.NewRecorder()
+			c.app.Router.ServeHTTP(response, request)
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.JSONEq(t, c.expectedBody, response.Body.String())
+		}
+	})
+}
+
+func TestGlobals(t *testing.T) {
+	previousConfig, previousDatabases, previousValidator := config.Get(), db.Current(), binding.Validator
+	defer func() {
+		config.Set(previousConfig)
+		db.Set(previousDatabases)
+		binding.Validator = previousValidator
+	}()
+
+	isolated := newTestApp(t)
+	defer isolated.Close()
+	assert.NotEqual(t, isolated.Config, config.Get())
+	assert.NotEqual(t, isolated.Databases, db.Current())
+
+	app, err := New(WithFS(newTestFS(t)))
+	require.NoError(t, err)
+	defer app.Close()
+	assert.Equal(t, app.Config, config.Get())
+	assert.Equal(t, app.Databases, db.Current())
+	assert.Equal(t, app.Databases.Get(db.Default), db.Get(db.Default))
+
+	// the handlers binding with gin know the tags of the routes
+	body := struct {
+		Username string `binding:"username"`
+	}{"abc"}
+	assert.Error(t, binding.Validator.ValidateStruct(&body))
+	body.Username = "alice"
+	assert.NoError(t, binding.Validator.ValidateStruct(&body))
+}
+
+func TestAddr(t *testing.T) {
+	cnf := &config.Config{}
+	cnf.Server.Host = "localhost"
+	app := &App{Config: cnf}
+	assert.Equal(t, "localhost:8080", app.Addr())
+
+	cnf.Server.Port = 3000
+	assert.Equal(t, "localhost:3000", app.Addr())
+}
+
+func TestBootstrap(t *testing.T) {
+	seed.BcryptCost = bcrypt.MinCost
+	app := newTestApp(t)
+	defer app.Close()
+	require.NoError(t, app.Databases.Get(db.Default).AutoMigrate(&models.UserRole{}).Error)
+	logs := &bytes.Buffer{}
+	app.Logger.Out = logs
+	app.Logger.Formatter = &logrus.JSONFormatter{}
+
+	// disabled by default
+	require.NoError(t, app.bootstrap())
+	assert.Empty(t, logs.String())
+
+	app.Config.Server.Bootstrap = true
+	stderr := &bytes.Buffer{}
+	app.Stderr = stderr
+	require.NoError(t, app.bootstrap())
+	require.True(t, strings.HasPrefix(stderr.String(), "bootstrap token: "))
+	token := strings.TrimSpace(strings.TrimPrefix(stderr.String(), "bootstrap token: "))
+	require.Len(t, token, 64)
+	assert.NotContains(t, logs.String(), token)
+	assert.Contains(t, logs.String(), controllers.BootstrapTokenHeader)
+
+	createAdmin := func(token string) int {
+		body := strings.NewReader(`{"email":"admin@example.com","username":"admin","password":"secret"}`)
+		request := httptest.NewRequest(http.MethodPost, "/bootstrap", body)
+		request.Header.Set(controllers.BootstrapTokenHeader, token)
+		response := httptest.NewRecorder()
+		app.Router.ServeHTTP(response, request)
+		return response.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, createAdmin("invalid"))
+	assert.Equal(t, http.StatusOK, createAdmin(token))
+	// the token is used once
+	assert.Equal(t, http.StatusUnauthorized, createAdmin(token))
+
+	exists, err := seed.HasAdmin(repositories.NewGormStore(app.Databases.Get(db.Default)))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestServe(t *testing.T) {
+	serve := func(t *testing.T, app *App) (string, context.CancelFunc, chan error) {
+		t.Helper()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- app.Serve(ctx, listener) }()
+		return "http://" + listener.Addr().String(), cancel, served
+	}
+	slowApp := func(t *testing.T, delay time.Duration) (*App, chan struct{}) {
+		t.Helper()
+		app := newTestApp(t)
+		app.Logger.Out = ioutil.Discard
+		started := make(chan struct{})
+		app.Router.GET("/slow", func(ctx *gin.Context) {
+			close(started)
+			time.Sleep(delay)
+			ctx.String(http.StatusOK, "done")
+		})
+		return app, started
+	}
+
+	t.Run("drain in-flight requests", func(t *testing.T) {
+		app, started := slowApp(t, 100*time.Millisecond)
+		url, cancel, served := serve(t, app)
+
+		responses := make(chan *http.Response, 1)
+		go func() {
+			response, err := http.Get(url + "/slow")
+			assert.NoError(t, err)
+			responses <- response
+		}()
+		<-started
+		assert.True(t, app.Ready())
+		cancel()
+
+		response := <-responses
+		require.NotNil(t, response)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		response.Body.Close()
+		assert.NoError(t, <-served)
+		assert.False(t, app.Ready())
+
+		// new connections are refused
+		_, err := http.Get(url + "/slow")
+		assert.Error(t, err)
+		// the databases are closed
+		assert.Error(t, app.Databases.Get(db.Default).DB().Ping())
+	})
+
+	t.Run("fail the readiness while draining", func(t *testing.T) {
+		app := newTestApp(t)
+		app.Logger.Out = ioutil.Discard
+		app.Config.Server.DrainDelay.Duration = 200 * time.Millisecond
+		url, cancel, served := serve(t, app)
+
+		response, err := http.Get(url + "/readyz")
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		cancel()
+		// the server is still serving during the drain delay
+		require.Eventually(t, func() bool { return !app.Ready() }, time.Second, time.Millisecond)
+		response, err = http.Get(url + "/readyz")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Contains(t, string(body), `"name":"shutdown"`)
+
+		assert.NoError(t, <-served)
+		_, err = http.Get(url + "/readyz")
+		assert.Error(t, err)
+	})
+
+	t.Run("force the shutdown", func(t *testing.T) {
+		app, started := slowApp(t, time.Second)
+		app.Config.Server.DrainDelay.Duration = time.Minute
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		force, forceCancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- app.serve(ctx, force, listener) }()
+
+		go http.Get("http://" + listener.Addr().String() + "/slow")
+		<-started
+		cancel()
+		require.Eventually(t, func() bool { return !app.Ready() }, time.Second, time.Millisecond)
+		// neither the drain delay nor the in-flight request is waited
+		start := time.Now()
+		forceCancel()
+		assert.Equal(t, context.Canceled, <-served)
+		assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	})
+
+	t.Run("cut off after the timeout", func(t *testing.T) {
+		app, started := slowApp(t, time.Second)
+		app.Config.Server.ShutdownTimeout.Duration = 10 * time.Millisecond
+		url, cancel, served := serve(t, app)
+
+		go http.Get(url + "/slow")
+		<-started
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, <-served)
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	app := newTestApp(t)
+	defer app.Close()
+
+	readyz := func() (int, string) {
+		response := httptest.NewRecorder()
+		app.Router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return response.Code, response.Body.String()
+	}
+	code, body := readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"db:default"`)
+	assert.Contains(t, body, `"name":"shutdown"`)
+
+	// readiness fails as soon as the shutdown starts
+	app.shuttingDown = 1
+	code, body = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, `"name":"shutdown","status":"down"`)
+}
+
+func TestReadyzRateLimiter(t *testing.T) {
+	readyz := func(app *App) (int, string) {
+		response := httptest.NewRecorder()
+		app.Router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return response.Code, response.Body.String()
+	}
+
+	t.Run("memory store", func(t *testing.T) {
+		app := newTestApp(t)
+		defer app.Close()
+		_, body := readyz(app)
+		assert.NotContains(t, body, `"name":"ratelimit"`)
+	})
+
+	t.Run("redis outage", func(t *testing.T) {
+		store := ratelimit.NewRedisStore(ratelimit.RedisConfig{Addr: "127.0.0.1:1"})
+		defer store.Close()
+		app, err := New(WithFS(newTestFS(t)), WithoutGlobals(), WithRateLimiter(store))
+		require.NoError(t, err)
+		defer app.Close()
+		app.Logger.Out = ioutil.Discard
+
+		code, body := readyz(app)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, `"name":"ratelimit","status":"down"`)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	fs := newTestFS(t)
+	cnf, err := afero.ReadFile(fs, "config.toml")
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, "config.toml", append(cnf, "[metrics]\nenabled = true\n"...), 0750))
+	app, err := New(WithFS(fs))
+	require.NoError(t, err)
+	defer app.Close()
+
+	get := func(url string) (int, string) {
+		response := httptest.NewRecorder()
+		app.Router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, url, nil))
+		return response.Code, response.Body.String()
+	}
+	get("/healthz")
+	code, body := get("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/healthz",status="200"} 1`)
+	assert.Contains(t, body, `db_pool_open_connections{db="default",replica="primary"}`)
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	app, err := New(WithFS(newTestFS(t)), WithTracerProvider(provider))
+	require.NoError(t, err)
+	defer app.Close()
+	app.Logger.Out = ioutil.Discard
+
+	database := app.Databases.Get(db.Default)
+	require.NoError(t, database.AutoMigrate(&models.UserRole{}, &models.User{}).Error)
+	role := &models.UserRole{Name: "administrator", Enabled: true}
+	require.NoError(t, database.Create(role).Error)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, database.Create(&models.User{
+		Email: "admin@example.com", Username: "admin", Password: string(hashedPassword), RoleID: role.ID, Enabled: true,
+	}).Error)
+
+	response := httptest.NewRecorder()
+	app.Router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/auth/login",
+		strings.NewReader(`{"username":"admin","password":"secret"}`)))
+	require.Equal(t, http.StatusOK, response.Code)
+	tokens := struct{ AccessToken string }{}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &tokens))
+	exporter.Reset()
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	request.Header.Set(controllers.AccessTokenHeader, tokens.AccessToken)
+	response = httptest.NewRecorder()
+	app.Router.ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String(), span.Name)
+		spans[span.Name] = span
+	}
+	server, ok := spans["GET /users/:id"]
+	require.True(t, ok, "the server span is named by the route")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Contains(t, server.Attributes, semconv.HTTPStatusCodeKey.Int(http.StatusOK))
+
+	auth, ok := spans["auth.roles"]
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext.SpanID(), auth.Parent.SpanID())
+	assert.Contains(t, auth.Attributes, semconv.EnduserIDKey.String("1"))
+
+	// the role is queried by the auth span and the user by the handler
+	queries := map[trace.SpanID]int{}
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "gorm.query" {
+			queries[span.Parent.SpanID()]++
+		}
+	}
+	assert.Len(t, queries, 2)
+	assert.Equal(t, 1, queries[auth.SpanContext.SpanID()])
+	assert.NotZero(t, queries[server.SpanContext.SpanID()])
+}
+
+func TestCORS(t *testing.T) {
+	fs := newTestFS(t)
+	file, err := fs.OpenFile("config.toml", os.O_APPEND|os.O_WRONLY, 0750)
+	require.NoError(t, err)
+	_, err = file.WriteString(`
+[cors]
+allowOrigins = ["http://localhost:8080"]
+
+[cors.profiles.production]
+allowOrigins = ["https://*.example.com"]
+exposeHeaders = ["X-Request-ID"]
+allowCredentials = true
+maxAge = "1h"
+`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	preflight := func(app *App, origin string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodOptions, "/auth/login", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		response := httptest.NewRecorder()
+		app.Router.ServeHTTP(response, request)
+		return response
+	}
+
+	development, err := New(WithFS(fs), WithEnvironment("development"))
+	require.NoError(t, err)
+	defer development.Close()
+	development.Logger.Out = ioutil.Discard
+	response := preflight(development, "http://localhost:8080")
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, "http://localhost:8080", response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "43200", response.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, response.Header().Get("Access-Control-Allow-Headers"), controllers.AccessTokenHeader)
+	assert.Equal(t, http.StatusForbidden, preflight(development, "https://app.example.com").Code)
+
+	production, err := New(WithFS(fs), WithEnvironment("production"))
+	require.NoError(t, err)
+	defer production.Close()
+	production.Logger.Out = ioutil.Discard
+	response = preflight(production, "https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, "https://app.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", response.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "3600", response.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, http.StatusForbidden, preflight(production, "http://localhost:8080").Code)
+
+	// the scripts of the browsers read the renewed tokens
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	response = httptest.NewRecorder()
+	production.Router.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+	exposed := response.Header().Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"X-Request-ID", controllers.AccessTokenHeader, controllers.RefreshTokenHeader} {
+		assert.Contains(t, exposed, http.CanonicalHeaderKey(header))
+	}
+
+	// the cross-origin requests are denied without origin
+	none, err := New(WithFS(newTestFS(t)))
+	require.NoError(t, err)
+	defer none.Close()
+	none.Logger.Out = ioutil.Discard
+	response = preflight(none, "http://localhost:8080")
+	assert.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestOriginMatcher(t *testing.T) {
+	match := originMatcher([]string{"http://localhost:8080", "https://*.example.com"})
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://localhost:8080", true},
+		{"http://localhost:3000", false},
+		{"https://app.example.com", true},
+		{"https://A.B.Example.com", true},
+		{"https://example.com", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://evil.com/.example.com", false},
+		{"https://evilexample.com", false},
+		{"https://.example.com", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, match(c.origin), c.origin)
+	}
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"strings"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/controllers"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCORSMaxAge = 12 * time.Hour
+
+// corsMiddleware of the policy, it is nil when the policy allows no origin
+func corsMiddleware(policy config.CORSPolicy) gin.HandlerFunc {
+	if len(policy.AllowOrigins) == 0 {
+		return nil
+	}
+
+	corsConfig := cors.DefaultConfig()
+	if len(policy.AllowMethods) > 0 {
+		corsConfig.AllowMethods = policy.AllowMethods
+	}
+	if len(policy.AllowHeaders) > 0 {
+		corsConfig.AllowHeaders = policy.AllowHeaders
+	}
+	corsConfig.AddAllowHeaders(controllers.AccessTokenHeader, controllers.RefreshTokenHeader)
+	// the browsers only let the scripts read the exposed headers, the tokens are renewed with the headers
+	corsConfig.ExposeHeaders = policy.ExposeHeaders
+	corsConfig.AddExposeHeaders(controllers.AccessTokenHeader, controllers.RefreshTokenHeader)
+	corsConfig.AllowCredentials = policy.AllowCredentials
+	corsConfig.MaxAge = policy.MaxAge.Duration
+	if corsConfig.MaxAge == 0 {
+		corsConfig.MaxAge = defaultCORSMaxAge
+	}
+
+	for _, origin := range policy.AllowOrigins {
+		if origin == "*" {
+			corsConfig.AllowAllOrigins = true
+			return cors.New(corsConfig)
+		}
+	}
+	corsConfig.AllowOriginFunc = originMatcher(policy.AllowOrigins)
+	return cors.New(corsConfig)
+}
+
+// originMatcher of the allowed origins, "https://*.example.com" match the subdomains of example.com
+// with the same scheme and port but not example.com itself
+func originMatcher(patterns []string) func(origin string) bool {
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(pattern)
+			if origin == pattern {
+				return true
+			}
+			wildcard := strings.Index(pattern, "://*.")
+			if wildcard < 0 {
+				continue
+			}
+			prefix, suffix := pattern[:wildcard+len("://")], pattern[wildcard+len("://*"):]
+			if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+				continue
+			}
+			if subdomain := origin[len(prefix) : len(origin)-len(suffix)]; validSubdomain(subdomain) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// validSubdomain is true when the labels are letters, digits and hyphens
+func validSubdomain(subdomain string) bool {
+	if subdomain == "" {
+		return false
+	}
+	for _, label := range strings.Split(subdomain, ".") {
+		if label == "" {
+			return false
+		}
+		for _, char := range label {
+			if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
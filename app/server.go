@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// Run the server on Addr until SIGINT or SIGTERM, then shut it down gracefully,
+// a second signal skips the drain delay and cuts off the in-flight requests
+func (app *App) Run() error {
+	listener, err := net.Listen("tcp", app.Addr())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	force, forceCancel := context.WithCancel(context.Background())
+	defer forceCancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			app.Logger.WithField("signal", sig.String()).Info("shutting down")
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case sig := <-signals:
+			app.Logger.WithField("signal", sig.String()).Warn("forcing the shutdown")
+			forceCancel()
+		case <-force.Done():
+		}
+	}()
+
+	return app.serve(ctx, force, listener)
+}
+
+// Serve the router on the listener until ctx is done. Then the app stops being ready,
+// the server keeps serving for the drain delay, the listener is closed, the in-flight requests are waited until the shutdown timeout
+// and the databases of the app are closed
+func (app *App) Serve(ctx context.Context, listener net.Listener) error {
+	return app.serve(ctx, context.Background(), listener)
+}
+
+// serve is Serve which shut down immediately when force is done,
+// the drain delay is skipped and the in-flight requests are cut off
+func (app *App) serve(ctx, force context.Context, listener net.Listener) error {
+	if err := app.bootstrap(); err != nil {
+		listener.Close()
+		return err
+	}
+
+	// the errors of the connections are logged by the logger of the app
+	errorLog := app.Logger.WriterLevel(logrus.ErrorLevel)
+	defer errorLog.Close()
+	server := app.newServer(errorLog)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	atomic.StoreInt32(&app.shuttingDown, 1)
+	if delay := app.Config.Server.DrainDelay.Duration; delay > 0 {
+		// /readyz fails while the server keeps serving, the load balancer stops routing meanwhile
+		app.Logger.WithField("delay", delay.String()).Info("draining before the shutdown")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-force.Done():
+			timer.Stop()
+		}
+	}
+	timeout := app.Config.Server.ShutdownTimeout.Duration
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(force, timeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// the requests which are still running are cut off
+		app.Logger.WithError(err).Warn("shutdown is cut off, closing the remaining connections")
+		server.Close()
+	}
+	<-served
+	app.Close()
+	return err
+}
+
+// Ready is false once the shutdown starts
+func (app *App) Ready() bool {
+	return atomic.LoadInt32(&app.shuttingDown) == 0
+}
+
+func (app *App) newServer(errorLog io.Writer) *http.Server {
+	cnf := app.Config.Server
+	return &http.Server{
+		Addr:         app.Addr(),
+		Handler:      app.Router,
+		ReadTimeout:  cnf.ReadTimeout.Duration,
+		WriteTimeout: cnf.WriteTimeout.Duration,
+		IdleTimeout:  cnf.IdleTimeout.Duration,
+		ErrorLog:     log.New(errorLog, "", 0),
+	}
+}
@@ -0,0 +1,245 @@
+// Package cli is the command line of the application,
+// the commands run in the calling process so they are tested without spawning one
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/frullah/gin-boilerplate/app"
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/logging"
+	"github.com/logrusorgru/aurora"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	defaultConfigPath = "config.toml"
+	// productionEnvironment refuse the fixtures which are not meant for production
+	productionEnvironment = "production"
+)
+
+// Command of the command line, a command runs or dispatches to its subcommands
+type Command struct {
+	Name string
+	// Usage of the arguments
+	Usage   string
+	Summary string
+	// Run the command, the flags of the command are defined on the flag set then parsed from args
+	Run func(env *Env, flags *flag.FlagSet, args []string) error
+
+	Subcommands []*Command
+}
+
+// Env of the commands, the streams and the file system are replaced in the tests
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	FS     afero.Fs
+
+	// ConfigPath of the config file in FS
+	ConfigPath string
+	// Environment of the app and the fixtures
+	Environment string
+	// Options of the app, added after the options of the env
+	Options []app.Option
+
+	app *app.App
+}
+
+// NewEnv of the process
+func NewEnv() *Env {
+	return &Env{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		FS:     afero.NewOsFs(),
+	}
+}
+
+// Config loaded from ConfigPath, it is installed into the global config like config.Init does,
+// the app of the env installs its databases into the global databases like db.Init does
+func (env *Env) Config() (*config.Config, error) {
+	cnf, err := config.LoadFile(env.FS, env.configPath())
+	if err != nil {
+		return nil, err
+	}
+	config.Set(cnf)
+	return cnf, nil
+}
+
+// App of the config, it is created on the first call and shared by the later ones,
+// so the options are used once
+func (env *Env) App(options ...app.Option) (*app.App, error) {
+	if env.app != nil {
+		return env.app, nil
+	}
+
+	cnf, err := env.Config()
+	if err != nil {
+		return nil, err
+	}
+	logger, err := logging.New(cnf.Log, env.Environment, env.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	appOptions := []app.Option{
+		app.WithConfig(cnf),
+		app.WithFS(env.FS),
+		app.WithEnvironment(env.Environment),
+		app.WithLogger(logger),
+		app.WithStderr(env.Stderr),
+	}
+	appOptions = append(appOptions, env.Options...)
+	env.app, err = app.New(append(appOptions, options...)...)
+	return env.app, err
+}
+
+// Close the app of the env
+func (env *Env) Close() {
+	if env.app != nil {
+		env.app.Close()
+		env.app = nil
+	}
+}
+
+func (env *Env) configPath() string {
+	if env.ConfigPath == "" {
+		return defaultConfigPath
+	}
+	return env.ConfigPath
+}
+
+// Commands of the command line
+func Commands() []*Command {
+	return []*Command{
+		serveCommand,
+		migrateCommand,
+		seedCommand,
+		createAdminCommand,
+		routesCommand,
+		configCommand,
+		userCommand,
+		tokenCommand,
+	}
+}
+
+// Main run the command line of the process, it returns the exit code
+func Main(args []string) int {
+	env := NewEnv()
+	defer env.Close()
+
+	if err := Run(env, args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(env.Stderr, aurora.Red(err))
+		}
+		return 1
+	}
+	return 0
+}
+
+// Run the command of the arguments with the global flags, "serve" when there is no command
+func Run(env *Env, args []string) error {
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	flags.SetOutput(env.Stderr)
+	flags.StringVar(&env.ConfigPath, "config", env.configPath(), "config file path")
+	flags.StringVar(&env.Environment, "env", defaultEnvironment(), "environment of the app")
+	flags.Usage = func() {
+		fmt.Fprintln(env.Stderr, "usage: app [-config path] [-env name] <command>")
+		fmt.Fprintln(env.Stderr)
+		printCommands(env.Stderr, Commands())
+		fmt.Fprintln(env.Stderr)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{serveCommand.Name}
+	}
+	return dispatch(env, &Command{Name: "app", Subcommands: Commands()}, args)
+}
+
+func dispatch(env *Env, command *Command, args []string) error {
+	if command.Run != nil {
+		return command.Run(env, newFlagSet(env, command), args)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintf(env.Stderr, "usage: %s <command>\n\n", command.Name)
+		printCommands(env.Stderr, command.Subcommands)
+		return fmt.Errorf("%s: missing command", command.Name)
+	}
+	for _, subcommand := range command.Subcommands {
+		if subcommand.Name == args[0] {
+			return dispatch(env, subcommand, args[1:])
+		}
+	}
+	printCommands(env.Stderr, command.Subcommands)
+	return fmt.Errorf("%s: unknown command %q", command.Name, args[0])
+}
+
+func printCommands(w io.Writer, commands []*Command) {
+	fmt.Fprintln(w, "commands:")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", command.Name, command.Summary)
+	}
+}
+
+// newFlagSet of the command, the usage is printed to the stderr of the env
+func newFlagSet(env *Env, command *Command) *flag.FlagSet {
+	flags := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	flags.SetOutput(env.Stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(env.Stderr, "usage: %s %s\n\n%s\n", command.Name, command.Usage, command.Summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// defaultEnvironment from APP_ENV
+func defaultEnvironment() string {
+	if env := os.Getenv("APP_ENV"); env != "" {
+		return env
+	}
+	return "development"
+}
+
+// readPassword from the environment variable, the terminal without echo, or the first line of stdin
+func readPassword(env *Env, variable string) (string, error) {
+	if password := os.Getenv(variable); password != "" {
+		return password, nil
+	}
+
+	if file, ok := env.Stdin.(*os.File); ok && terminal.IsTerminal(int(file.Fd())) {
+		fmt.Fprint(env.Stderr, "password: ")
+		password, err := terminal.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(env.Stderr)
+		return string(password), err
+	}
+
+	// read byte by byte so the next reads start at the next line
+	line := ""
+	buffer := make([]byte, 1)
+	for {
+		n, err := env.Stdin.Read(buffer)
+		if n > 0 && buffer[0] == '\n' {
+			break
+		}
+		line += string(buffer[:n])
+		if err == io.EOF && line != "" {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading the password: %v", err)
+		}
+	}
+	return strings.TrimRight(line, "\r"), nil
+}
@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/seed"
+	"github.com/gin-gonic/gin"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	seed.BcryptCost = bcrypt.MinCost
+}
+
+const testConfig = `
+[[db]]
+name = "default"
+type = "sqlite3"
+dsn = ":memory:"
+maxOpenConns = 1
+
+[storage]
+secret = "storage-secret"
+`
+
+// testEnv with the config in the memory file system, the commands of the env share one database
+func testEnv(t *testing.T) (*Env, *bytes.Buffer) {
+	t.Helper()
+	fileSystem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fileSystem, "configs/test.toml", []byte(testConfig), 0644))
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/roles.yaml", []byte(`
+roles:
+  - name: member
+    enabled: true
+    default: true
+`), 0644))
+
+	stdout := &bytes.Buffer{}
+	return &Env{
+		Stdin:  strings.NewReader(""),
+		Stdout: stdout,
+		Stderr: &bytes.Buffer{},
+		FS:     fileSystem,
+	}, stdout
+}
+
+func run(t *testing.T, env *Env, stdin string, args ...string) (string, error) {
+	t.Helper()
+	stdout := env.Stdout.(*bytes.Buffer)
+	stdout.Reset()
+	env.Stdin = strings.NewReader(stdin)
+	err := Run(env, append([]string{"-config", "configs/test.toml", "-env", "test"}, args...))
+	return stdout.String(), err
+}
+
+func TestRun(t *testing.T) {
+	env, _ := testEnv(t)
+	defer env.Close()
+
+	output, err := run(t, env, "", "config", "validate")
+	require.NoError(t, err)
+	assert.Equal(t, "configs/test.toml is valid\n", output)
+
+	require.NoError(t, afero.WriteFile(env.FS, "db/migrations/default/0004_create_audit.up.sql",
+		[]byte("CREATE TABLE audit (id INTEGER PRIMARY KEY);"), 0644))
+	output, err = run(t, env, "", "migrate", "-dir", "db/migrations", "up")
+	require.NoError(t, err)
+	assert.Contains(t, output, "applied 1_create_users")
+	assert.Contains(t, output, "applied 4_create_audit")
+
+	output, err = run(t, env, "", "seed")
+	require.NoError(t, err)
+	assert.Equal(t, "seeded test fixtures: 1 created, 0 updated, 0 unchanged\n", output)
+
+	output, err = run(t, env, "secret\n", "create-admin", "-email", "admin@example.com", "-username", "admin")
+	require.NoError(t, err)
+	assert.Contains(t, output, "created administrator admin")
+	_, err = run(t, env, "secret\n", "create-admin", "-email", "root@example.com", "-username", "superuser")
+	assert.Error(t, err)
+
+	output, err = run(t, env, "password\n", "user", "create", "-email", "alice@example.com", "-username", "alice")
+	require.NoError(t, err)
+	assert.Contains(t, output, "role member")
+	_, err = run(t, env, "password\n", "user", "create", "-email", "bob@example.com", "-username", "bobby", "-role", "missing")
+	assert.Error(t, err)
+
+	output, err = run(t, env, "changed\n", "user", "reset-password", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "reset the password of user alice\n", output)
+	_, err = run(t, env, "", "user", "disable", "alice")
+	require.NoError(t, err)
+	_, err = run(t, env, "", "user", "disable", "nobody")
+	assert.Error(t, err)
+
+	// the commands run with the global config and databases initialized
+	application, err := env.App()
+	require.NoError(t, err)
+	assert.Equal(t, application.Config, config.Get())
+	assert.Equal(t, application.Databases, db.Current())
+	alice, err := repositories.NewGormStore(application.Databases.Get(db.Default)).Users().GetByUsername("alice")
+	require.NoError(t, err)
+	assert.False(t, alice.Enabled)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(alice.Password), []byte("changed")))
+}
+
+func TestRunErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"unknown command", []string{"unknown"}},
+		{"missing subcommand", []string{"user"}},
+		{"unknown subcommand", []string{"config", "check"}},
+		{"unknown flag", []string{"seed", "-unknown"}},
+		{"missing token", []string{"token", "inspect"}},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			env, _ := testEnv(t)
+			defer env.Close()
+			_, err := run(t, env, "", testCase.args...)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("missing config", func(t *testing.T) {
+		env, _ := testEnv(t)
+		assert.Error(t, Run(env, []string{"-config", "missing.toml", "config", "validate"}))
+	})
+}
+
+func TestSeedProduction(t *testing.T) {
+	env, _ := testEnv(t)
+	defer env.Close()
+	_, err := run(t, env, "", "migrate", "up")
+	require.NoError(t, err)
+	seedProduction := func(args ...string) error {
+		return Run(env, append([]string{"-config", "configs/test.toml", "-env", "production", "seed"}, args...))
+	}
+
+	assert.EqualError(t, seedProduction("-env", "development"), "seed: the development fixtures are refused in production")
+
+	require.NoError(t, afero.WriteFile(env.FS, "fixtures/production/users.yaml", []byte(`
+users:
+  - email: admin@example.com
+    username: admin
+    password: administrator
+    role: member
+`), 0644))
+	assert.Error(t, seedProduction())
+
+	require.NoError(t, afero.WriteFile(env.FS, "fixtures/production/users.yaml", []byte(`
+users:
+  - email: admin@example.com
+    username: admin
+    passwordEnv: CLI_TEST_ADMIN_PASSWORD
+    role: member
+`), 0644))
+	os.Setenv("CLI_TEST_ADMIN_PASSWORD", "secret")
+	defer os.Unsetenv("CLI_TEST_ADMIN_PASSWORD")
+	assert.NoError(t, seedProduction())
+}
+
+func TestRoutes(t *testing.T) {
+	env, _ := testEnv(t)
+	defer env.Close()
+
+	output, err := run(t, env, "", "routes")
+	require.NoError(t, err)
+	assert.Contains(t, output, "POST    /auth/login")
+	assert.Contains(t, output, "GET     /users/:id")
+}
+
+func TestTokenInspect(t *testing.T) {
+	env, _ := testEnv(t)
+
+	// the valid tokens are covered by the tests of controllers.InspectToken
+	output, err := run(t, env, "", "token", "inspect", "invalid")
+	assert.Error(t, err)
+	assert.Empty(t, output)
+}
+
+func TestReadPassword(t *testing.T) {
+	env, _ := testEnv(t)
+	env.Stdin = strings.NewReader("first\r\nsecond")
+
+	password, err := readPassword(env, "TEST_PASSWORD")
+	require.NoError(t, err)
+	assert.Equal(t, "first", password)
+	password, err = readPassword(env, "TEST_PASSWORD")
+	require.NoError(t, err)
+	assert.Equal(t, "second", password)
+	_, err = readPassword(env, "TEST_PASSWORD")
+	assert.Error(t, err)
+
+	os.Setenv("TEST_PASSWORD", "variable")
+	defer os.Unsetenv("TEST_PASSWORD")
+	password, err = readPassword(env, "TEST_PASSWORD")
+	require.NoError(t, err)
+	assert.Equal(t, "variable", password)
+}
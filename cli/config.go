@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+)
+
+var configCommand = &Command{
+	Name:    "config",
+	Summary: "check the config",
+	Subcommands: []*Command{
+		{
+			Name:    "validate",
+			Summary: "load the config and validate its values",
+			Run:     runConfigValidate,
+		},
+	},
+}
+
+// runConfigValidate the "config validate" command
+func runConfigValidate(env *Env, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cnf, err := env.Config()
+	if err != nil {
+		return err
+	}
+	if err := cnf.Validate(); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "%s is valid\n", env.configPath())
+	return nil
+}
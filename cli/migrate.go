@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/frullah/gin-boilerplate/migrate"
+	_ "github.com/frullah/gin-boilerplate/migrations"
+)
+
+var migrateCommand = &Command{
+	Name:  "migrate",
+	Usage: "[-db name] [-dir path] <up|down [n]|status|redo>",
+	Summary: `run the Go migrations and the SQL migrations of "<dir>/<db name>"
+  up        apply every pending migration
+  down [n]  revert the last n applied migrations, 1 by default
+  status    show the migrations status
+  redo      revert and apply the last applied migration`,
+	Run: runMigrate,
+}
+
+// runMigrate the "migrate" command on the databases of the app
+func runMigrate(env *Env, flags *flag.FlagSet, args []string) error {
+	instanceName := flags.String("db", "default", "database instance name")
+	dir := flags.String("dir", migrate.SQLDir, "SQL migrations directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	application, err := env.App()
+	if err != nil {
+		return err
+	}
+	databases := application.Databases
+	instance, ok := databases.Lookup(*instanceName)
+	if !ok || databases.Primary(instance) == nil {
+		return fmt.Errorf("unknown database instance %q", *instanceName)
+	}
+	migrator, err := migrate.ForInstance(application.FS, *dir, databases, instance)
+	if err != nil {
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Fprintf(env.Stdout, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(env.Stdout, "no pending migration")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(env.Stdout, "reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Missing {
+				state = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05") + ", missing"
+			} else if status.Applied {
+				state = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(env.Stdout, "%d_%s\t%s\n", status.Version, status.Name, state)
+		}
+		return nil
+	case "redo":
+		redone, err := migrator.Redo()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.Stdout, "redone %d_%s\n", redone.Version, redone.Name)
+		return nil
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/frullah/gin-boilerplate/app"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/gin-gonic/gin"
+)
+
+var routesCommand = &Command{
+	Name:    "routes",
+	Summary: "list the HTTP routes, the databases are not opened",
+	Run:     runRoutes,
+}
+
+// runRoutes the "routes" command
+func runRoutes(env *Env, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// the routes don't query while they are loaded
+	application, err := env.App(app.WithDatabases(&db.Databases{}), app.WithRouter(gin.New()))
+	if err != nil {
+		return err
+	}
+
+	routes := application.Router.Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	w := tabwriter.NewWriter(env.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", route.Method, route.Path, route.Handler)
+	}
+	return w.Flush()
+}
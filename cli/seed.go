@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/seed"
+)
+
+var seedCommand = &Command{
+	Name:  "seed",
+	Usage: "[-dir path] [-env name]",
+	Summary: `upsert the fixtures of the directory then the fixtures of "<dir>/<env>",
+the fixtures are YAML or JSON files of roles and users, in production only the
+production fixtures without a password in plain text are seeded`,
+	Run: runSeed,
+}
+
+var createAdminCommand = &Command{
+	Name:  "create-admin",
+	Usage: "-email address -username name [-name name] [-force]",
+	Summary: `create the administrator role when it is missing and the first administrator,
+the password is read from ADMIN_PASSWORD or prompted`,
+	Run: runCreateAdmin,
+}
+
+// runSeed the "seed" command on the default database of the app
+func runSeed(env *Env, flags *flag.FlagSet, args []string) error {
+	dir := flags.String("dir", seed.Dir, "fixtures directory")
+	fixturesEnv := flags.String("env", env.Environment, "environment of the fixtures")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	production := env.Environment == productionEnvironment
+	if production && *fixturesEnv != productionEnvironment {
+		return fmt.Errorf("seed: the %s fixtures are refused in production", *fixturesEnv)
+	}
+
+	fixtures, err := seed.Load(env.FS, *dir, *fixturesEnv)
+	if err != nil {
+		return err
+	}
+	if production {
+		if err := fixtures.CheckProduction(); err != nil {
+			return err
+		}
+	}
+	store, err := defaultStore(env)
+	if err != nil {
+		return err
+	}
+	result, err := seed.Apply(store, fixtures)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "seeded %s fixtures: %d created, %d updated, %d unchanged\n",
+		*fixturesEnv, result.Created, result.Updated, result.Unchanged)
+	return nil
+}
+
+// runCreateAdmin the "create-admin" command on the default database of the app
+func runCreateAdmin(env *Env, flags *flag.FlagSet, args []string) error {
+	admin := seed.Admin{}
+	flags.StringVar(&admin.Email, "email", "", "email of the administrator")
+	flags.StringVar(&admin.Username, "username", "", "username of the administrator")
+	flags.StringVar(&admin.Name, "name", "", "name of the administrator")
+	force := flags.Bool("force", false, "create the administrator even when one exists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := readPassword(env, "ADMIN_PASSWORD")
+	if err != nil {
+		return err
+	}
+	admin.Password = password
+
+	store, err := defaultStore(env)
+	if err != nil {
+		return err
+	}
+	user, err := seed.CreateAdmin(store, admin, *force)
+	if err == seed.ErrAdminExists {
+		return fmt.Errorf("%v, use -force to create another one", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "created administrator %s with id %d\n", user.Username, *user.ID)
+	return nil
+}
+
+// defaultStore of the primary of the default database
+func defaultStore(env *Env) (repositories.Store, error) {
+	application, err := env.App()
+	if err != nil {
+		return nil, err
+	}
+	return repositories.NewGormStore(application.Databases.Primary(db.Default)), nil
+}
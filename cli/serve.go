@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	_ "github.com/frullah/gin-boilerplate/docs"
+	"github.com/gin-gonic/gin"
+	"github.com/logrusorgru/aurora"
+	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/swaggo/gin-swagger/swaggerFiles"
+)
+
+var serveCommand = &Command{
+	Name:    "serve",
+	Summary: "run the HTTP server, the default command",
+	Run:     runServe,
+}
+
+// runServe the "serve" command
+func runServe(env *Env, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(env.Stdout, "Initializing server...")
+	gin.SetMode(gin.ReleaseMode)
+	application, err := env.App()
+	if err != nil {
+		return err
+	}
+	if application.Environment == "test" {
+		fmt.Fprintln(env.Stdout, aurora.Yellow("Running in testing mode"))
+	}
+
+	host := application.Addr()
+	swaggerURL := ginSwagger.URL(fmt.Sprintf("http://%s/swagger/doc.json", host))
+	swaggerHandler := ginSwagger.WrapHandler(swaggerFiles.Handler, swaggerURL)
+	application.Router.GET("/swagger/*any", swaggerHandler)
+
+	fmt.Fprintln(env.Stdout, aurora.BrightGreen("Server initialized!"))
+	fmt.Fprintln(env.Stdout, "Server running on", aurora.BrightBlue(host))
+	fmt.Fprintln(env.Stdout)
+
+	return application.Run()
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/controllers"
+)
+
+var tokenCommand = &Command{
+	Name:    "token",
+	Summary: "inspect the authentication tokens",
+	Subcommands: []*Command{
+		{
+			Name:    "inspect",
+			Usage:   "<token>",
+			Summary: "decode an access or a refresh token and check its signature and expiry",
+			Run:     runTokenInspect,
+		},
+	},
+}
+
+// runTokenInspect the "token inspect" command
+func runTokenInspect(env *Env, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("missing token")
+	}
+
+	info, err := controllers.InspectToken(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	status := "valid"
+	if info.Err != nil {
+		status = "invalid: " + info.Err.Error()
+	}
+	fmt.Fprintf(env.Stdout, "type:    %s\nuser id: %d\nissued:  %s\nexpires: %s\nstatus:  %s\n",
+		info.Type, info.UserID,
+		info.IssuedAt.Format(time.RFC3339), info.ExpiresAt.Format(time.RFC3339),
+		status)
+	return nil
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/seed"
+)
+
+var userCommand = &Command{
+	Name:    "user",
+	Summary: "manage the users",
+	Subcommands: []*Command{
+		{
+			Name:  "create",
+			Usage: "-email address -username name [-name name] [-role name] [-disabled]",
+			Summary: `create a user, the password is read from USER_PASSWORD or prompted,
+the default role is used when the role is empty`,
+			Run: runUserCreate,
+		},
+		{
+			Name:    "disable",
+			Usage:   "<username>",
+			Summary: "disable the user, the user can't login anymore",
+			Run:     runUserDisable,
+		},
+		{
+			Name:    "reset-password",
+			Usage:   "<username>",
+			Summary: "set the password of the user, it is read from USER_PASSWORD or prompted",
+			Run:     runUserResetPassword,
+		},
+	},
+}
+
+// runUserCreate the "user create" command
+func runUserCreate(env *Env, flags *flag.FlagSet, args []string) error {
+	admin := seed.Admin{}
+	flags.StringVar(&admin.Email, "email", "", "email of the user")
+	flags.StringVar(&admin.Username, "username", "", "username of the user")
+	flags.StringVar(&admin.Name, "name", "", "name of the user")
+	roleName := flags.String("role", "", "role name of the user")
+	disabled := flags.Bool("disabled", false, "create the user disabled")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := readPassword(env, "USER_PASSWORD")
+	if err != nil {
+		return err
+	}
+	// the user is validated like the admin
+	admin.Password = password
+	if err := admin.Validate(); err != nil {
+		return err
+	}
+
+	store, err := defaultStore(env)
+	if err != nil {
+		return err
+	}
+	role, err := store.Roles().GetDefault()
+	if *roleName != "" {
+		role, err = store.Roles().GetByName(*roleName)
+	}
+	if err == repositories.ErrNotFound {
+		return fmt.Errorf("unknown role %q", *roleName)
+	}
+	if err != nil {
+		return err
+	}
+
+	hashed, err := seed.HashPassword(admin.Password)
+	if err != nil {
+		return err
+	}
+	user := &models.User{
+		Email:    admin.Email,
+		Username: admin.Username,
+		Password: hashed,
+		Name:     admin.Name,
+		RoleID:   role.ID,
+		Enabled:  !*disabled,
+		Verified: true,
+	}
+	if err := store.Users().Create(user); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "created user %s with id %d and role %s\n", user.Username, *user.ID, role.Name)
+	return nil
+}
+
+// runUserDisable the "user disable" command
+func runUserDisable(env *Env, flags *flag.FlagSet, args []string) error {
+	store, user, err := userOfArgs(env, flags, args)
+	if err != nil {
+		return err
+	}
+	if err := store.Users().SetEnabled(*user.ID, false); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "disabled user %s\n", user.Username)
+	return nil
+}
+
+// runUserResetPassword the "user reset-password" command
+func runUserResetPassword(env *Env, flags *flag.FlagSet, args []string) error {
+	store, user, err := userOfArgs(env, flags, args)
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(env, "USER_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if len(password) < 5 || len(password) > 64 {
+		return errors.New("the password must have 5 to 64 characters")
+	}
+	hashed, err := seed.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := store.Users().Update(&models.User{ID: user.ID, Password: hashed}); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.Stdout, "reset the password of user %s\n", user.Username)
+	return nil
+}
+
+// userOfArgs find the user by the username argument
+func userOfArgs(env *Env, flags *flag.FlagSet, args []string) (repositories.Store, *models.User, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return nil, nil, errors.New("missing username")
+	}
+
+	store, err := defaultStore(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := store.Users().GetByUsername(flags.Arg(0))
+	if err == repositories.ErrNotFound {
+		return nil, nil, fmt.Errorf("unknown user %q", flags.Arg(0))
+	}
+	return store, user, err
+}
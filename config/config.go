@@ -1,9 +1,17 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/spf13/afero"
 
 	"github.com/frullah/gin-boilerplate/fs"
 )
@@ -13,44 +21,392 @@ type Config struct {
 	Server struct {
 		Host string
 		Port uint16
+		// QueryTimeout of the database queries of a request, zero means no timeout
+		QueryTimeout Duration
+		// QueryTimeouts of the route groups by name, such as "auth", "users", "userRoles",
+		// "userAttributes", "system" and "bootstrap", they override QueryTimeout
+		QueryTimeouts map[string]Duration
+		// Bootstrap print a one-time token at startup to create the first administrator,
+		// when there is no administrator
+		Bootstrap bool
+		// ReadTimeout, WriteTimeout and IdleTimeout of the connections, zero means no timeout
+		ReadTimeout  Duration
+		WriteTimeout Duration
+		IdleTimeout  Duration
+		// ShutdownTimeout of waiting the in-flight requests on SIGINT or SIGTERM, 30s by default
+		ShutdownTimeout Duration
+		// DrainDelay of serving with the readiness failing on SIGINT or SIGTERM before the shutdown starts,
+		// so the load balancer stops routing to the server, zero means no delay.
+		// A second signal skips the delay and cuts off the in-flight requests
+		DrainDelay Duration
+		// TrustedProxies are the IPs or the CIDRs of the reverse proxies, the client IP is taken
+		// from their X-Forwarded-For header. The header is ignored when it is empty
+		TrustedProxies []string
 	}
-	DB []struct {
-		Name    string
-		Type    string
-		DSN     string
-		Logging bool
+	DB           []Database
+	Registration struct {
+		// DefaultRole name, the role flagged as default is used when empty
+		DefaultRole string
+		// Rules pick the role by email domain, "*.domain.tld" match the subdomains
+		Rules []struct {
+			Domain string
+			Role   string
+		}
 	}
+	Upload struct {
+		MaxAvatarSize int64
+	}
+	Storage Storage
+	Health  struct {
+		// Timeout of every readiness check, 2s by default
+		Timeout Duration
+	}
+	Metrics struct {
+		// Enabled serve /metrics, the metrics describe the internals of the app so it is disabled by default
+		Enabled bool
+		// Token of /metrics sent as "Authorization: Bearer <token>", no token is required when empty
+		Token string
+	}
+	Log       Log
+	Tracing   Tracing
+	RateLimit RateLimit
+	CORS      CORS
+}
+
+// CORS policy of the browsers, the profile of the app environment replaces the default policy.
+// The cross-origin requests are denied when the policy allows no origin
+type CORS struct {
+	CORSPolicy
+	// Profiles by environment, such as "development" and "production"
+	Profiles map[string]CORSPolicy
+}
+
+// CORSPolicy of the cross-origin requests, the methods and the headers are the defaults of gin-contrib/cors
+// when they are empty. The token headers are always allowed and exposed
+type CORSPolicy struct {
+	// AllowOrigins such as "https://app.example.com", "https://*.example.com" allow the subdomains
+	// of example.com and "*" allow any origin
+	AllowOrigins  []string
+	AllowMethods  []string
+	AllowHeaders  []string
+	ExposeHeaders []string
+	// AllowCredentials let the browsers send the cookies, it can't be used with the "*" origin
+	AllowCredentials bool
+	// MaxAge of the preflight responses in the cache of the browsers, 12h by default
+	MaxAge Duration
+}
+
+// Policy of the environment, the default policy when the environment has no profile
+func (c CORS) Policy(environment string) CORSPolicy {
+	if policy, ok := c.Profiles[environment]; ok {
+		return policy
+	}
+	return c.CORSPolicy
+}
+
+// RateLimit of the route groups with token buckets, the groups without a limit aren't limited
+type RateLimit struct {
+	// Store of the buckets, "memory" or "redis", "memory" by default.
+	// The memory buckets aren't shared by the instances of the app
+	Store string
+	Redis struct {
+		Addr     string
+		Password string
+		DB       int
+		// Prefix of the bucket keys, "ratelimit:" by default
+		Prefix string
+	}
+	// Groups limits by the route group name, such as "login", "register" and "availability"
+	Groups map[string]Limit
+	// APIKeys issued to the clients of the "apikey" limits, the SHA-256 hex digest of the key by client name
+	APIKeys map[string]string
+}
+
+// Limit of a route group, Requests are allowed every Period and Burst requests at once, Requests by default.
+// Key of the buckets is "ip", "user" or "apikey", "ip" by default.
+// The "user" and "apikey" keys fall back to the IP when the request has no user or issued API key,
+// so the "user" key is rejected for the groups of the routes without a user
+type Limit struct {
+	Requests int
+	Period   Duration
+	Burst    int
+	Key      string
+}
+
+// Tracing of the requests with OpenTelemetry, Exporter is "stdout" or "otlp" and tracing is disabled when empty
+type Tracing struct {
+	Exporter string
+	// Endpoint of the OTLP/HTTP collector, http://localhost:4318/v1/traces by default
+	Endpoint string
+	// Headers of the OTLP requests, such as the API key of the collector
+	Headers map[string]string
+	// ServiceName of the traces, "gin-boilerplate" by default
+	ServiceName string
+}
+
+// Log of the app, Level is "debug", "info", "warn" or "error", "info" by default.
+// Format is "json" or "text", the production environment logs JSON by default and the others text
+type Log struct {
+	Level  string
+	Format string
+}
+
+// Storage of the uploaded files, Type is "local" or "s3"
+type Storage struct {
+	Type    string
+	Dir     string
+	Secret  string
+	BaseURL string
+	S3      struct {
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+		PathStyle bool
+	}
+}
+
+// Database instance config, the name is used to lookup the instance
+type Database struct {
+	Name    string
+	Type    string
+	DSN     string
+	Logging bool
+	// MaxOpenConns and MaxIdleConns of the pool, zero use the database/sql defaults
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime of a pooled connection, zero means forever
+	ConnMaxLifetime Duration
+	// Timeout of connecting the database at startup
+	Timeout Duration
+	// Retries of connecting the database at startup, 5 by default and negative disables retrying,
+	// the Backoff (1s by default) is doubled after every retry
+	Retries int
+	Backoff Duration
+	// Replicas DSN, the read-only queries are sent to the replicas
+	Replicas []string
+	// HealthInterval of pinging the replicas, failed replicas are ejected until they respond
+	HealthInterval Duration
+	// Sticky duration of reading from the primary after a client writes
+	Sticky Duration
+}
+
+// Duration decoded from a string such as "30s" or "5m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 const configFileName = "config.toml"
+
+// logLevels and logFormats supported by the logging package
+var (
+	logLevels  = map[string]bool{"": true, "debug": true, "info": true, "warn": true, "error": true}
+	logFormats = map[string]bool{"": true, "json": true, "text": true}
+)
+
+// tracingExporters supported by the tracing package
+var tracingExporters = map[string]bool{"": true, "stdout": true, "otlp": true}
+
+// rateLimitStores and rateLimitKeys supported by the rate limiter
+var (
+	rateLimitStores = map[string]bool{"": true, "memory": true, "redis": true}
+	rateLimitKeys   = map[string]bool{"": true, "ip": true, "user": true, "apikey": true}
+	// rateLimitPublicGroups are the route groups without a user, their "user" key is always the IP
+	rateLimitPublicGroups = map[string]bool{"login": true, "register": true, "availability": true}
+)
+
+// databaseTypes supported by the db package
+var databaseTypes = map[string]bool{"mysql": true, "postgres": true, "sqlite3": true}
+
 const defaultPort = uint16(3000)
+const defaultMaxAvatarSize = int64(2 << 20)
 
 var (
 	config *Config
 )
 
-// Init config from config.yaml
+// Init the global config from config.toml of the global file system
 func Init() error {
-	file, err := fs.FS.OpenFile(configFileName, os.O_RDONLY, 0750)
+	loaded, err := Load(fs.FS)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	config = loaded
+	return nil
+}
 
-	config = &Config{}
-	_, err = toml.DecodeReader(file, &config)
+// Load config from config.toml of the file system
+func Load(fileSystem afero.Fs) (*Config, error) {
+	return LoadFile(fileSystem, configFileName)
+}
+
+// LoadFile config from the path of the file system
+func LoadFile(fileSystem afero.Fs, path string) (*Config, error) {
+	file, err := fileSystem.OpenFile(path, os.O_RDONLY, 0750)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer file.Close()
+
+	loaded := &Config{}
+	if _, err := toml.DecodeReader(file, loaded); err != nil {
+		return nil, err
+	}
+
+	if loaded.Server.Port == 0 {
+		loaded.Server.Port = defaultPort
+	}
+	if loaded.Upload.MaxAvatarSize == 0 {
+		loaded.Upload.MaxAvatarSize = defaultMaxAvatarSize
+	}
+
+	return loaded, nil
+}
+
+// Validate the values which the config file can't type,
+// every error is reported so they are fixed at once
+func (c *Config) Validate() error {
+	errs := []string{}
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	names := map[string]bool{}
+	for i, database := range c.DB {
+		switch {
+		case database.Name == "":
+			invalid("db[%d]: missing name", i)
+		case names[database.Name]:
+			invalid("db[%d]: duplicate name %q", i, database.Name)
+		}
+		names[database.Name] = true
+		if !databaseTypes[database.Type] {
+			invalid("db %q: unknown type %q", database.Name, database.Type)
+		}
+		if database.DSN == "" {
+			invalid("db %q: missing dsn", database.Name)
+		}
+	}
+	if !names["default"] {
+		invalid(`db: no "default" database`)
+	}
+
+	switch c.Storage.Type {
+	case "", "local":
+		if c.Storage.Secret == "" {
+			invalid("storage: missing local secret")
+		}
+	case "s3":
+		if c.Storage.S3.Bucket == "" {
+			invalid("storage: missing s3 bucket")
+		}
+	default:
+		invalid("storage: unknown type %q", c.Storage.Type)
+	}
+
+	if c.Server.DrainDelay.Duration < 0 {
+		invalid("server.drainDelay: negative delay")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("server.trustedProxies: invalid IP or CIDR %q", proxy)
+		}
+	}
+	for name, timeout := range c.Server.QueryTimeouts {
+		if timeout.Duration < 0 {
+			invalid("server.queryTimeouts.%s: negative timeout", name)
+		}
+	}
+
+	if !logLevels[c.Log.Level] {
+		invalid("log: unknown level %q", c.Log.Level)
+	}
+	if !logFormats[c.Log.Format] {
+		invalid("log: unknown format %q", c.Log.Format)
+	}
+
+	if !tracingExporters[c.Tracing.Exporter] {
+		invalid("tracing: unknown exporter %q", c.Tracing.Exporter)
+	}
+
+	if !rateLimitStores[c.RateLimit.Store] {
+		invalid("rateLimit: unknown store %q", c.RateLimit.Store)
+	}
+	if c.RateLimit.Store == "redis" && c.RateLimit.Redis.Addr == "" {
+		invalid("rateLimit: missing redis addr")
+	}
+	for name, limit := range c.RateLimit.Groups {
+		if limit.Requests <= 0 || limit.Period.Duration <= 0 {
+			invalid("rateLimit.groups.%s: requests and period must be positive", name)
+		}
+		if limit.Burst < 0 {
+			invalid("rateLimit.groups.%s: negative burst", name)
+		}
+		if !rateLimitKeys[limit.Key] {
+			invalid("rateLimit.groups.%s: unknown key %q", name, limit.Key)
+		}
+		if limit.Key == "user" && rateLimitPublicGroups[name] {
+			invalid(`rateLimit.groups.%s: the requests have no user, use the "ip" or "apikey" key`, name)
+		}
+	}
+	for name, digest := range c.RateLimit.APIKeys {
+		if sum, err := hex.DecodeString(digest); err != nil || len(sum) != sha256.Size {
+			invalid("rateLimit.apiKeys.%s: the digest must be a SHA-256 hex digest", name)
+		}
+	}
+
+	validateCORS("cors", c.CORS.CORSPolicy, invalid)
+	for environment, policy := range c.CORS.Profiles {
+		validateCORS("cors.profiles."+environment, policy, invalid)
 	}
 
-	if config.Server.Port == 0 {
-		config.Server.Port = defaultPort
+	for i, rule := range c.Registration.Rules {
+		if rule.Domain == "" || rule.Role == "" {
+			invalid("registration.rules[%d]: missing domain or role", i)
+		}
 	}
 
+	if len(errs) > 0 {
+		return errors.New("config: " + strings.Join(errs, "; "))
+	}
 	return nil
 }
 
+// validateCORS origins, they are "*" or a scheme and a host whose first label may be "*"
+func validateCORS(name string, policy CORSPolicy, invalid func(format string, args ...interface{})) {
+	for _, origin := range policy.AllowOrigins {
+		if origin == "*" {
+			if policy.AllowCredentials {
+				invalid(`%s: the "*" origin can't allow credentials`, name)
+			}
+			continue
+		}
+		scheme := strings.Index(origin, "://")
+		host := origin[scheme+len("://"):]
+		if scheme <= 0 || host == "" || strings.ContainsAny(host, "/?#") ||
+			strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			invalid("%s: invalid origin %q", name, origin)
+		}
+	}
+	if policy.MaxAge.Duration < 0 {
+		invalid("%s: negative maxAge", name)
+	}
+}
+
 // Get config
 func Get() *Config {
 	return config
 }
+
+// Set config, used for testing
+func Set(c *Config) {
+	config = c
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/frullah/gin-boilerplate/fs"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,9 +33,17 @@ func TestRead(t *testing.T) {
 		file.Close()
 		require.NoError(t, Init())
 		assert.Equal(t, config.Server.Port, defaultPort)
+		assert.Equal(t, config.Upload.MaxAvatarSize, defaultMaxAvatarSize)
 
 	})
 
+	t.Run("handle invalid duration", func(t *testing.T) {
+		file, _ := fs.FS.OpenFile(configFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0750)
+		file.WriteString("[[db]]\ntimeout = \"soon\"")
+		file.Close()
+		assert.Error(t, Init())
+	})
+
 	t.Run("success", func(t *testing.T) {
 		content := `
 [server]
@@ -44,11 +54,135 @@ port = 3000
 name = "default"
 type = "mysql"
 dsn = "root:frullah-cat-eat@/getting_started"
-logging = true`
+logging = true
+maxOpenConns = 20
+connMaxLifetime = "5m"`
 		file, _ := fs.FS.OpenFile(configFileName, os.O_CREATE|os.O_WRONLY, 0750)
 		file.WriteString(content)
 		file.Close()
 		require.NoError(t, Init())
-		assert.NotNil(t, Get())
+		require.NotNil(t, Get())
+		require.Len(t, config.DB, 1)
+		assert.Equal(t, 20, config.DB[0].MaxOpenConns)
+		assert.Equal(t, 5*time.Minute, config.DB[0].ConnMaxLifetime.Duration)
 	})
 }
+
+func TestLoad(t *testing.T) {
+	previous := Get()
+	fileSystem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fileSystem, configFileName, []byte("[server]\nport = 4000"), 0750))
+
+	loaded, err := Load(fileSystem)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(4000), loaded.Server.Port)
+	// the global config is not changed
+	assert.Equal(t, previous, Get())
+}
+
+func TestLoadFile(t *testing.T) {
+	fileSystem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fileSystem, "configs/production.toml", []byte("[server]\nport = 4000"), 0750))
+
+	loaded, err := LoadFile(fileSystem, "configs/production.toml")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(4000), loaded.Server.Port)
+
+	_, err = LoadFile(fileSystem, configFileName)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		c := &Config{DB: []Database{{Name: "default", Type: "sqlite3", DSN: ":memory:"}}}
+		c.Storage.Type = "local"
+		c.Storage.Secret = "secret"
+		return c
+	}
+
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"valid", func(c *Config) {}, true},
+		{"no default database", func(c *Config) { c.DB[0].Name = "reporting" }, false},
+		{"duplicate database", func(c *Config) { c.DB = append(c.DB, c.DB[0]) }, false},
+		{"unknown database type", func(c *Config) { c.DB[0].Type = "oracle" }, false},
+		{"missing dsn", func(c *Config) { c.DB[0].DSN = "" }, false},
+		{"local without secret", func(c *Config) { c.Storage.Secret = "" }, false},
+		{"unknown storage", func(c *Config) { c.Storage.Type = "ftp" }, false},
+		{"s3 without bucket", func(c *Config) { c.Storage.Type = "s3" }, false},
+		{"json log", func(c *Config) { c.Log = Log{Level: "debug", Format: "json"} }, true},
+		{"query timeout of a route group", func(c *Config) {
+			c.Server.QueryTimeouts = map[string]Duration{"users": {time.Minute}}
+		}, true},
+		{"negative query timeout", func(c *Config) {
+			c.Server.QueryTimeouts = map[string]Duration{"users": {-time.Minute}}
+		}, false},
+		{"trusted proxies", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1", "::1"} }, true},
+		{"invalid trusted proxy", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/33"} }, false},
+		{"negative drain delay", func(c *Config) { c.Server.DrainDelay.Duration = -time.Second }, false},
+		{"unknown log level", func(c *Config) { c.Log.Level = "trace" }, false},
+		{"unknown log format", func(c *Config) { c.Log.Format = "xml" }, false},
+		{"otlp tracing", func(c *Config) { c.Tracing.Exporter = "otlp" }, true},
+		{"unknown tracing exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, false},
+		{"rate limit", func(c *Config) {
+			c.RateLimit.Store = "redis"
+			c.RateLimit.Redis.Addr = "localhost:6379"
+			c.RateLimit.Groups = map[string]Limit{"login": {Requests: 5, Period: Duration{time.Minute}, Key: "ip"}}
+		}, true},
+		{"api keys", func(c *Config) {
+			c.RateLimit.APIKeys = map[string]string{"partner": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}
+		}, true},
+		{"plain api key", func(c *Config) { c.RateLimit.APIKeys = map[string]string{"partner": "secret"} }, false},
+		{"unknown rate limit store", func(c *Config) { c.RateLimit.Store = "memcached" }, false},
+		{"redis without addr", func(c *Config) { c.RateLimit.Store = "redis" }, false},
+		{"rate limit without period", func(c *Config) {
+			c.RateLimit.Groups = map[string]Limit{"login": {Requests: 5}}
+		}, false},
+		{"cors", func(c *Config) {
+			c.CORS.AllowOrigins = []string{"http://localhost:8080", "https://*.example.com", "*"}
+			c.CORS.Profiles = map[string]CORSPolicy{
+				"production": {AllowOrigins: []string{"https://example.com"}, AllowCredentials: true},
+			}
+		}, true},
+		{"cors wildcard with credentials", func(c *Config) {
+			c.CORS.AllowOrigins = []string{"*"}
+			c.CORS.AllowCredentials = true
+		}, false},
+		{"cors origin without scheme", func(c *Config) { c.CORS.AllowOrigins = []string{"example.com"} }, false},
+		{"cors origin with path", func(c *Config) { c.CORS.AllowOrigins = []string{"https://example.com/"} }, false},
+		{"cors wildcard inside host", func(c *Config) {
+			c.CORS.Profiles = map[string]CORSPolicy{"production": {AllowOrigins: []string{"https://app.*.example.com"}}}
+		}, false},
+		{"user key of an authenticated group", func(c *Config) {
+			c.RateLimit.Groups = map[string]Limit{"users": {Requests: 5, Period: Duration{time.Minute}, Key: "user"}}
+		}, true},
+		{"user key of a public group", func(c *Config) {
+			c.RateLimit.Groups = map[string]Limit{"register": {Requests: 5, Period: Duration{time.Minute}, Key: "user"}}
+		}, false},
+		{"unknown rate limit key", func(c *Config) {
+			c.RateLimit.Groups = map[string]Limit{"login": {Requests: 5, Period: Duration{time.Minute}, Key: "cookie"}}
+		}, false},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := valid()
+			testCase.modify(c)
+			if testCase.valid {
+				assert.NoError(t, c.Validate())
+			} else {
+				assert.Error(t, c.Validate())
+			}
+		})
+	}
+}
+
+func TestCORSPolicy(t *testing.T) {
+	cnf := CORS{CORSPolicy: CORSPolicy{AllowOrigins: []string{"http://localhost:8080"}}}
+	cnf.Profiles = map[string]CORSPolicy{"production": {AllowOrigins: []string{"https://example.com"}}}
+	assert.Equal(t, []string{"http://localhost:8080"}, cnf.Policy("").AllowOrigins)
+	assert.Equal(t, []string{"http://localhost:8080"}, cnf.Policy("development").AllowOrigins)
+	assert.Equal(t, []string{"https://example.com"}, cnf.Policy("production").AllowOrigins)
+}
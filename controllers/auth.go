@@ -3,16 +3,17 @@ package controllers
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/frullah/gin-boilerplate/db"
 	"golang.org/x/crypto/bcrypt"
 
-	"github.com/jinzhu/gorm"
-
 	"github.com/dgrijalva/jwt-go"
-	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // JWTClaims struct
@@ -32,8 +33,6 @@ const (
 )
 
 var (
-	bcryptCost = 12
-
 	accessTokenSecret  = []byte("auth-token-secret")
 	refreshTokenSecret = []byte("refresh-token-secret")
 
@@ -60,37 +59,55 @@ var (
 	}
 )
 
-// LoadAuthRoutes to engine
-func LoadAuthRoutes(router *gin.Engine) {
-	group := router.Group("/auth")
-	group.POST("/login", AuthLogin)
+// AuthController handle the authentication
+type AuthController struct {
+	*controller
+	store StoreProvider
+}
+
+// NewAuthController with the dependencies and the repositories of the request
+func NewAuthController(deps Dependencies, store StoreProvider) (*AuthController, error) {
+	base, err := newController(deps)
+	if err != nil {
+		return nil, err
+	}
+	return newAuthController(base, store), nil
+}
+
+func newAuthController(base *controller, store StoreProvider) *AuthController {
+	return &AuthController{base, store}
+}
+
+// LoadRoutes of the authentication to router
+func (c *AuthController) LoadRoutes(router *gin.Engine) {
+	group := router.Group("/auth", c.queryTimeout(QueryTimeoutAuth))
+	group.POST("/login", c.rateLimit(RateLimitLogin), c.Login)
 	// group.GET("/google/v2")
 
 	authenticated := group.Group("")
-	authenticated.Use(AuthRolesMiddleware(nil))
-	authenticated.GET("data", AuthData)
+	authenticated.Use(c.RolesMiddleware(nil))
+	authenticated.GET("data", c.Data)
 }
 
-// AuthLogin handler
+// Login handler
 // @Success 200 {object} struct{AccessToken string}
 // @Failure 401
 // @Failure 403 ResponseError
+// @Failure 429 ResponseError
 // @Router /auth/login [post]
-func AuthLogin(ctx *gin.Context) {
+func (c *AuthController) Login(ctx *gin.Context) {
 	body := struct {
 		Username string `json:"username" binding:"username"`
 		Password string `json:"password" binding:"password"`
 	}{}
-	if err := ctx.BindJSON(&body); err != nil {
+	if !c.validation.bindJSON(ctx, &body) {
 		return
 	}
 
-	user := models.User{}
-	if err := db.Get(db.Default).
-		Select("id, password, enabled").
-		First(&user, "username = ?", body.Username).
-		Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	user, err := c.store(ctx).Users().GetByUsername(body.Username)
+	if err != nil {
+		if err == repositories.ErrNotFound {
+			c.Metrics.login("failure")
 			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
 		} else {
 			ctx.Error(err)
@@ -101,17 +118,21 @@ func AuthLogin(ctx *gin.Context) {
 	}
 
 	if !user.Enabled {
+		c.Metrics.login("disabled")
 		ctx.PureJSON(http.StatusForbidden, jsonErrUserDisabled)
 		ctx.Abort()
 		return
 	}
 
 	if !comparePassword([]byte(user.Password), []byte(body.Password)) {
+		c.Metrics.login("failure")
 		ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
 		ctx.Abort()
 		return
 	}
 
+	c.Metrics.login("success")
+
 	ctx.PureJSON(http.StatusOK, struct {
 		AccessToken  string `json:"accessToken"`
 		RefreshToken string `json:"refreshToken"`
@@ -121,18 +142,16 @@ func AuthLogin(ctx *gin.Context) {
 	})
 }
 
-// AuthData retrieve data from authenticated user
+// Data retrieve data from authenticated user
 // @Success 200 {object} type struct{}
 // @Failure 401
 // @Router /auth/data [get]
-func AuthData(ctx *gin.Context) {
+func (c *AuthController) Data(ctx *gin.Context) {
 	userID := ctx.MustGet("userID").(uint64)
-	user := models.User{Role: &models.UserRole{}}
-	if err := db.Get(db.Default).
-		Select("enabled, username, name").
-		First(&user, userID).
-		Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
+	store := c.store(ctx)
+	user, err := store.Users().Get(userID)
+	if err != nil {
+		if err == repositories.ErrNotFound {
 			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
 		} else {
 			ctx.Error(err)
@@ -145,9 +164,8 @@ func AuthData(ctx *gin.Context) {
 		return
 	}
 
-	userRole := models.UserRole{}
-	db.Get(db.Default).Select("enabled, name").First(&userRole, user.RoleID)
-	if !userRole.Enabled {
+	userRole, err := store.Roles().Get(user.RoleID)
+	if err != nil || !userRole.Enabled {
 		ctx.PureJSON(http.StatusForbidden, jsonErrUserDisabled)
 		return
 	}
@@ -159,74 +177,94 @@ func AuthData(ctx *gin.Context) {
 	}{user.Username, user.Name, userRole.Name}})
 }
 
-// AuthRolesMiddleware function
-func AuthRolesMiddleware(allowedRoles map[string]struct{}) func(*gin.Context) {
+// RolesMiddleware authorize the user with the access token,
+// the tokens are renewed with the refresh token when the access token is expired.
+// Any role is allowed when allowedRoles is nil
+func (c *AuthController) RolesMiddleware(allowedRoles map[string]struct{}) func(*gin.Context) {
 	return func(ctx *gin.Context) {
-		decoded, err := parseAccessToken(ctx)
-		if err == errEmptyToken || err == errInvalidTokenMethod || decoded == nil {
+		end := c.startQuerySpan(ctx, "auth.roles")
+		authorized := c.authorize(ctx, allowedRoles)
+		end()
+		if authorized {
+			ctx.Next()
+		}
+	}
+}
+
+// authorize the request, the unauthorized requests are responded and aborted
+func (c *AuthController) authorize(ctx *gin.Context, allowedRoles map[string]struct{}) bool {
+	decoded, err := parseAccessToken(ctx)
+	if err == errEmptyToken || err == errInvalidTokenMethod || decoded == nil {
+		ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+		ctx.Abort()
+		return false
+	}
+
+	shouldCreateNewToken := false
+	accessClaims := decoded.Claims.(*JWTClaims)
+	if err != nil {
+		decoded, err := parseRefreshToken(ctx)
+		if err != nil || decoded == nil || !decoded.Valid {
+			c.Metrics.tokenRefresh("failure")
+			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+			ctx.Abort()
+			return false
+		}
+
+		refreshClaims := decoded.Claims.(*JWTClaims)
+		if refreshClaims.UserID != accessClaims.UserID {
+			c.Metrics.tokenRefresh("failure")
 			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
 			ctx.Abort()
-			return
+			return false
 		}
 
-		shouldCreateNewToken := false
-		accessClaims := decoded.Claims.(*JWTClaims)
+		user, err := c.store(ctx).Users().Get(refreshClaims.UserID)
 		if err != nil {
-			decoded, err := parseRefreshToken(ctx)
-			if err != nil || decoded == nil || !decoded.Valid {
-				ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
-				ctx.Abort()
-				return
-			}
-
-			refreshClaims := decoded.Claims.(*JWTClaims)
-			if refreshClaims.UserID != accessClaims.UserID {
-				ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
-				ctx.Abort()
-				return
-			}
-
-			user := models.User{}
-			if err := db.Get(db.Default).
-				Select("enabled, password").
-				First(&user, refreshClaims.UserID).
-				Error; err != nil {
-				ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
-				ctx.Abort()
-				return
-			}
-
-			if !user.Enabled {
-				ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
-				ctx.Abort()
-				return
-			}
-
-			shouldCreateNewToken = true
+			c.Metrics.tokenRefresh("failure")
+			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+			ctx.Abort()
+			return false
 		}
 
-		if allowedRoles != nil {
-			role := models.UserRole{}
-			db.Get(db.Default).
-				Table("user").
-				Select("user_role.name").
-				Joins("INNER JOIN user ON user_role.id = user.role_id").
-				First(&role, accessClaims.UserID)
-			if _, ok := allowedRoles[role.Name]; !ok {
-				ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
-				ctx.Abort()
-				return
-			}
+		if !user.Enabled {
+			c.Metrics.tokenRefresh("failure")
+			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+			ctx.Abort()
+			return false
 		}
 
-		if shouldCreateNewToken {
-			ctx.Header(AccessTokenHeader, makeAccessToken(accessClaims.UserID))
-			ctx.Header(RefreshTokenHeader, makeRefreshToken(accessClaims.UserID))
+		shouldCreateNewToken = true
+	}
+
+	if allowedRoles != nil {
+		role, err := c.store(ctx).Roles().GetByUser(accessClaims.UserID)
+		if err != nil {
+			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+			ctx.Abort()
+			return false
 		}
+		if _, ok := allowedRoles[role.Name]; !ok {
+			ctx.PureJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+			ctx.Abort()
+			return false
+		}
+	}
 
-		ctx.Set("userID", accessClaims.UserID)
-		ctx.Next()
+	span := trace.SpanFromContext(queryContext(ctx))
+	span.SetAttributes(
+		attribute.Bool("auth.refreshed", shouldCreateNewToken),
+		semconv.EnduserIDKey.String(strconv.FormatUint(accessClaims.UserID, 10)),
+	)
+
+	if shouldCreateNewToken {
+		c.Metrics.tokenRefresh("success")
+		ctx.Header(AccessTokenHeader, makeAccessToken(accessClaims.UserID))
+		ctx.Header(RefreshTokenHeader, makeRefreshToken(accessClaims.UserID))
 	}
+
+	ctx.Set("userID", accessClaims.UserID)
+	return true
 }
 
 func parseJWT(ctx *gin.Context, headerKey string, tokenChecker jwt.Keyfunc) (*jwt.Token, error) {
@@ -281,3 +319,50 @@ func makeAccessToken(userID uint64) string {
 func makeRefreshToken(userID uint64) string {
 	return makeJWT(userID, refreshTokenDuration, refreshTokenSecret)
 }
+
+// TokenInfo of an access or a refresh token
+type TokenInfo struct {
+	// Type is "access" or "refresh"
+	Type      string
+	UserID    uint64
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Err of the validation, the signature is valid when it is only expired
+	Err error
+}
+
+// errUnknownToken returned when the token is signed by none of the secrets
+var errUnknownToken = errors.New("token is not signed by the access or the refresh secret")
+
+// InspectToken decode the token and check it with the access and the refresh secrets
+func InspectToken(tokenString string) (*TokenInfo, error) {
+	if tokenString == "" {
+		return nil, errEmptyToken
+	}
+
+	for _, tokenType := range []struct {
+		name    string
+		checker jwt.Keyfunc
+	}{
+		{"access", accessTokenChecker},
+		{"refresh", refreshTokenChecker},
+	} {
+		claims := &JWTClaims{}
+		_, err := jwt.ParseWithClaims(tokenString, claims, tokenType.checker)
+		if validationErr, ok := err.(*jwt.ValidationError); ok &&
+			validationErr.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0 {
+			continue
+		} else if ok && validationErr.Errors&jwt.ValidationErrorMalformed != 0 {
+			return nil, err
+		}
+
+		return &TokenInfo{
+			Type:      tokenType.name,
+			UserID:    claims.UserID,
+			IssuedAt:  time.Unix(claims.IssuedAt, 0),
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+			Err:       err,
+		}, nil
+	}
+	return nil, errUnknownToken
+}
@@ -10,7 +10,10 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/seed"
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -20,7 +23,7 @@ import (
 )
 
 func init() {
-	bcryptCost = bcrypt.MinCost
+	seed.BcryptCost = bcrypt.MinCost
 }
 
 func TestAuthLogin(t *testing.T) {
@@ -30,7 +33,7 @@ func TestAuthLogin(t *testing.T) {
 		return `{"username": "` + username + `", "password": "` + password + `"}`
 	}
 	password := "secret"
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	hashedPassword, _ := seed.HashPassword(password)
 
 	router := SetupRouter()
 	cases := []routeTestCase{
@@ -285,9 +288,10 @@ func TestAuthMiddleware(t *testing.T) {
 	validRefreshToken := makeRefreshToken(1)
 	expiredAccessToken := makeJWT(1, -time.Second, accessTokenSecret)
 
+	auth := newAuthController(testController(testDependencies()), RequestStore(db.Current()))
 	router := gin.New()
-	router.GET("/", AuthRolesMiddleware(nil))
-	router.GET("/roles", AuthRolesMiddleware(map[string]struct{}{
+	router.GET("/", auth.RolesMiddleware(nil))
+	router.GET("/roles", auth.RolesMiddleware(map[string]struct{}{
 		"administrator": {},
 	}))
 	routeCases := []routeTestCase{
@@ -430,3 +434,27 @@ func TestAuthMiddleware(t *testing.T) {
 		t.Run(routeCase.name, func(t *testing.T) { routeCase.run(t, router) })
 	}
 }
+
+func TestInspectToken(t *testing.T) {
+	info, err := InspectToken(makeAccessToken(7))
+	require.NoError(t, err)
+	assert.Equal(t, "access", info.Type)
+	assert.Equal(t, uint64(7), info.UserID)
+	assert.NoError(t, info.Err)
+
+	info, err = InspectToken(makeRefreshToken(7))
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", info.Type)
+
+	info, err = InspectToken(makeJWT(7, -time.Minute, accessTokenSecret))
+	require.NoError(t, err)
+	assert.Equal(t, "access", info.Type)
+	assert.Error(t, info.Err)
+
+	_, err = InspectToken(makeJWT(7, time.Minute, []byte("another secret")))
+	assert.Equal(t, errUnknownToken, err)
+	_, err = InspectToken("malformed")
+	assert.Error(t, err)
+	_, err = InspectToken("")
+	assert.Equal(t, errEmptyToken, err)
+}
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+
+	// register decoders for the accepted avatar formats
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/frullah/gin-boilerplate/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	avatarFormField       = "avatar"
+	avatarDefaultSize     = "medium"
+	avatarMaxDimension    = 4096
+	avatarContentType     = "image/png"
+	avatarCacheControl    = "public, max-age=300"
+	avatarSniffBufferSize = 512
+)
+
+// avatarSizes maps the thumbnail name to its width and height in pixels
+var avatarSizes = map[string]int{
+	"small":  64,
+	"medium": 128,
+	"large":  256,
+}
+
+var avatarAllowedContentTypes = map[string]struct{}{
+	"image/png":  {},
+	"image/jpeg": {},
+	"image/gif":  {},
+}
+
+var (
+	jsonErrAvatarRequired = ResponseError{
+		Status:  "error",
+		Message: "Avatar file is required",
+	}
+	jsonErrAvatarTooLarge = ResponseError{
+		Status:  "error",
+		Message: "Avatar file is too large",
+	}
+	jsonErrAvatarUnsupported = ResponseError{
+		Status:  "error",
+		Message: "Avatar must be a PNG, JPEG or GIF image",
+	}
+	jsonErrAvatarInvalidSize = ResponseError{
+		Status:  "error",
+		Message: "size must be one of small, medium or large",
+	}
+)
+
+// AvatarUpload store the avatar of the authenticated user
+// @Accept multipart/form-data
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} controllers.Response
+// @Failure 400 {object} controllers.ResponseError
+// @Failure 401
+// @Failure 413 {object} controllers.ResponseError
+// @Router /me/avatar [put]
+func (c *UserController) AvatarUpload(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint64)
+	maxSize := c.Config.Upload.MaxAvatarSize
+
+	// the multipart envelope takes a few bytes on top of the file itself
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+4096)
+	fileHeader, err := ctx.FormFile(avatarFormField)
+	if err != nil {
+		if isRequestTooLarge(err) {
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, jsonErrAvatarTooLarge)
+		} else {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, jsonErrAvatarRequired)
+		}
+		return
+	}
+	if fileHeader.Size > maxSize {
+		ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, jsonErrAvatarTooLarge)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	if int64(len(content)) > maxSize {
+		ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, jsonErrAvatarTooLarge)
+		return
+	}
+
+	sniffLen := len(content)
+	if sniffLen > avatarSniffBufferSize {
+		sniffLen = avatarSniffBufferSize
+	}
+	if _, ok := avatarAllowedContentTypes[http.DetectContentType(content[:sniffLen])]; !ok {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, jsonErrAvatarUnsupported)
+		return
+	}
+
+	// check the dimension before decoding to avoid decompression bombs
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil ||
+		imgConfig.Width > avatarMaxDimension ||
+		imgConfig.Height > avatarMaxDimension {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, jsonErrAvatarUnsupported)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, jsonErrAvatarUnsupported)
+		return
+	}
+
+	if err := saveAvatar(ctx.Request.Context(), c.Storage, userID, img); err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
+}
+
+// AvatarGet serve the avatar thumbnail of the user
+// @Produce png
+// @Param id path int true "User ID"
+// @Param size query string false "small, medium or large"
+// @Success 200
+// @Failure 400 {object} controllers.ResponseError
+// @Failure 404 {object} controllers.ResponseError
+// @Router /users/{id}/avatar [get]
+func (c *UserController) AvatarGet(ctx *gin.Context) {
+	id, err := mustParseUintParam(ctx, "id", 64)
+	if err != nil {
+		return
+	}
+
+	size := ctx.DefaultQuery("size", avatarDefaultSize)
+	if _, ok := avatarSizes[size]; !ok {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, jsonErrAvatarInvalidSize)
+		return
+	}
+
+	reader, object, err := c.Storage.Get(ctx.Request.Context(), avatarKey(id, size))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, ResponseError{
+				Status:  "error",
+				Message: "data not found",
+			})
+		} else {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+		return
+	}
+	defer reader.Close()
+
+	headers := map[string]string{"Cache-Control": avatarCacheControl}
+	if !object.LastModified.IsZero() {
+		headers["Last-Modified"] = object.LastModified.UTC().Format(http.TimeFormat)
+	}
+	ctx.DataFromReader(http.StatusOK, object.Size, avatarContentType, reader, headers)
+}
+
+func saveAvatar(ctx context.Context, blob storage.Blob, userID uint64, img image.Image) error {
+	square := cropSquare(img)
+	for size, dimension := range avatarSizes {
+		buff := bytes.Buffer{}
+		if err := png.Encode(&buff, resizeImage(square, dimension)); err != nil {
+			return err
+		}
+
+		if err := blob.Put(
+			ctx,
+			avatarKey(userID, size),
+			&buff,
+			storage.PutOptions{ContentType: avatarContentType},
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func avatarKey(userID uint64, size string) string {
+	return path.Join("avatars", strconv.FormatUint(userID, 10), fmt.Sprintf("%s.png", size))
+}
+
+func isRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// cropSquare take the centered square of the image
+func cropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == height {
+		return img
+	}
+
+	side := width
+	if height < side {
+		side = height
+	}
+	minPoint := image.Pt(
+		bounds.Min.X+(width-side)/2,
+		bounds.Min.Y+(height-side)/2,
+	)
+
+	return subImage(img, image.Rectangle{minPoint, minPoint.Add(image.Pt(side, side))})
+}
+
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			dst.Set(x, y, img.At(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// resizeImage scale the image into a square of the given size,
+// each destination pixel is the average of the source pixels it covers
+func resizeImage(img image.Image, size int) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	for y := 0; y < size; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/size
+		y1 := bounds.Min.Y + (y+1)*srcHeight/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < size; x++ {
+			x0 := bounds.Min.X + x*srcWidth/size
+			x1 := bounds.Min.X + (x+1)*srcWidth/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pixel := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r += uint64(pixel.R)
+					g += uint64(pixel.G)
+					b += uint64(pixel.B)
+					a += uint64(pixel.A)
+					count++
+				}
+			}
+
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / count >> 8),
+				G: uint8(g / count >> 8),
+				B: uint8(b / count >> 8),
+				A: uint8(a / count >> 8),
+			})
+		}
+	}
+
+	return dst
+}
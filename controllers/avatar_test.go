@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeAvatarBody(t *testing.T, field string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile(field, "avatar.png")
+	require.NoError(t, err)
+	part.Write(content)
+	require.NoError(t, writer.Close())
+
+	return body, writer.FormDataContentType()
+}
+
+func makePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	buff := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buff, img))
+	return buff.Bytes()
+}
+
+func TestUserAvatarUpload(t *testing.T) {
+	deps := testDependencies()
+	router := setupRouter(deps)
+
+	upload := func(field string, content []byte) *httptest.ResponseRecorder {
+		body, contentType := makeAvatarBody(t, field, content)
+		request, _ := http.NewRequest(http.MethodPut, "/me/avatar", body)
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set(AccessTokenHeader, makeAccessToken(7))
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPut, "/me/avatar", nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		response := upload("not-avatar", makePNG(t, 8, 8))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		response := upload(avatarFormField, []byte("<html>not an image</html>"))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		response := upload(avatarFormField, make([]byte, deps.Config.Upload.MaxAvatarSize+1))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		response := upload(avatarFormField, make([]byte, 2*deps.Config.Upload.MaxAvatarSize))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		response := upload(avatarFormField, makePNG(t, 300, 200))
+		require.Equal(t, http.StatusOK, response.Code)
+
+		for size, dimension := range avatarSizes {
+			reader, _, err := deps.Storage.Get(context.Background(), avatarKey(7, size))
+			require.NoError(t, err)
+			content, _ := ioutil.ReadAll(reader)
+			reader.Close()
+
+			img, err := png.Decode(bytes.NewReader(content))
+			require.NoError(t, err)
+			assert.Equal(t, dimension, img.Bounds().Dx())
+			assert.Equal(t, dimension, img.Bounds().Dy())
+		}
+	})
+}
+
+func TestUserAvatarGet(t *testing.T) {
+	deps := testDependencies()
+	router := setupRouter(deps)
+	require.NoError(t, saveAvatar(context.Background(), deps.Storage, 3, image.NewNRGBA(image.Rect(0, 0, 10, 10))))
+
+	cases := []routeTestCase{
+		{
+			name:         "invalid id param",
+			url:          "/users/x/avatar",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid size",
+			url:          "/users/3/avatar?size=huge",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "avatar not found",
+			url:          "/users/4/avatar",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "avatar found",
+			url:          "/users/3/avatar?size=small",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+
+	t.Run("content type", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/users/3/avatar", nil)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, avatarContentType, response.Header().Get("Content-Type"))
+	})
+}
+
+func TestResizeImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 10))
+	square := cropSquare(img)
+	assert.Equal(t, 10, square.Bounds().Dx())
+	assert.Equal(t, 10, square.Bounds().Dy())
+
+	resized := resizeImage(square, 64)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), resized.Bounds())
+}
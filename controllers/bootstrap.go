@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/frullah/gin-boilerplate/seed"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	bootstrapURL = "/bootstrap"
+
+	// BootstrapTokenHeader of the token printed at startup
+	BootstrapTokenHeader = "X-Bootstrap-Token"
+)
+
+var jsonErrAdminExists = ResponseError{
+	Status:  "error",
+	Message: "An administrator already exists",
+}
+
+// BootstrapController create the first administrator with the one-time token printed at startup
+type BootstrapController struct {
+	*controller
+	store StoreProvider
+
+	mu sync.Mutex
+	// token is cleared once it is used
+	token string
+}
+
+// NewBootstrapController with the dependencies, the repositories of the request and a new random token
+func NewBootstrapController(deps Dependencies, store StoreProvider) (*BootstrapController, error) {
+	base, err := newController(deps)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &BootstrapController{controller: base, store: store, token: hex.EncodeToString(token)}, nil
+}
+
+// Token to create the first administrator, empty once it is used
+func (c *BootstrapController) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// LoadRoutes of the bootstrap to router
+func (c *BootstrapController) LoadRoutes(router *gin.Engine) {
+	router.POST(bootstrapURL, c.queryTimeout(QueryTimeoutBootstrap), c.CreateAdmin)
+}
+
+// CreateAdmin docs
+// @Accept json
+// @Success 200 {object} Uint64ID
+// @Failure 401
+// @Failure 409
+// @Router /bootstrap [post]
+func (c *BootstrapController) CreateAdmin(ctx *gin.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token := ctx.GetHeader(BootstrapTokenHeader)
+	if c.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+		return
+	}
+
+	data := struct {
+		Email    string `json:"email" binding:"required,email"`
+		Username string `json:"username" binding:"required,username"`
+		Password string `json:"password" binding:"required,password"`
+		Name     string `json:"name" binding:"max=64"`
+	}{}
+	if !c.validation.bindJSON(ctx, &data) {
+		return
+	}
+
+	user, err := seed.CreateAdmin(c.store(ctx), seed.Admin{
+		Email:    data.Email,
+		Username: data.Username,
+		Password: data.Password,
+		Name:     data.Name,
+	}, false)
+	if err == seed.ErrAdminExists {
+		c.token = ""
+		ctx.AbortWithStatusJSON(http.StatusConflict, jsonErrAdminExists)
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	c.token = ""
+	ctx.PureJSON(http.StatusOK, &Response{"success", Uint64ID{*user.ID}})
+}
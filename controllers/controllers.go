@@ -2,15 +2,16 @@ package controllers
 
 import (
 	"bytes"
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/frullah/gin-boilerplate/db"
 	ginvalidator "github.com/frullah/gin-validator"
-	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
-	"github.com/logrusorgru/aurora"
 
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/sirupsen/logrus"
 	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
 )
 
@@ -54,8 +56,12 @@ type ResponseError struct {
 	Code    int         `json:"code,omitempty"`
 }
 
+// statusClientClosedRequest is the nginx status of the requests which the client closed
+const statusClientClosedRequest = 499
+
 const (
 	userURL     = "/users"
+	meURL       = "/me"
 	userRoleURL = "/user-roles"
 	registerURL = "/register"
 )
@@ -65,6 +71,26 @@ var (
 		Status:  "error",
 		Message: "Data already exists!",
 	}
+	jsonErrForeignKey = ResponseError{
+		Status:  "error",
+		Message: "Data references missing data or is still referenced",
+	}
+	jsonErrNotNull = ResponseError{
+		Status:  "error",
+		Message: "Required data is missing",
+	}
+	jsonErrDBUnavailable = ResponseError{
+		Status:  "error",
+		Message: "Database is busy, please retry",
+	}
+	jsonErrQueryTimeout = ResponseError{
+		Status:  "error",
+		Message: "Database query timed out",
+	}
+	jsonErrClientClosed = ResponseError{
+		Status:  "error",
+		Message: "Client closed the request",
+	}
 	jsonErrInvalidJSONBody = ResponseError{
 		Status:  "error",
 		Message: "Body is not valid JSON",
@@ -74,18 +100,12 @@ var (
 		Message: "Body should not empty",
 	}
 
-	validatorTranslator ut.Translator
+	errEmptyBody = errors.New("invalid request")
 )
 
-func init() {
-	binding.Validator = &ginvalidator.Validator{
-		ConfigFn: configureValidation,
-	}
-}
-
 func (c FieldError) Error() string {
 	buff := bytes.Buffer{}
-	for _, fieldName := range c {
+	for fieldName := range c {
 		buff.WriteString(c[fieldName])
 		buff.WriteByte('\n')
 	}
@@ -93,17 +113,45 @@ func (c FieldError) Error() string {
 	return strings.TrimSpace(buff.String())
 }
 
-// LoadRoutes into router
-func LoadRoutes(router *gin.Engine) {
-	router.Use(ErrorMiddleware)
+// LoadRoutes into router, the handlers use the given dependencies
+func LoadRoutes(router *gin.Engine, deps Dependencies) error {
+	base, err := newController(deps)
+	if err != nil {
+		return err
+	}
+
+	if err := trustProxies(router, base.Config.Server.TrustedProxies); err != nil {
+		return err
+	}
+
+	templates := &routeTemplates{router: router}
+	router.Use(
+		base.tracingMiddleware(templates),
+		base.metricsMiddleware(templates),
+		ErrorMiddleware(base.Logger),
+		base.stickyMiddleware,
+	)
+
+	store := RequestStore(base.Databases)
+	newAuthController(base, store).LoadRoutes(router)
+	newUserController(base, store).LoadRoutes(router)
+	newUserRoleController(base, store).LoadRoutes(router)
+	newUserAttributeController(base, store).LoadRoutes(router)
+	newSystemController(base, store).LoadRoutes(router)
+	(&HealthController{base}).LoadRoutes(router)
+	newMetricsController(base).LoadRoutes(router)
+	return nil
+}
 
-	LoadAuthRoutes(router)
-	LoadUserRoutes(router)
-	LoadUserRoleRoutes(router)
+// ErrorMiddleware handling error after all handler, the errors are logged
+// with the entry of the request or the logger
+func ErrorMiddleware(logger logrus.FieldLogger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		handleError(ctx, requestLogger(ctx, logger))
+	}
 }
 
-// ErrorMiddleware handling error after all handler
-func ErrorMiddleware(ctx *gin.Context) {
+func handleError(ctx *gin.Context, logger logrus.FieldLogger) {
 	ctx.Next()
 
 	lastError := ctx.Errors.Last()
@@ -115,12 +163,8 @@ func ErrorMiddleware(ctx *gin.Context) {
 	switch lastError.Type {
 	case gin.ErrorTypeBind:
 		switch err := lastError.Err.(type) {
-		case validator.ValidationErrors:
-			resJSON := FieldError{}
-			for _, fieldError := range err {
-				resJSON[fieldError.Field()] = fieldError.Translate(validatorTranslator)
-			}
-			ctx.PureJSON(http.StatusBadRequest, Response{"fail", resJSON})
+		case FieldError:
+			ctx.PureJSON(http.StatusBadRequest, Response{"fail", err})
 		default:
 			ctx.PureJSON(http.StatusBadRequest, jsonErrEmptyBody)
 		}
@@ -139,22 +183,57 @@ func ErrorMiddleware(ctx *gin.Context) {
 
 	CHECK_ERROR_TYPE:
 		_ = "coverage test line"
-		switch err := lastError.Err.(type) {
-		case *mysql.MySQLError:
-			switch err.Number {
-			case 1062:
-				ctx.PureJSON(http.StatusConflict, jsonErrConflict)
-			}
+		switch contextError(lastError.Err) {
+		case context.DeadlineExceeded:
+			logger.WithError(lastError.Err).Warn("database query timed out")
+			ctx.PureJSON(http.StatusGatewayTimeout, jsonErrQueryTimeout)
+			return
+		case context.Canceled:
+			ctx.PureJSON(statusClientClosedRequest, jsonErrClientClosed)
+			return
+		}
+
+		switch db.Classify(lastError.Err) {
+		case db.ErrUniqueViolation:
+			ctx.PureJSON(http.StatusConflict, jsonErrConflict)
+		case db.ErrForeignKeyViolation:
+			ctx.PureJSON(http.StatusUnprocessableEntity, jsonErrForeignKey)
+		case db.ErrNotNullViolation:
+			ctx.PureJSON(http.StatusUnprocessableEntity, jsonErrNotNull)
+		case db.ErrDeadlock, db.ErrTimeout:
+			logger.WithError(lastError.Err).Warn("database unavailable")
+			ctx.Header("Retry-After", "1")
+			ctx.PureJSON(http.StatusServiceUnavailable, jsonErrDBUnavailable)
 		default:
-			internalServerError(ctx, lastError.Err)
+			internalServerError(ctx, logger, lastError.Err)
 		}
 
 	default:
-		internalServerError(ctx, lastError.Err)
+		internalServerError(ctx, logger, lastError.Err)
+	}
+}
+
+// contextError is the context error which err wraps, nil when it wraps none
+func contextError(err error) error {
+	if errs, ok := err.(gorm.Errors); ok {
+		for _, err := range errs {
+			if ctxErr := contextError(err); ctxErr != nil {
+				return ctxErr
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return context.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return context.Canceled
 	}
+	return nil
 }
 
-func internalServerError(ctx *gin.Context, err error) {
+func internalServerError(ctx *gin.Context, logger logrus.FieldLogger, err error) {
 	ctx.PureJSON(
 		http.StatusInternalServerError,
 		&ResponseError{
@@ -162,19 +241,83 @@ func internalServerError(ctx *gin.Context, err error) {
 			Message: "Internal server error",
 		},
 	)
-	log.Println(
-		aurora.BrightRed("[Error]"),
-		aurora.BrightRed(err),
-	)
+	logger.WithError(err).Error("internal server error")
 }
 
-func configureValidation(v *validator.Validate) {
+// NewBindingValidator with the tags of the routes, such as "username" and "password",
+// it is a compatibility shim for the handlers which bind with ctx.ShouldBindJSON
+// through the global binding.Validator of gin
+func NewBindingValidator() binding.StructValidator {
+	return newValidation().validator
+}
+
+// validation of the request bodies, every router has its own validator and translator
+type validation struct {
+	validator  *ginvalidator.Validator
+	translator ut.Translator
+}
+
+func newValidation() *validation {
+	v := &validation{}
+	v.validator = &ginvalidator.Validator{ConfigFn: v.configure}
+	// the translator is set up with the engine
+	v.validator.Engine()
+	return v
+}
+
+// bindJSON decode the body into obj and validate it,
+// the request is aborted with a bind error when it fails
+func (v *validation) bindJSON(ctx *gin.Context, obj interface{}) bool {
+	if err := v.shouldBindJSON(ctx, obj); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypeBind)
+		return false
+	}
+	return true
+}
+
+// shouldBindJSON decode the body into obj and validate it, the validation errors are a FieldError
+func (v *validation) shouldBindJSON(ctx *gin.Context, obj interface{}) error {
+	if ctx.Request == nil || ctx.Request.Body == nil {
+		return errEmptyBody
+	}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(obj); err != nil {
+		return err
+	}
+	return v.validate(obj)
+}
+
+// validate the struct by its binding tags, the validation errors are translated into a FieldError
+func (v *validation) validate(obj interface{}) error {
+	err := v.validator.ValidateStruct(obj)
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	fieldErrors := FieldError{}
+	for _, fieldError := range validationErrors {
+		fieldErrors[fieldError.Field()] = fieldError.Translate(v.translator)
+	}
+	return fieldErrors
+}
+
+// translate the message of the translation key
+func (v *validation) translate(key string, params ...string) string {
+	msg, _ := v.translator.T(key, params...)
+	return msg
+}
+
+func (v *validation) configure(engine *validator.Validate) {
 	translator := en.New()
-	validatorTranslator, _ = ut.New(translator, translator).GetTranslator("en")
-	en_translations.RegisterDefaultTranslations(v, validatorTranslator)
+	v.translator, _ = ut.New(translator, translator).GetTranslator("en")
+	en_translations.RegisterDefaultTranslations(engine, v.translator)
+
+	v.translator.Add("attribute-type", "{0} must be a {1}", false)
+	v.translator.Add("attribute-pattern", "{0} format is invalid", false)
+	v.translator.Add("attribute-unknown", "{0} is not a known attribute", false)
 
-	v.RegisterAlias("username", "min=5,max=64")
-	v.RegisterAlias("password", "min=5,max=64")
+	engine.RegisterAlias("username", "min=5,max=64")
+	engine.RegisterAlias("password", "min=5,max=64")
 
 	betweenTranslator := func(min, max int) validator.TranslationFunc {
 		minStr := strconv.Itoa(min)
@@ -203,15 +346,15 @@ func configureValidation(v *validator.Validate) {
 		return nil
 	}
 
-	v.RegisterTranslation(
+	engine.RegisterTranslation(
 		"username",
-		validatorTranslator,
+		v.translator,
 		emptyRegisterTranslationFn,
 		betweenTranslator(5, 64),
 	)
-	v.RegisterTranslation(
+	engine.RegisterTranslation(
 		"password",
-		validatorTranslator,
+		v.translator,
 		emptyRegisterTranslationFn,
 		betweenTranslator(5, 64),
 	)
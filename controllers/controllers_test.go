@@ -12,10 +12,17 @@ import (
 	"testing"
 	"unsafe"
 
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/logging"
 	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/frullah/gin-boilerplate/storage"
+	"github.com/spf13/afero"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/frullah/gin-boilerplate/db"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -55,6 +62,18 @@ type routeTestCase struct {
 	expectedCode     int
 }
 
+const (
+	sqlBegin    = "BEGIN"
+	sqlCommit   = "COMMIT"
+	sqlRollback = "ROLLBACK"
+)
+
+var (
+	sqlExpectBegin    = sqlExpect{expectedSQL: sqlBegin}
+	sqlExpectCommit   = sqlExpect{expectedSQL: sqlCommit}
+	sqlExpectRollback = sqlExpect{expectedSQL: sqlRollback}
+)
+
 type sqlExpect struct {
 	expectedSQL string
 	result      interface{}
@@ -106,7 +125,7 @@ func Setup(params tSetup) *Test {
 	router := gin.New()
 
 	if params.LoadRoutes {
-		LoadRoutes(router)
+		router = SetupRouter()
 	}
 
 	return &Test{
@@ -118,7 +137,23 @@ func TestControllers(t *testing.T) {
 	_ = FieldError{"field": "message"}.Error()
 }
 
+func TestLoadRoutesDependencies(t *testing.T) {
+	deps := testDependencies()
+	deps.Config = nil
+	assert.Equal(t, errMissingConfig, LoadRoutes(gin.New(), deps))
+
+	deps = testDependencies()
+	deps.Databases = nil
+	assert.Equal(t, errMissingDatabases, LoadRoutes(gin.New(), deps))
+
+	deps = testDependencies()
+	deps.Storage = nil
+	_, err := NewAuthController(deps, RequestStore(db.Current()))
+	assert.Equal(t, errMissingStorage, err)
+}
+
 func TestErrorMiddleware(t *testing.T) {
+	errorMiddleware := ErrorMiddleware(logrus.StandardLogger())
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
 
@@ -126,7 +161,7 @@ func TestErrorMiddleware(t *testing.T) {
 		const url = "/a-route-url"
 		request, _ := http.NewRequest("GET", url, nil)
 		router := gin.New()
-		router.Use(ErrorMiddleware)
+		router.Use(errorMiddleware)
 		router.GET(url, func(ctx *gin.Context) {
 			ctx.Error(errDummy).SetType(gin.ErrorTypePrivate)
 		})
@@ -135,25 +170,82 @@ func TestErrorMiddleware(t *testing.T) {
 	})
 
 	t.Run("without error", func(t *testing.T) {
-		ErrorMiddleware(ctx)
+		errorMiddleware(ctx)
 	})
 
 	t.Run("unhandled bind", func(t *testing.T) {
 		ctx.Error(errDummy).SetType(gin.ErrorTypeBind)
-		ErrorMiddleware(ctx)
+		errorMiddleware(ctx)
 	})
 
 	t.Run("unhandled private error type", func(t *testing.T) {
 		ctx.Error(errDummy).SetType(gin.ErrorTypePrivate)
-		ErrorMiddleware(ctx)
+		errorMiddleware(ctx)
 	})
 
 	t.Run("unknown error type", func(t *testing.T) {
 		ctx.Error(errDummy).SetType(gin.ErrorTypeAny)
-		ErrorMiddleware(ctx)
+		errorMiddleware(ctx)
 	})
 }
 
+func TestErrorMiddlewareDBErrors(t *testing.T) {
+	cases := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{"unique violation", &mysql.MySQLError{Number: 1062}, http.StatusConflict},
+		{"foreign key violation", &mysql.MySQLError{Number: 1452}, http.StatusUnprocessableEntity},
+		{"not null violation", &mysql.MySQLError{Number: 1048}, http.StatusUnprocessableEntity},
+		{"deadlock", &mysql.MySQLError{Number: 1213}, http.StatusServiceUnavailable},
+		{"timeout", &mysql.MySQLError{Number: 1205}, http.StatusServiceUnavailable},
+		{"unknown database error", &mysql.MySQLError{Number: 1064}, http.StatusInternalServerError},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest(http.MethodGet, "/", nil)
+			router := gin.New()
+			router.Use(ErrorMiddleware(logrus.StandardLogger()))
+			router.GET("/", func(ctx *gin.Context) {
+				ctx.Error(testCase.err)
+			})
+			router.ServeHTTP(recorder, request)
+			assert.Equal(t, testCase.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestErrorMiddlewareLogs(t *testing.T) {
+	logs := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = logs
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	router := gin.New()
+	router.Use(logging.Middleware(logger), ErrorMiddleware(logrus.StandardLogger()))
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Error(errDummy)
+	})
+	request, _ := http.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(logging.RequestIDHeader, "request-id")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	line := struct {
+		Level     string
+		Msg       string
+		Error     string
+		RequestID string `json:"request_id"`
+	}{}
+	require.NoError(t, jsoniter.NewDecoder(logs).Decode(&line))
+	assert.Equal(t, "error", line.Level)
+	assert.Equal(t, "internal server error", line.Msg)
+	assert.Equal(t, errDummy.Error(), line.Error)
+	assert.Equal(t, "request-id", line.RequestID)
+}
+
 func toHTTPBody(body interface{}) io.Reader {
 	switch x := body.(type) {
 	case nil:
@@ -241,6 +333,19 @@ func sqlmockExpects(sqlMock sqlmock.Sqlmock, params ...sqlExpect) {
 }
 
 func sqlmockExpect(sqlMock sqlmock.Sqlmock, param sqlExpect) {
+	// explicit transaction which contains several statements
+	switch param.expectedSQL {
+	case sqlBegin:
+		sqlMock.ExpectBegin()
+		return
+	case sqlCommit:
+		sqlMock.ExpectCommit()
+		return
+	case sqlRollback:
+		sqlMock.ExpectRollback()
+		return
+	}
+
 	if param.transaction {
 		sqlMock.ExpectBegin()
 	}
@@ -280,13 +385,67 @@ func sqlmockExpect(sqlMock sqlmock.Sqlmock, param sqlExpect) {
 	}
 }
 
+// testDependencies of the routes, the databases are mocked by db.SetupTest
+func testDependencies() Dependencies {
+	cnf := &config.Config{}
+	cnf.Upload.MaxAvatarSize = 64 << 10
+	return Dependencies{
+		Config:    cnf,
+		Databases: db.Current(),
+		Storage:   storage.NewLocal(afero.NewMemMapFs(), storage.LocalConfig{Secret: []byte("storage-secret")}),
+	}
+}
+
 func SetupRouter() *gin.Engine {
+	return setupRouter(testDependencies())
+}
+
+func setupRouter(deps Dependencies) *gin.Engine {
 	router := gin.New()
-	LoadRoutes(router)
+	if err := LoadRoutes(router, deps); err != nil {
+		panic(err)
+	}
+	return router
+}
 
+// testController with the completed dependencies
+func testController(deps Dependencies) *controller {
+	base, err := newController(deps)
+	if err != nil {
+		panic(err)
+	}
+	return base
+}
+
+// memoryRouter serve the user and role routes from the in-memory repositories
+func memoryRouter(store repositories.Store) *gin.Engine {
+	return memoryRouterWith(testController(testDependencies()), store)
+}
+
+func memoryRouterWith(base *controller, store repositories.Store) *gin.Engine {
+	provider := func(*gin.Context) repositories.Store { return store }
+	router := gin.New()
+	if err := trustProxies(router, base.Config.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	router.Use(ErrorMiddleware(base.Logger))
+	newAuthController(base, provider).LoadRoutes(router)
+	newUserController(base, provider).LoadRoutes(router)
+	newUserRoleController(base, provider).LoadRoutes(router)
 	return router
 }
 
+// memoryAdministrator create the administrator role and user into the store,
+// returns the access token of the user
+func memoryAdministrator(t *testing.T, store repositories.Store) string {
+	t.Helper()
+	role := &models.UserRole{Name: "administrator", Enabled: true}
+	require.NoError(t, store.Roles().Create(role))
+	user := &models.User{Email: "admin@example.com", Username: "admin", RoleID: role.ID, Enabled: true}
+	require.NoError(t, store.Users().Create(user))
+	return makeAccessToken(*user.ID)
+}
+
 func sqlExpectAuthRole(roleName string) sqlExpect {
 	return sqlExpect{
 		expectedSQL: "SELECT .+ FROM .user.",
@@ -294,3 +453,20 @@ func sqlExpectAuthRole(roleName string) sqlExpect {
 			AddRow(roleName),
 	}
 }
+
+func sqlExpectUserAttributes(rows *sqlmock.Rows) sqlExpect {
+	if rows == nil {
+		rows = sqlmock.NewRows([]string{"id", "name", "type", "required", "pattern", "enum"})
+	}
+	return sqlExpect{
+		expectedSQL: "SELECT .+ FROM .user_attribute.",
+		result:      rows,
+	}
+}
+
+func sqlExpectDefaultRole(id uint32) sqlExpect {
+	return sqlExpect{
+		expectedSQL: "SELECT .+ FROM .user_role.",
+		result:      sqlmock.NewRows([]string{"id", "enabled"}).AddRow(id, true),
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// stickyCookie holds the time until the client reads from the primary
+	stickyCookie = "db-primary-until"
+	// txKey of the request transaction in the context
+	txKey = "db.tx"
+	// queryContextKey of the context of the request queries
+	queryContextKey = "db.context"
+)
+
+// requestTx is began by the first getDB call of the request
+type requestTx struct {
+	tx  *db.Tx
+	err error
+}
+
+// getDB of the request from the databases of the controller, see requestDB
+func (c *controller) getDB(ctx *gin.Context) *gorm.DB {
+	return requestDB(ctx, c.Databases)
+}
+
+// requestDB is the transaction when the route uses TransactionMiddleware,
+// the reads go to the replicas unless the request writes or the client has written recently
+func requestDB(ctx *gin.Context, databases *db.Databases) *gorm.DB {
+	queryCtx := queryContext(ctx)
+	if value, ok := ctx.Get(txKey); ok {
+		rtx := value.(*requestTx)
+		if rtx.tx == nil && rtx.err == nil {
+			rtx.tx, rtx.err = db.Begin(db.WithContext(databases.Primary(db.Default), queryCtx))
+		}
+		if rtx.err != nil {
+			// the error is returned by every query of the handler
+			failed := databases.Primary(db.Default).New()
+			failed.AddError(rtx.err)
+			return failed
+		}
+		return rtx.tx.DB
+	}
+
+	if isWriteRequest(ctx.Request) || isSticky(ctx) {
+		return db.WithContext(databases.Primary(db.Default), queryCtx)
+	}
+	return db.WithContext(databases.Get(db.Default), queryCtx)
+}
+
+// queryContext of the request queries, they are canceled when the client disconnects
+// or the query timeout is exceeded
+func queryContext(ctx *gin.Context) context.Context {
+	if value, ok := ctx.Get(queryContextKey); ok {
+		return value.(context.Context)
+	}
+	return ctx.Request.Context()
+}
+
+// QueryTimeout of the route queries, it overrides the timeout of the config.
+// It must run before TransactionMiddleware, the transaction is rolled back once the timeout is canceled
+func QueryTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		queryCtx := ctx.Request.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			queryCtx, cancel = context.WithTimeout(queryCtx, timeout)
+			defer cancel()
+		}
+		ctx.Set(queryContextKey, queryCtx)
+		ctx.Next()
+	}
+}
+
+// Route groups of the query timeouts in the config
+const (
+	QueryTimeoutAuth           = "auth"
+	QueryTimeoutUsers          = "users"
+	QueryTimeoutUserRoles      = "userRoles"
+	QueryTimeoutUserAttributes = "userAttributes"
+	QueryTimeoutSystem         = "system"
+	QueryTimeoutBootstrap      = "bootstrap"
+)
+
+// queryTimeout of the route group, its timeout in the config or the query timeout of the server
+func (c *controller) queryTimeout(group string) gin.HandlerFunc {
+	timeout, ok := c.Config.Server.QueryTimeouts[group]
+	if !ok {
+		timeout = c.Config.Server.QueryTimeout
+	}
+	return QueryTimeout(timeout.Duration)
+}
+
+// StoreProvider returns the repositories used by the request
+type StoreProvider func(ctx *gin.Context) repositories.Store
+
+// RequestStore is the StoreProvider of the request database of the databases, see requestDB
+func RequestStore(databases *db.Databases) StoreProvider {
+	return func(ctx *gin.Context) repositories.Store {
+		g := requestDB(ctx, databases)
+		if value, ok := ctx.Get(txKey); ok {
+			if rtx := value.(*requestTx); rtx.tx != nil {
+				return repositories.NewTxStore(rtx.tx)
+			}
+		}
+		return repositories.NewGormStore(g)
+	}
+}
+
+// TransactionMiddleware run the handlers in a transaction of the primary database,
+// it is rolled back when the request fails, aborts or panics and committed otherwise.
+// The response is held until the commit, so a failed commit responds with its error
+func TransactionMiddleware(ctx *gin.Context) {
+	rtx := &requestTx{}
+	ctx.Set(txKey, rtx)
+	writer := &bufferedWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = writer
+
+	defer func() {
+		ctx.Writer = writer.ResponseWriter
+		if rtx.tx == nil {
+			return
+		}
+		if recovered := recover(); recovered != nil {
+			rtx.tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	ctx.Next()
+
+	if rtx.tx != nil {
+		if len(ctx.Errors) > 0 || ctx.IsAborted() || writer.Status() >= http.StatusBadRequest {
+			rtx.tx.Rollback()
+		} else if err := rtx.tx.Commit().Error; err != nil {
+			ctx.Error(err)
+			return
+		}
+	}
+	writer.flush()
+}
+
+// bufferedWriter hold the response until flushed
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	written bool
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(data string) (int, error) {
+	w.written = true
+	return w.body.WriteString(data)
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written || w.ResponseWriter.Written()
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) flush() {
+	if !w.written {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// stickyMiddleware make the client read its own writes,
+// the reads go to the primary for the configured duration after a write request
+func (c *controller) stickyMiddleware(ctx *gin.Context) {
+	sticky := c.Databases.Sticky(db.Default)
+	if sticky > 0 && isWriteRequest(ctx.Request) {
+		until := time.Now().Add(sticky).Unix()
+		http.SetCookie(ctx.Writer, &http.Cookie{
+			Name:     stickyCookie,
+			Value:    strconv.FormatInt(until, 10),
+			Path:     "/",
+			MaxAge:   int(sticky / time.Second),
+			HttpOnly: true,
+		})
+	}
+
+	ctx.Next()
+}
+
+func isWriteRequest(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+func isSticky(ctx *gin.Context) bool {
+	value, err := ctx.Cookie(stickyCookie)
+	if err != nil {
+		return false
+	}
+	until, err := strconv.ParseInt(value, 10, 64)
+	return err == nil && time.Now().Unix() < until
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStickyMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(testController(testDependencies()).stickyMiddleware)
+	router.POST("/", func(ctx *gin.Context) {})
+
+	// the default instance has no replica, reads are never sticky
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest(http.MethodPost, "/", nil)
+	router.ServeHTTP(recorder, request)
+	assert.Empty(t, recorder.Header().Get("Set-Cookie"))
+}
+
+func TestIsSticky(t *testing.T) {
+	cases := []struct {
+		name     string
+		cookie   string
+		expected bool
+	}{
+		{"no cookie", "", false},
+		{"invalid cookie", "soon", false},
+		{"expired", strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10), false},
+		{"not expired", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10), true},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+			if testCase.cookie != "" {
+				ctx.Request.AddCookie(&http.Cookie{Name: stickyCookie, Value: testCase.cookie})
+			}
+			assert.Equal(t, testCase.expected, isSticky(ctx))
+		})
+	}
+}
+
+func TestTransactionMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(ErrorMiddleware(logrus.StandardLogger()))
+	router.POST("/commit", TransactionMiddleware, func(ctx *gin.Context) {
+		requestDB(ctx, db.Current()).Exec("UPDATE user SET enabled = ?", true)
+		ctx.PureJSON(http.StatusOK, Response{"success", nil})
+	})
+	router.POST("/fail", TransactionMiddleware, func(ctx *gin.Context) {
+		requestDB(ctx, db.Current()).Exec("UPDATE user SET enabled = ?", true)
+		ctx.AbortWithStatusJSON(http.StatusConflict, jsonErrConflict)
+	})
+	router.POST("/panic", TransactionMiddleware, func(ctx *gin.Context) {
+		requestDB(ctx, db.Current()).Exec("UPDATE user SET enabled = ?", true)
+		panic("handler panic")
+	})
+	router.POST("/untouched", TransactionMiddleware, func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		name         string
+		url          string
+		expectedCode int
+		expectedBody string
+		expect       func(sqlMock sqlmock.Sqlmock)
+	}{
+		{
+			name:         "commit",
+			url:          "/commit",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status": "success", "data": null}`,
+			expect: func(sqlMock sqlmock.Sqlmock) {
+				sqlMock.ExpectBegin()
+				sqlMock.ExpectExec("UPDATE user").WillReturnResult(sqlmock.NewResult(0, 1))
+				sqlMock.ExpectCommit()
+			},
+		},
+		{
+			name:         "handle commit error",
+			url:          "/commit",
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{"status": "error", "message": "Internal server error"}`,
+			expect: func(sqlMock sqlmock.Sqlmock) {
+				sqlMock.ExpectBegin()
+				sqlMock.ExpectExec("UPDATE user").WillReturnResult(sqlmock.NewResult(0, 1))
+				sqlMock.ExpectCommit().WillReturnError(errDummy)
+			},
+		},
+		{
+			name:         "rollback failed request",
+			url:          "/fail",
+			expectedCode: http.StatusConflict,
+			expect: func(sqlMock sqlmock.Sqlmock) {
+				sqlMock.ExpectBegin()
+				sqlMock.ExpectExec("UPDATE user").WillReturnResult(sqlmock.NewResult(0, 1))
+				sqlMock.ExpectRollback()
+			},
+		},
+		{
+			name: "rollback on panic",
+			url:  "/panic",
+			expect: func(sqlMock sqlmock.Sqlmock) {
+				sqlMock.ExpectBegin()
+				sqlMock.ExpectExec("UPDATE user").WillReturnResult(sqlmock.NewResult(0, 1))
+				sqlMock.ExpectRollback()
+			},
+		},
+		{
+			name:         "no query",
+			url:          "/untouched",
+			expectedCode: http.StatusNoContent,
+			expect:       func(sqlMock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sqlMock, teardown := db.SetupTest(db.Default)
+			defer teardown()
+			testCase.expect(sqlMock)
+
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest(http.MethodPost, testCase.url, nil)
+			if testCase.expectedCode == 0 {
+				assert.Panics(t, func() { router.ServeHTTP(recorder, request) })
+			} else {
+				router.ServeHTTP(recorder, request)
+				assert.Equal(t, testCase.expectedCode, recorder.Code)
+			}
+			if testCase.expectedBody != "" {
+				assert.JSONEq(t, testCase.expectedBody, recorder.Body.String())
+			}
+			assert.NoError(t, sqlMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	router := gin.New()
+	router.Use(ErrorMiddleware(logrus.StandardLogger()))
+	handler := func(ctx *gin.Context) {
+		names := []string{}
+		if err := requestDB(ctx, db.Current()).Table("user").Pluck("name", &names).Error; err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.PureJSON(http.StatusOK, Response{"success", names})
+	}
+	router.GET("/slow", QueryTimeout(10*time.Millisecond), handler)
+	router.GET("/fast", QueryTimeout(time.Second), handler)
+	router.GET("/unlimited", QueryTimeout(0), handler)
+
+	cases := []struct {
+		name         string
+		url          string
+		cancel       bool
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "timeout",
+			url:          "/slow",
+			expectedCode: http.StatusGatewayTimeout,
+			expectedBody: `{"status": "error", "message": "Database query timed out"}`,
+		},
+		{
+			name:         "in time",
+			url:          "/fast",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "without timeout",
+			url:          "/unlimited",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "client closed",
+			url:          "/unlimited",
+			cancel:       true,
+			expectedCode: statusClientClosedRequest,
+			expectedBody: `{"status": "error", "message": "Client closed the request"}`,
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sqlMock, teardown := db.SetupTest(db.Default)
+			defer teardown()
+			sqlMock.ExpectQuery("SELECT name").
+				WillDelayFor(50 * time.Millisecond).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if testCase.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			recorder := httptest.NewRecorder()
+			request, _ := http.NewRequest(http.MethodGet, testCase.url, nil)
+			router.ServeHTTP(recorder, request.WithContext(ctx))
+			assert.Equal(t, testCase.expectedCode, recorder.Code)
+			if testCase.expectedBody != "" {
+				assert.JSONEq(t, testCase.expectedBody, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestQueryTimeoutGroup(t *testing.T) {
+	deps := testDependencies()
+	deps.Config.Server.QueryTimeout = config.Duration{Duration: time.Hour}
+	deps.Config.Server.QueryTimeouts = map[string]config.Duration{QueryTimeoutSystem: {Duration: time.Second}}
+	base := testController(deps)
+
+	router := gin.New()
+	handler := func(ctx *gin.Context) {
+		deadline, _ := queryContext(ctx).Deadline()
+		ctx.String(http.StatusOK, strconv.FormatFloat(time.Until(deadline).Hours(), 'f', 0, 64))
+	}
+	router.GET("/system", base.queryTimeout(QueryTimeoutSystem), handler)
+	router.GET("/users", base.queryTimeout(QueryTimeoutUsers), handler)
+
+	response := (&Test{router: router}).Serve(tRequest{Method: http.MethodGet, URL: "/system"})
+	assert.Equal(t, "0", response.Body.String(), "the timeout of the group overrides the server timeout")
+	response = (&Test{router: router}).Serve(tRequest{Method: http.MethodGet, URL: "/users"})
+	assert.Equal(t, "1", response.Body.String(), "the group without a timeout uses the server timeout")
+}
+
+func TestContextError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"deadline", context.DeadlineExceeded, context.DeadlineExceeded},
+		{"wrapped cancel", fmt.Errorf("query: %w", context.Canceled), context.Canceled},
+		{"gorm errors", gorm.Errors{errDummy, context.DeadlineExceeded}, context.DeadlineExceeded},
+		{"driver error", errDummy, nil},
+		{"record not found", gorm.ErrRecordNotFound, nil},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, contextError(testCase.err))
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/health"
+	"github.com/frullah/gin-boilerplate/logging"
+	"github.com/frullah/gin-boilerplate/metrics"
+	"github.com/frullah/gin-boilerplate/ratelimit"
+	"github.com/frullah/gin-boilerplate/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	errMissingConfig    = errors.New("controllers: missing config dependency")
+	errMissingDatabases = errors.New("controllers: missing databases dependency")
+	errMissingStorage   = errors.New("controllers: missing storage dependency")
+)
+
+// Dependencies of the routes, Config, Databases and Storage are required
+// and the other nil dependencies are created for the routes
+type Dependencies struct {
+	Config    *config.Config
+	Databases *db.Databases
+	Storage   storage.Blob
+	// Logger of the requests without the logging middleware, a logger writing to stderr when it is nil
+	Logger *logrus.Logger
+	// Health checkers of the readiness, the databases are checked when it is nil
+	Health *health.Registry
+	// Metrics of the routes, a registry with the pools of the databases when it is nil
+	Metrics *Metrics
+	// TracerProvider of the request, the auth and the query spans, the spans are not recorded when it is nil
+	TracerProvider trace.TracerProvider
+	// RateLimiter store of the rate limits, a store in the memory of the process when it is nil
+	RateLimiter ratelimit.Store
+	// APIKeys issued to the clients of the "apikey" rate limits, the keys of the config when it is nil
+	APIKeys APIKeyStore
+}
+
+// complete check the required dependencies and create the missing ones
+func (d Dependencies) complete() (Dependencies, error) {
+	switch {
+	case d.Config == nil:
+		return d, errMissingConfig
+	case d.Databases == nil:
+		return d, errMissingDatabases
+	case d.Storage == nil:
+		return d, errMissingStorage
+	}
+
+	if d.Logger == nil {
+		d.Logger = logrus.New()
+		d.Logger.Out = os.Stderr
+	}
+	if d.Health == nil {
+		d.Health = health.NewRegistry(d.Config.Health.Timeout.Duration)
+		d.Health.RegisterDatabases(d.Databases)
+	}
+	if d.Metrics == nil {
+		registry := prometheus.NewRegistry()
+		if err := metrics.RegisterDatabases(registry, d.Databases); err != nil {
+			return d, err
+		}
+		routeMetrics, err := NewMetrics(registry)
+		if err != nil {
+			return d, err
+		}
+		d.Metrics = routeMetrics
+	}
+	if d.TracerProvider == nil {
+		d.TracerProvider = trace.NewNoopTracerProvider()
+	}
+	if d.RateLimiter == nil {
+		d.RateLimiter = ratelimit.NewMemoryStore()
+	}
+	if d.APIKeys == nil {
+		d.APIKeys = NewConfigAPIKeys(d.Config.RateLimit.APIKeys)
+	}
+	return d, nil
+}
+
+// controller is embedded by the controllers, it holds the dependencies of the routes
+type controller struct {
+	Dependencies
+	validation *validation
+	tracer     trace.Tracer
+}
+
+// newController with the completed dependencies
+func newController(deps Dependencies) (*controller, error) {
+	deps, err := deps.complete()
+	if err != nil {
+		return nil, err
+	}
+	return &controller{
+		Dependencies: deps,
+		validation:   newValidation(),
+		tracer:       deps.TracerProvider.Tracer(tracerName),
+	}, nil
+}
+
+// logger of the request, it is the entry with the request ID given by logging.Middleware
+// or the logger of the dependencies
+func (c *controller) logger(ctx *gin.Context) logrus.FieldLogger {
+	return requestLogger(ctx, c.Logger)
+}
+
+func requestLogger(ctx *gin.Context, logger logrus.FieldLogger) logrus.FieldLogger {
+	if ctx.Request != nil {
+		if entry, ok := logging.FromContext(ctx.Request.Context()); ok {
+			return entry
+		}
+	}
+	return logger
+}
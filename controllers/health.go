@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/frullah/gin-boilerplate/health"
+	"github.com/gin-gonic/gin"
+)
+
+// HealthController report the liveness and the readiness of the process
+type HealthController struct {
+	*controller
+}
+
+// LoadRoutes of the health to router
+func (c *HealthController) LoadRoutes(router *gin.Engine) {
+	router.GET("/healthz", c.Live)
+	router.GET("/readyz", c.Ready)
+}
+
+// Live handle GET: /healthz, the process is alive when it responds
+// @Success 200 {object} Response
+// @Router /healthz [get]
+func (c *HealthController) Live(ctx *gin.Context) {
+	ctx.PureJSON(http.StatusOK, &Response{"success", gin.H{"status": health.StatusUp}})
+}
+
+// Ready handle GET: /readyz, every registered check must be up,
+// the errors of the down checks are logged and reported as unavailable
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /readyz [get]
+func (c *HealthController) Ready(ctx *gin.Context) {
+	report := c.Health.Check(ctx.Request.Context())
+	if report.Status != health.StatusUp {
+		logger := requestLogger(ctx, c.Logger)
+		for _, result := range report.Checks {
+			if result.Err != nil {
+				logger.WithError(result.Err).WithField("check", result.Name).Warn("readiness check is down")
+			}
+		}
+		ctx.PureJSON(http.StatusServiceUnavailable, &ResponseError{
+			Status:  "error",
+			Message: "Service unavailable",
+			Data:    report,
+		})
+		return
+	}
+	ctx.PureJSON(http.StatusOK, &Response{"success", report})
+}
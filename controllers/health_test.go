@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/health"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	router := SetupRouter()
+	cases := []routeTestCase{
+		{
+			name:         "alive",
+			url:          "/healthz",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status": "success", "data": {"status": "up"}}`,
+		},
+		{
+			name:         "ready",
+			url:          "/readyz",
+			expectedCode: http.StatusOK,
+			db:           dbMockMap{db.Default: {}},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
+
+func TestHealthReadyRegistry(t *testing.T) {
+	registry := health.NewRegistry(0)
+	registry.Register("cache", health.CheckerFunc(func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}))
+	logger, hook := logtest.NewNullLogger()
+	deps := testDependencies()
+	deps.Health = registry
+	deps.Logger = logger
+	router := setupRouter(deps)
+
+	response := (&Test{router: router}).Serve(tRequest{Method: http.MethodGet, URL: "/readyz"})
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	body := response.Body.String()
+	assert.Contains(t, body, `"status":"down"`)
+	assert.Contains(t, body, `"name":"cache"`)
+	assert.Contains(t, body, `"error":"unavailable"`)
+	assert.NotContains(t, body, "connection refused")
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, "cache", entry.Data["check"])
+	assert.EqualError(t, entry.Data[logrus.ErrorKey].(error), "connection refused")
+}
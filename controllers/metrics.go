@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels the requests which match no route, so the raw paths don't become labels
+const unmatchedRoute = "unmatched"
+
+// Metrics of the routes
+type Metrics struct {
+	// Registry gathered by /metrics
+	Registry *prometheus.Registry
+
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	logins         *prometheus.CounterVec
+	tokenRefreshes *prometheus.CounterVec
+	rateLimits     *prometheus.CounterVec
+}
+
+// NewMetrics registered into registry, it fails when they are already registered
+func NewMetrics(registry *prometheus.Registry) (*Metrics, error) {
+	m := &Metrics{
+		Registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "http_request_duration_seconds",
+			Help: "Latency of the HTTP requests.",
+		}, []string{"method", "route", "status"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_logins_total",
+			Help: "Total number of logins by result: success, failure or disabled.",
+		}, []string{"result"}),
+		tokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_token_refreshes_total",
+			Help: "Total number of the token refreshes of the roles middleware by result: success or failure.",
+		}, []string{"result"}),
+		rateLimits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_rate_limited_total",
+			Help: "Total number of the requests rejected by the rate limit of the route group.",
+		}, []string{"group"}),
+	}
+	for _, collector := range []prometheus.Collector{m.requests, m.duration, m.logins, m.tokenRefreshes, m.rateLimits} {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// MetricsController serve the metrics of the routes when they are enabled by the config
+type MetricsController struct {
+	*controller
+	handler http.Handler
+}
+
+func newMetricsController(base *controller) *MetricsController {
+	return &MetricsController{base, promhttp.HandlerFor(base.Metrics.Registry, promhttp.HandlerOpts{
+		ErrorLog: base.Logger,
+	})}
+}
+
+// LoadRoutes of the metrics to router, /metrics is not served when it is disabled
+func (c *MetricsController) LoadRoutes(router *gin.Engine) {
+	if !c.Config.Metrics.Enabled {
+		return
+	}
+	router.GET("/metrics", c.authorize, c.Get)
+}
+
+// authorize the bearer token of the config, the scrapers are trusted when there is no token
+func (c *MetricsController) authorize(ctx *gin.Context) {
+	token := c.Config.Metrics.Token
+	if token == "" {
+		return
+	}
+	given := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, jsonErrUnauthorized)
+	}
+}
+
+// Get handle GET: /metrics in the Prometheus text format
+// @Produce plain
+// @Success 200 {string} string
+// @Failure 401
+// @Router /metrics [get]
+func (c *MetricsController) Get(ctx *gin.Context) {
+	c.handler.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+// metricsMiddleware record the count and the latency of the requests by the route template
+func (c *controller) metricsMiddleware(templates *routeTemplates) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		route, ok := templates.lookup(ctx.Request.Method, ctx.Request.URL.Path, ctx.Params, status != http.StatusNotFound)
+		if !ok {
+			route = unmatchedRoute
+		}
+		m := c.Metrics
+		labels := []string{ctx.Request.Method, route, strconv.Itoa(status)}
+		m.requests.WithLabelValues(labels...).Inc()
+		m.duration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+}
+
+// routeTemplates find the route template of a request, gin doesn't give the matched route
+type routeTemplates struct {
+	router *gin.Engine
+
+	mu sync.RWMutex
+	// routes of every method, split by "/"
+	routes map[string][][]string
+}
+
+// lookup the template of the path which matches the params. The routes are reloaded on a miss when
+// reload is true, so the routes added after the first request are found, the requests which match
+// no route are 404 so they don't reload
+func (r *routeTemplates) lookup(method, path string, params gin.Params, reload bool) (string, bool) {
+	r.mu.RLock()
+	loaded := r.routes != nil
+	route, ok := matchRoute(r.routes[method], strings.Split(path, "/"), params)
+	r.mu.RUnlock()
+	if ok || (loaded && !reload) {
+		return route, ok
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = map[string][][]string{}
+	for _, info := range r.router.Routes() {
+		r.routes[info.Method] = append(r.routes[info.Method], strings.Split(info.Path, "/"))
+	}
+	return matchRoute(r.routes[method], strings.Split(path, "/"), params)
+}
+
+func matchRoute(routes [][]string, path []string, params gin.Params) (string, bool) {
+	for _, route := range routes {
+		if matchSegments(route, path, params) {
+			return strings.Join(route, "/"), true
+		}
+	}
+	return "", false
+}
+
+// matchSegments is true when the static segments equal the path and the parameters equal the params
+func matchSegments(route, path []string, params gin.Params) bool {
+	for i, segment := range route {
+		if strings.HasPrefix(segment, "*") {
+			// the catch-all value is the rest of the path with its leading slash
+			value, ok := params.Get(segment[1:])
+			return ok && i <= len(path) && value == "/"+strings.Join(path[i:], "/")
+		}
+		if i >= len(path) {
+			return false
+		}
+		if strings.HasPrefix(segment, ":") {
+			if value, _ := params.Get(segment[1:]); value != path[i] {
+				return false
+			}
+		} else if segment != path[i] {
+			return false
+		}
+	}
+	return len(route) == len(path)
+}
+
+// login record the result of a login
+func (m *Metrics) login(result string) {
+	m.logins.WithLabelValues(result).Inc()
+}
+
+// tokenRefresh record the result of a token refresh
+func (m *Metrics) tokenRefresh(result string) {
+	m.tokenRefreshes.WithLabelValues(result).Inc()
+}
+
+// rateLimited record a request rejected by the rate limit of the group
+func (m *Metrics) rateLimited(group string) {
+	m.rateLimits.WithLabelValues(group).Inc()
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	provider := func(*gin.Context) repositories.Store { return store }
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	role := &models.UserRole{Name: "member", Enabled: true}
+	require.NoError(t, store.Roles().Create(role))
+	require.NoError(t, store.Users().Create(&models.User{
+		Email: "alice@example.com", Username: "alice", Password: string(hashedPassword), RoleID: role.ID, Enabled: true,
+	}))
+
+	m := newTestMetrics(t)
+	deps := testDependencies()
+	deps.Config.Metrics.Enabled = true
+	deps.Metrics = m
+	base := testController(deps)
+	router := gin.New()
+	router.Use(base.metricsMiddleware(&routeTemplates{router: router}), ErrorMiddleware(base.Logger))
+	newAuthController(base, provider).LoadRoutes(router)
+	newUserController(base, provider).LoadRoutes(router)
+	newMetricsController(base).LoadRoutes(router)
+	router.GET("/files/*key", func(ctx *gin.Context) { ctx.Status(http.StatusNoContent) })
+
+	test := &Test{router: router}
+	login := func(password string) int {
+		return test.Serve(tRequest{
+			Method: http.MethodPost,
+			URL:    "/auth/login",
+			data:   tRequestData{Body: `{"username": "alice", "password": "` + password + `"}`},
+		}).Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, login("wrong"))
+	assert.Equal(t, http.StatusOK, login("secret"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.logins.WithLabelValues("failure")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.logins.WithLabelValues("success")))
+
+	for _, url := range []string{"/users/1", "/users/2", "/files/a/b", "/files/", "/missing"} {
+		test.Serve(tRequest{Method: http.MethodGet, URL: url})
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodPost, "/auth/login", "401")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, "/users/:id", "401")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, "/files/*key", "204")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+	assert.Equal(t, uint64(1), histogramCount(t, m.duration.WithLabelValues(http.MethodPost, "/auth/login", "200")))
+
+	response := test.Serve(tRequest{Method: http.MethodGet, URL: "/metrics"})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	body := response.Body.String()
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/users/:id",status="401"} 2`)
+	assert.Contains(t, body, `auth_logins_total{result="success"} 1`)
+	assert.False(t, strings.Contains(body, "/users/1"), "the raw paths are not labels")
+}
+
+func TestMetricsController(t *testing.T) {
+	deps := testDependencies()
+	deps.Metrics = newTestMetrics(t)
+	get := func(token string) int {
+		router := gin.New()
+		newMetricsController(testController(deps)).LoadRoutes(router)
+		request := tRequest{Method: http.MethodGet, URL: "/metrics"}
+		if token != "" {
+			request.data.header = http.Header{"Authorization": []string{"Bearer " + token}}
+		}
+		return (&Test{router: router}).Serve(request).Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, get(""), "disabled by default")
+	deps.Config.Metrics.Enabled = true
+	assert.Equal(t, http.StatusOK, get(""))
+	deps.Config.Metrics.Token = "metrics-token"
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+	assert.Equal(t, http.StatusUnauthorized, get("invalid"))
+	assert.Equal(t, http.StatusOK, get("metrics-token"))
+}
+
+func TestMetricsTokenRefresh(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	provider := func(*gin.Context) repositories.Store { return store }
+	role := &models.UserRole{Name: "member", Enabled: true}
+	require.NoError(t, store.Roles().Create(role))
+	user := &models.User{Email: "alice@example.com", Username: "alice", RoleID: role.ID, Enabled: true}
+	require.NoError(t, store.Users().Create(user))
+
+	m := newTestMetrics(t)
+	deps := testDependencies()
+	deps.Metrics = m
+	router := gin.New()
+	router.GET("/", newAuthController(testController(deps), provider).RolesMiddleware(nil))
+
+	test := &Test{router: router}
+	expiredAccessToken := makeJWT(*user.ID, -time.Second, accessTokenSecret)
+	refresh := func(refreshToken string) int {
+		return test.Serve(tRequest{Method: http.MethodGet, URL: "/", data: tRequestData{header: http.Header{
+			AccessTokenHeader:  []string{expiredAccessToken},
+			RefreshTokenHeader: []string{refreshToken},
+		}}}).Code
+	}
+	assert.Equal(t, http.StatusOK, refresh(makeRefreshToken(*user.ID)))
+	assert.Equal(t, http.StatusUnauthorized, refresh("invalid"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("failure")))
+}
+
+func newTestMetrics(t *testing.T) *Metrics {
+	t.Helper()
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+	return m
+}
+
+func histogramCount(t *testing.T, observer prometheus.Observer) uint64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	require.NoError(t, observer.(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestMatchSegments(t *testing.T) {
+	split := func(path string) []string { return strings.Split(path, "/") }
+	params := gin.Params{{Key: "id", Value: "users"}}
+	assert.False(t, matchSegments(split("/users/:id"), split("/users"), params))
+	assert.True(t, matchSegments(split("/users/:id"), split("/users/users"), params))
+	assert.False(t, matchSegments(split("/users/:id/avatar"), split("/users/users"), params))
+	assert.False(t, matchSegments(split("/users/me"), split("/users/users"), nil))
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/gin-gonic/gin"
+)
+
+// PaginationOptions of the list endpoint
+type PaginationOptions struct {
+	DefaultLimit int
+	MaxLimit     int
+	// SortColumns maps the "sort" query value to the column name
+	SortColumns map[string]string
+	DefaultSort string
+}
+
+// Pagination parsed from the query parameters
+// "page", "limit" and "sort" (prefix with "-" to sort descending)
+type Pagination struct {
+	Page  int
+	Limit int
+	Sort  string
+	Desc  bool
+
+	column string
+}
+
+// PageMeta of the list response
+type PageMeta struct {
+	Page       int       `json:"page"`
+	Limit      int       `json:"limit"`
+	TotalPages int       `json:"totalPages"`
+	Links      PageLinks `json:"links"`
+}
+
+// PageLinks to the other pages, empty when there is no such page
+type PageLinks struct {
+	Self  string `json:"self"`
+	First string `json:"first"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last"`
+}
+
+// PageData is the data of the list response
+type PageData struct {
+	Count      uint64      `json:"count"`
+	Items      interface{} `json:"items"`
+	Pagination PageMeta    `json:"pagination"`
+}
+
+const (
+	defaultPageLimit = 25
+	maxPageLimit     = 100
+)
+
+// parsePagination from the query, responds with 400 when the query is not valid
+func parsePagination(ctx *gin.Context, options PaginationOptions) (*Pagination, bool) {
+	if options.DefaultLimit == 0 {
+		options.DefaultLimit = defaultPageLimit
+	}
+	if options.MaxLimit == 0 {
+		options.MaxLimit = maxPageLimit
+	}
+
+	fieldErrors := FieldError{}
+	pagination := &Pagination{Page: 1, Limit: options.DefaultLimit}
+
+	if value := ctx.Query("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			fieldErrors["page"] = "page must be a number greater than 0"
+		}
+		pagination.Page = page
+	}
+
+	if value := ctx.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			fieldErrors["limit"] = "limit must be a number greater than 0"
+		}
+		if limit > options.MaxLimit {
+			limit = options.MaxLimit
+		}
+		pagination.Limit = limit
+	}
+
+	// the offset of the page must fit into an int
+	if fieldErrors["page"] == "" && fieldErrors["limit"] == "" &&
+		pagination.Page-1 > math.MaxInt/pagination.Limit {
+		fieldErrors["page"] = "page is out of range"
+	}
+
+	sortValue := ctx.DefaultQuery("sort", options.DefaultSort)
+	if sortValue != "" {
+		pagination.Desc = strings.HasPrefix(sortValue, "-")
+		pagination.Sort = strings.TrimPrefix(sortValue, "-")
+		column, ok := options.SortColumns[pagination.Sort]
+		if !ok {
+			names := make([]string, 0, len(options.SortColumns))
+			for name := range options.SortColumns {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fieldErrors["sort"] = "sort must be one of [" + strings.Join(names, " ") + "]"
+		}
+		pagination.column = column
+	}
+
+	if len(fieldErrors) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{"fail", fieldErrors})
+		return nil, false
+	}
+	return pagination, true
+}
+
+// ListOptions of the repositories with the offset, limit and order of the page
+func (p *Pagination) ListOptions() repositories.ListOptions {
+	return repositories.ListOptions{
+		Offset: (p.Page - 1) * p.Limit,
+		Limit:  p.Limit,
+		Sort:   p.column,
+		Desc:   p.Desc,
+	}
+}
+
+// Meta of the page with the links to the other pages
+func (p *Pagination) Meta(ctx *gin.Context, total uint64) PageMeta {
+	totalPages := int((total + uint64(p.Limit) - 1) / uint64(p.Limit))
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	link := func(page int) string {
+		url := *ctx.Request.URL
+		query := url.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("limit", strconv.Itoa(p.Limit))
+		url.RawQuery = query.Encode()
+		return url.RequestURI()
+	}
+
+	links := PageLinks{
+		Self:  link(p.Page),
+		First: link(1),
+		Last:  link(totalPages),
+	}
+	if p.Page > 1 {
+		links.Prev = link(p.Page - 1)
+	}
+	if p.Page < totalPages {
+		links.Next = link(p.Page + 1)
+	}
+
+	return PageMeta{
+		Page:       p.Page,
+		Limit:      p.Limit,
+		TotalPages: totalPages,
+		Links:      links,
+	}
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of the client IP set by the reverse proxies
+const (
+	ForwardedForHeader = "X-Forwarded-For"
+	RealIPHeader       = "X-Real-Ip"
+)
+
+// parseTrustedProxies of the config, a single IP is a network of that IP only
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("controllers: invalid trusted proxy %q", proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// trustProxies of the config, gin take the client IP of the forwarded headers of any client
+// so they are ignored without trusted proxies and cleaned by trustedProxiesMiddleware otherwise
+func trustProxies(router *gin.Engine, trusted []string) error {
+	proxies, err := parseTrustedProxies(trusted)
+	if err != nil {
+		return err
+	}
+	router.ForwardedByClientIP = len(proxies) > 0
+	if len(proxies) > 0 {
+		router.Use(trustedProxiesMiddleware(proxies))
+	}
+	return nil
+}
+
+// trustedProxiesMiddleware replace X-Forwarded-For with the client IP and remove X-Real-Ip,
+// so gin.Context.ClientIP can't be spoofed. The hops of X-Forwarded-For are only honoured
+// from the right while they are added by a trusted proxy, the first untrusted hop is the client
+func trustedProxiesMiddleware(proxies []*net.IPNet) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.Request.Header
+		defer header.Del(RealIPHeader)
+
+		host, _, err := net.SplitHostPort(strings.TrimSpace(ctx.Request.RemoteAddr))
+		client := net.ParseIP(host)
+		if err != nil || client == nil {
+			header.Del(ForwardedForHeader)
+			return
+		}
+
+		hops := strings.Split(strings.Join(header[ForwardedForHeader], ","), ",")
+		for i := len(hops) - 1; i >= 0 && trustedProxy(client, proxies); i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			client = hop
+		}
+		header.Set(ForwardedForHeader, client.String())
+	}
+}
+
+func trustedProxy(ip net.IP, proxies []*net.IPNet) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of the rate limit, see the RateLimit header fields draft of the IETF
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+	// APIKeyHeader of the clients limited by their API key
+	APIKeyHeader = "X-API-Key"
+)
+
+// Route groups of the rate limits in the config
+const (
+	RateLimitLogin        = "login"
+	RateLimitRegister     = "register"
+	RateLimitAvailability = "availability"
+)
+
+// APIKeyStore of the API keys issued to the clients
+type APIKeyStore interface {
+	// Verify the API key, the client name is returned when the key is issued
+	Verify(apiKey string) (client string, ok bool)
+}
+
+// ConfigAPIKeys of the config, the client names by the SHA-256 hex digest of their key
+type ConfigAPIKeys map[string]string
+
+// NewConfigAPIKeys of the rate limit config, the keys are given by client name
+func NewConfigAPIKeys(digests map[string]string) ConfigAPIKeys {
+	keys := ConfigAPIKeys{}
+	for client, digest := range digests {
+		keys[strings.ToLower(digest)] = client
+	}
+	return keys
+}
+
+// Verify the digest of the API key is issued
+func (k ConfigAPIKeys) Verify(apiKey string) (string, bool) {
+	sum := sha256.Sum256([]byte(apiKey))
+	client, ok := k[hex.EncodeToString(sum[:])]
+	return client, ok
+}
+
+var jsonErrTooManyRequests = &ResponseError{
+	Status:  "error",
+	Message: "Too many requests",
+}
+
+// rateLimit the requests of the route group with its limit in the config, the group without a limit
+// or with an invalid limit isn't limited. The requests limited by the user must be authorized before.
+// The requests are allowed when the store fails, so an unavailable store doesn't take the routes down
+func (c *controller) rateLimit(group string) gin.HandlerFunc {
+	limitConfig, ok := c.Config.RateLimit.Groups[group]
+	if !ok {
+		return func(*gin.Context) {}
+	}
+	limit, err := ratelimit.NewLimit(limitConfig)
+	if err != nil {
+		c.Logger.WithError(err).WithField("group", group).Warn("invalid rate limit, the group isn't limited")
+		return func(*gin.Context) {}
+	}
+
+	return func(ctx *gin.Context) {
+		key := group + ":" + c.rateLimitKey(ctx, limitConfig.Key)
+		result, err := c.RateLimiter.Take(queryContext(ctx), key, limit)
+		if err != nil {
+			c.logger(ctx).WithError(err).WithField("group", group).Warn("rate limit failed, the request is allowed")
+			return
+		}
+
+		ctx.Header(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+		ctx.Header(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		ctx.Header(RateLimitResetHeader, formatSeconds(result.Reset))
+		if !result.Allowed {
+			c.Metrics.rateLimited(group)
+			ctx.Header(RetryAfterHeader, formatSeconds(result.RetryAfter))
+			ctx.PureJSON(http.StatusTooManyRequests, jsonErrTooManyRequests)
+			ctx.Abort()
+		}
+	}
+}
+
+// rateLimitKey of the client by the key of the limit, the user and the API key fall back to the IP.
+// Only the issued API keys have their own bucket, so a client can't get a new bucket with any key
+func (c *controller) rateLimitKey(ctx *gin.Context, key string) string {
+	switch key {
+	case "user":
+		if userID, ok := ctx.Get("userID"); ok {
+			return "user:" + strconv.FormatUint(userID.(uint64), 10)
+		}
+	case "apikey":
+		if apiKey := ctx.GetHeader(APIKeyHeader); apiKey != "" {
+			if client, ok := c.APIKeys.Verify(apiKey); ok {
+				return "apikey:" + client
+			}
+		}
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+// formatSeconds round the duration up to whole seconds
+func formatSeconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(duration.Seconds())), 10)
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/ratelimit"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	cnf := &config.Config{}
+	cnf.RateLimit.Groups = map[string]config.Limit{
+		RateLimitLogin: {Requests: 2, Period: config.Duration{Duration: time.Minute}},
+	}
+	m := newTestMetrics(t)
+	logs := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = logs
+	newRouter := func(limiter ratelimit.Store) *gin.Engine {
+		deps := testDependencies()
+		deps.Config, deps.Metrics, deps.RateLimiter, deps.Logger = cnf, m, limiter, logger
+		return memoryRouterWith(testController(deps), store)
+	}
+	test := &Test{router: newRouter(ratelimit.NewMemoryStore())}
+	login := func() *httptest.ResponseRecorder {
+		return test.Serve(tRequest{
+			Method: http.MethodPost,
+			URL:    "/auth/login",
+			data:   tRequestData{Body: `{"username": "alice", "password": "secret"}`},
+		})
+	}
+
+	response := login()
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Equal(t, "2", response.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "1", response.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "30", response.Header().Get(RateLimitResetHeader))
+	assert.Empty(t, response.Header().Get(RetryAfterHeader))
+
+	assert.Equal(t, http.StatusUnauthorized, login().Code)
+	response = login()
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.JSONEq(t, `{"status":"error","message":"Too many requests"}`, response.Body.String())
+	assert.Equal(t, "0", response.Header().Get(RateLimitRemainingHeader))
+	assert.Equal(t, "30", response.Header().Get(RetryAfterHeader))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.rateLimits.WithLabelValues(RateLimitLogin)))
+
+	// the groups without a limit aren't limited
+	for i := 0; i < 3; i++ {
+		response = test.Serve(tRequest{Method: http.MethodGet, URL: "/user-availibility?context=username&value=alice"})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Empty(t, response.Header().Get(RateLimitLimitHeader))
+	}
+
+	// the requests are allowed when the store fails
+	test = &Test{router: newRouter(failingStore{})}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login().Code)
+	}
+	assert.Contains(t, logs.String(), "store unavailable")
+}
+
+func TestRateLimitSpoofing(t *testing.T) {
+	newRouter := func(modify func(cnf *config.Config)) *gin.Engine {
+		deps := testDependencies()
+		deps.Config.RateLimit.Groups = map[string]config.Limit{
+			RateLimitLogin: {Requests: 1, Period: config.Duration{Duration: time.Minute}},
+		}
+		modify(deps.Config)
+		deps.Metrics = newTestMetrics(t)
+		return memoryRouterWith(testController(deps), repositories.NewMemoryStore())
+	}
+	login := func(router *gin.Engine, header http.Header) int {
+		request := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username": "alice", "password": "secret"}`))
+		request.RemoteAddr = "192.0.2.1:1234"
+		for name, values := range header {
+			for _, value := range values {
+				request.Header.Add(name, value)
+			}
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Code
+	}
+	forwardedFor := func(value string) http.Header {
+		return http.Header{ForwardedForHeader: {value}}
+	}
+
+	t.Run("forwarded headers without trusted proxy", func(t *testing.T) {
+		router := newRouter(func(*config.Config) {})
+		assert.Equal(t, http.StatusUnauthorized, login(router, forwardedFor("198.51.100.1")))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, forwardedFor("198.51.100.2")))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, http.Header{RealIPHeader: {"198.51.100.3"}}))
+	})
+
+	t.Run("forwarded headers of a trusted proxy", func(t *testing.T) {
+		router := newRouter(func(cnf *config.Config) {
+			cnf.Server.TrustedProxies = []string{"192.0.2.0/24"}
+		})
+		assert.Equal(t, http.StatusUnauthorized, login(router, forwardedFor("198.51.100.1")))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, forwardedFor("198.51.100.1")))
+		// the client prepends a spoofed hop, the proxy appends the real client
+		assert.Equal(t, http.StatusTooManyRequests, login(router, forwardedFor("203.0.113.1, 198.51.100.1")))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, http.Header{
+			ForwardedForHeader: {"198.51.100.1"},
+			RealIPHeader:       {"203.0.113.2"},
+		}))
+		assert.Equal(t, http.StatusUnauthorized, login(router, forwardedFor("198.51.100.2")))
+	})
+
+	t.Run("api key not issued", func(t *testing.T) {
+		router := newRouter(func(cnf *config.Config) {
+			limit := cnf.RateLimit.Groups[RateLimitLogin]
+			limit.Key = "apikey"
+			cnf.RateLimit.Groups[RateLimitLogin] = limit
+			cnf.RateLimit.APIKeys = map[string]string{"partner": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}
+		})
+		assert.Equal(t, http.StatusUnauthorized, login(router, http.Header{APIKeyHeader: {"forged-1"}}))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, http.Header{APIKeyHeader: {"forged-2"}}))
+		// the issued key has its own bucket
+		assert.Equal(t, http.StatusUnauthorized, login(router, http.Header{APIKeyHeader: {"secret"}}))
+		assert.Equal(t, http.StatusTooManyRequests, login(router, http.Header{APIKeyHeader: {"secret"}}))
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		router := newRouter(func(cnf *config.Config) {
+			cnf.RateLimit.Groups[RateLimitLogin] = config.Limit{Period: config.Duration{Duration: time.Minute}}
+		})
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login(router, nil))
+		}
+	})
+}
+
+func TestRateLimitKey(t *testing.T) {
+	base := testController(Dependencies{
+		Config:    &config.Config{},
+		Databases: db.Current(),
+		Storage:   testDependencies().Storage,
+		APIKeys: NewConfigAPIKeys(map[string]string{
+			"partner": "2BB80D537B1DA3E38BD30361AA855686BDE0EACD7162FEF6A25FE97BF527A25B",
+		}),
+	})
+	newContext := func(apiKey string) *gin.Context {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx.Request.RemoteAddr = "192.0.2.1:1234"
+		if apiKey != "" {
+			ctx.Request.Header.Set(APIKeyHeader, apiKey)
+		}
+		return ctx
+	}
+
+	ctx := newContext("")
+	assert.Equal(t, "ip:192.0.2.1", base.rateLimitKey(ctx, ""))
+	assert.Equal(t, "ip:192.0.2.1", base.rateLimitKey(ctx, "user"))
+	assert.Equal(t, "ip:192.0.2.1", base.rateLimitKey(ctx, "apikey"))
+	ctx.Set("userID", uint64(7))
+	assert.Equal(t, "user:7", base.rateLimitKey(ctx, "user"))
+	assert.Equal(t, "ip:192.0.2.1", base.rateLimitKey(ctx, "ip"))
+
+	// the issued API key is the bucket of its client, the other keys fall back to the IP
+	assert.Equal(t, "apikey:partner", base.rateLimitKey(newContext("secret"), "apikey"))
+	assert.Equal(t, "ip:192.0.2.1", base.rateLimitKey(newContext("forged"), "apikey"))
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const systemURL = "/system"
+
+// SystemController report the state of the system to the administrators
+type SystemController struct {
+	*controller
+	auth *AuthController
+}
+
+func newSystemController(base *controller, store StoreProvider) *SystemController {
+	return &SystemController{base, newAuthController(base, store)}
+}
+
+// LoadRoutes of the system to router
+func (c *SystemController) LoadRoutes(router *gin.Engine) {
+	authorized := router.Group(systemURL, c.queryTimeout(QueryTimeoutSystem))
+	authorized.Use(c.auth.RolesMiddleware(map[string]struct{}{
+		"administrator": {},
+	}))
+	authorized.GET("/db-stats", c.DBStats)
+}
+
+// DBStats handle GET: /system/db-stats
+// @Success 200 {array} db.PoolStats
+// @Failure 401
+// @Failure 403
+// @Router /system/db-stats [get]
+func (c *SystemController) DBStats(ctx *gin.Context) {
+	ctx.PureJSON(http.StatusOK, &Response{"success", c.Databases.Stats()})
+}
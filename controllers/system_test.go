@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/db"
+)
+
+func TestSystemDBStats(t *testing.T) {
+	router := SetupRouter()
+	cases := []routeTestCase{
+		{
+			name:         "not allowed role",
+			url:          "/system/db-stats",
+			expectedCode: http.StatusUnauthorized,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("user"),
+				},
+			},
+		},
+		{
+			name:         "administrator",
+			url:          "/system/db-stats",
+			expectedCode: http.StatusOK,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
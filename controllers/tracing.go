@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/frullah/gin-boilerplate/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName of the request and the auth spans
+const tracerName = "github.com/frullah/gin-boilerplate/controllers"
+
+// tracingMiddleware start the server span of the request, it continues the W3C trace context of the headers.
+// The span is named by the route template once the route is handled
+func (c *controller) tracingMiddleware(templates *routeTemplates) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		request := ctx.Request
+		parent := tracing.Propagator.Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+		spanCtx, span := c.tracer.Start(parent, request.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(request.Method),
+				semconv.HTTPTargetKey.String(request.URL.RequestURI()),
+			),
+		)
+		defer span.End()
+		ctx.Request = request.WithContext(spanCtx)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		if route, ok := templates.lookup(request.Method, request.URL.Path, ctx.Params, status != http.StatusNotFound); ok {
+			span.SetName(request.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if lastError := ctx.Errors.Last(); lastError != nil {
+			span.RecordError(lastError.Err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// startQuerySpan start a span whose context is used by the queries of the request until it ends
+func (c *controller) startQuerySpan(ctx *gin.Context, name string) (end func()) {
+	queryCtx := queryContext(ctx)
+	spanCtx, span := c.tracer.Start(queryCtx, name)
+	ctx.Set(queryContextKey, spanCtx)
+	return func() {
+		span.End()
+		ctx.Set(queryContextKey, queryCtx)
+	}
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/gin-gonic/gin"
+)
+
+// UserAttributeBody ...
+type UserAttributeBody struct {
+	Name     string   `json:"name" binding:"required,max=64"`
+	Type     string   `json:"type" binding:"required,oneof=string number boolean"`
+	Required bool     `json:"required"`
+	Pattern  string   `json:"pattern" binding:"max=255"`
+	Enum     []string `json:"enum"`
+}
+
+const (
+	userAttributeURL    = "/user-attributes"
+	userAttributePrefix = "attributes."
+)
+
+// UserAttributeController handle the definitions of the custom user attributes
+type UserAttributeController struct {
+	*controller
+	auth *AuthController
+}
+
+func newUserAttributeController(base *controller, store StoreProvider) *UserAttributeController {
+	return &UserAttributeController{base, newAuthController(base, store)}
+}
+
+// LoadRoutes of the user attributes to router
+func (c *UserAttributeController) LoadRoutes(router *gin.Engine) {
+	routes := router.Group(userAttributeURL, c.queryTimeout(QueryTimeoutUserAttributes))
+	authorized := routes.Group("")
+	authorized.Use(c.auth.RolesMiddleware(map[string]struct{}{
+		"administrator": {},
+	}))
+	authorized.GET("/:id", c.GetOne)
+	authorized.GET("", c.GetMany)
+	authorized.PUT("/:id", c.Update)
+	authorized.DELETE("/:id", c.Delete)
+	authorized.POST("", c.CreateOne)
+}
+
+// CreateOne handle POST: /user-attributes
+func (c *UserAttributeController) CreateOne(ctx *gin.Context) {
+	data := UserAttributeBody{}
+	if !c.validation.bindJSON(ctx, &data) {
+		return
+	}
+	if !checkUserAttributeBody(ctx, &data) {
+		return
+	}
+
+	attribute := models.UserAttribute{
+		Name:     data.Name,
+		Type:     data.Type,
+		Required: data.Required,
+		Pattern:  data.Pattern,
+		Enum:     data.Enum,
+	}
+	if err := c.getDB(ctx).
+		Create(&attribute).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", IntID{int(attribute.ID)}})
+}
+
+// Update handle PUT /user-attributes/:id
+func (c *UserAttributeController) Update(ctx *gin.Context) {
+	id, err := mustParseUintParam(ctx, "id", 32)
+	if err != nil {
+		return
+	}
+
+	data := UserAttributeBody{}
+	if !c.validation.bindJSON(ctx, &data) {
+		return
+	}
+	if !checkUserAttributeBody(ctx, &data) {
+		return
+	}
+
+	// update with a map, so the flags and the enum can be cleared
+	if err := c.getDB(ctx).
+		Model(&models.UserAttribute{ID: uint32(id)}).
+		Updates(map[string]interface{}{
+			"name":     data.Name,
+			"type":     data.Type,
+			"required": data.Required,
+			"pattern":  data.Pattern,
+			"enum":     models.StringList(data.Enum),
+		}).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
+}
+
+// Delete handle DELETE /user-attributes/:id
+func (c *UserAttributeController) Delete(ctx *gin.Context) {
+	id, err := mustParseUintParam(ctx, "id", 32)
+	if err != nil {
+		return
+	}
+
+	if err := c.getDB(ctx).
+		Delete(&models.UserAttribute{}, uint32(id)).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
+}
+
+// GetOne handle GET /user-attributes/:id
+func (c *UserAttributeController) GetOne(ctx *gin.Context) {
+	id, err := mustParseUintParam(ctx, "id", 32)
+	if err != nil {
+		return
+	}
+
+	attribute := &models.UserAttribute{}
+	if err := c.getDB(ctx).
+		First(attribute, uint32(id)).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", attribute})
+}
+
+// GetMany handle GET /user-attributes
+func (c *UserAttributeController) GetMany(ctx *gin.Context) {
+	attributes := []models.UserAttribute{}
+	if err := c.getDB(ctx).
+		Order("name").
+		Find(&attributes).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", attributes})
+}
+
+// checkUserAttributeBody validate the definition which can't be described by the binding tags
+func checkUserAttributeBody(ctx *gin.Context, data *UserAttributeBody) bool {
+	fieldErrors := FieldError{}
+	if !models.ValidUserAttributeName(data.Name) {
+		fieldErrors["name"] = "name must start with a lowercase letter and contain only lowercase letters, digits or underscores"
+	}
+	if data.Pattern != "" {
+		if data.Type != models.UserAttributeString {
+			fieldErrors["pattern"] = "pattern is only allowed for string type"
+		} else if _, err := regexp.Compile(data.Pattern); err != nil {
+			fieldErrors["pattern"] = "pattern must be a valid regular expression"
+		}
+	}
+	if len(data.Enum) > 0 && data.Type != models.UserAttributeString {
+		fieldErrors["enum"] = "enum is only allowed for string type"
+	}
+
+	if len(fieldErrors) > 0 {
+		ctx.Error(fieldErrors).SetType(gin.ErrorTypeBind)
+		ctx.Abort()
+		return false
+	}
+	return true
+}
+
+// validateUserAttributes against the attribute definitions,
+// the error is written into the context when validation failed
+func (c *controller) validateUserAttributes(ctx *gin.Context, attributes models.Attributes) bool {
+	definitions := []models.UserAttribute{}
+	if err := c.getDB(ctx).
+		Find(&definitions).
+		Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return false
+	}
+
+	fieldErrors := FieldError{}
+	known := map[string]struct{}{}
+	for _, definition := range definitions {
+		known[definition.Name] = struct{}{}
+		field := userAttributePrefix + definition.Name
+		value, exists := attributes[definition.Name]
+		if !exists || value == nil {
+			if definition.Required {
+				fieldErrors[field] = c.validation.translate("required", field)
+			}
+			continue
+		}
+
+		if msg := c.validation.checkUserAttributeValue(field, &definition, value); msg != "" {
+			fieldErrors[field] = msg
+		}
+	}
+
+	for name := range attributes {
+		if _, ok := known[name]; !ok {
+			field := userAttributePrefix + name
+			fieldErrors[field] = c.validation.translate("attribute-unknown", field)
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		ctx.Error(fieldErrors).SetType(gin.ErrorTypeBind)
+		ctx.Abort()
+		return false
+	}
+	return true
+}
+
+func (v *validation) checkUserAttributeValue(field string, definition *models.UserAttribute, value interface{}) string {
+	typeError := func() string {
+		return v.translate("attribute-type", field, definition.Type)
+	}
+
+	switch definition.Type {
+	case models.UserAttributeNumber:
+		if _, ok := value.(float64); !ok {
+			return typeError()
+		}
+	case models.UserAttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return typeError()
+		}
+	default:
+		str, ok := value.(string)
+		if !ok {
+			return typeError()
+		}
+		if str == "" && definition.Required {
+			return v.translate("required", field)
+		}
+		if len(definition.Enum) > 0 && !containsString(definition.Enum, str) {
+			return v.translate("oneof", field, strings.Join(definition.Enum, " "))
+		}
+		if definition.Pattern != "" {
+			pattern, err := regexp.Compile(definition.Pattern)
+			if err != nil || !pattern.MatchString(str) {
+				return v.translate("attribute-pattern", field)
+			}
+		}
+	}
+
+	return ""
+}
+
+// userAttributeFilter from the "attributes.<name>=<value>" query parameters,
+// the values are parsed by the type of the attribute definitions
+func (c *controller) userAttributeFilter(ctx *gin.Context) (map[string]interface{}, bool) {
+	values := map[string]string{}
+	for key := range ctx.Request.URL.Query() {
+		if !strings.HasPrefix(key, userAttributePrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, userAttributePrefix)
+		if !models.ValidUserAttributeName(name) {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{
+				"fail",
+				FieldError{key: "attribute name is not valid"},
+			})
+			return nil, false
+		}
+		values[name] = ctx.Query(key)
+	}
+
+	filter := map[string]interface{}{}
+	if len(values) == 0 {
+		return filter, true
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	definitions := []models.UserAttribute{}
+	if err := c.getDB(ctx).Where("name IN (?)", names).Find(&definitions).Error; err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return nil, false
+	}
+	types := map[string]string{}
+	for _, definition := range definitions {
+		types[definition.Name] = definition.Type
+	}
+
+	fieldErrors := FieldError{}
+	for name, value := range values {
+		field := userAttributePrefix + name
+		attributeType, ok := types[name]
+		if !ok {
+			fieldErrors[field] = c.validation.translate("attribute-unknown", field)
+			continue
+		}
+
+		var err error
+		switch attributeType {
+		case models.UserAttributeNumber:
+			filter[name], err = strconv.ParseFloat(value, 64)
+		case models.UserAttributeBoolean:
+			filter[name], err = strconv.ParseBool(value)
+		default:
+			filter[name] = value
+		}
+		if err != nil {
+			fieldErrors[field] = c.validation.translate("attribute-type", field, attributeType)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{"fail", fieldErrors})
+		return nil, false
+	}
+	return filter, true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
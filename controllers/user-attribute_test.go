@@ -0,0 +1,403 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/frullah/gin-boilerplate/db"
+)
+
+func userAttributeRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "type", "required", "pattern", "enum"}).
+		AddRow(uint32(1), "phone", "string", true, `^\+?[0-9]{6,15}$`, nil).
+		AddRow(uint32(2), "locale", "string", false, "", `["en","id"]`).
+		AddRow(uint32(3), "floor", "number", false, "", nil)
+}
+
+func TestUserAttributeCreateOne(t *testing.T) {
+	const url = "/user-attributes"
+	const method = http.MethodPost
+
+	router := SetupRouter()
+	cases := []routeTestCase{
+		// client error cases
+		{
+			name:         "invalid definition",
+			url:          url,
+			method:       method,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"name": "name must start with a lowercase letter and contain only lowercase letters, digits or underscores",
+					"pattern": "pattern is only allowed for string type",
+					"enum": "enum is only allowed for string type"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "Phone Number", "type": "number", "pattern": "[0-9]+", "enum": ["1"]}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		{
+			name:         "invalid pattern",
+			url:          url,
+			method:       method,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"pattern": "pattern must be a valid regular expression"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "phone", "type": "string", "pattern": "[0-9"}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		{
+			name:         "invalid type",
+			url:          url,
+			method:       method,
+			expectedCode: http.StatusBadRequest,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "phone", "type": "date"}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		// success cases
+		{
+			name:         "valid body",
+			url:          url,
+			method:       method,
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"id": 1
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "locale", "type": "string", "enum": ["en", "id"]}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					{"INSERT INTO .user_attribute.", sqlmock.NewResult(1, 1), true},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
+
+func TestUserAttributeUpdate(t *testing.T) {
+	router := SetupRouter()
+	cases := []routeTestCase{
+		{
+			name:         "id found",
+			url:          "/user-attributes/1",
+			method:       http.MethodPut,
+			expectedCode: http.StatusOK,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "phone", "type": "string"}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					{"UPDATE .user_attribute. SET", sqlmock.NewResult(0, 1), true},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
+
+func TestUserRegisterAttributes(t *testing.T) {
+	const url = "/register"
+	makeBody := func(attributes string) string {
+		return `{
+			"email": "new-user@domain.tld",
+			"username": "new-usr",
+			"password": "new-user",
+			"name": "new-user",
+			"attributes": ` + attributes + `
+		}`
+	}
+
+	router := SetupRouter()
+	cases := []routeTestCase{
+		// internal error cases
+		{
+			name:         "handle definitions error",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusInternalServerError,
+			body:         makeBody(`{}`),
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_attribute.", errDummy, false},
+					sqlExpectRollback,
+				},
+			},
+		},
+		// client error cases
+		{
+			name:         "required attribute",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.phone": "attributes.phone is a required field"
+				}
+			}`,
+			body: makeBody(`{}`),
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectBegin,
+					sqlExpectUserAttributes(userAttributeRows()),
+					sqlExpectRollback,
+				},
+			},
+		},
+		{
+			name:         "invalid attributes",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.phone": "attributes.phone format is invalid",
+					"attributes.locale": "attributes.locale must be one of [en id]",
+					"attributes.floor": "attributes.floor must be a number",
+					"attributes.department": "attributes.department is not a known attribute"
+				}
+			}`,
+			body: makeBody(`{
+				"phone": "not-a-phone",
+				"locale": "fr",
+				"floor": "3",
+				"department": "engineering"
+			}`),
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectBegin,
+					sqlExpectUserAttributes(userAttributeRows()),
+					sqlExpectRollback,
+				},
+			},
+		},
+		// success cases
+		{
+			name:         "valid attributes",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusOK,
+			body:         makeBody(`{"phone": "+6281234567", "locale": "id", "floor": 3}`),
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectBegin,
+					sqlExpectUserAttributes(userAttributeRows()),
+					sqlExpectDefaultRole(2),
+					{"INSERT INTO .user.", sqlmock.NewResult(1, 1), false},
+					sqlExpectCommit,
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
+
+func TestUserGetMany(t *testing.T) {
+	const url = "/users"
+
+	router := SetupRouter()
+	cases := []routeTestCase{
+		{
+			name:         "invalid attribute filter",
+			url:          url + "?attributes.phone')=1",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.phone')": "attribute name is not valid"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		{
+			name:         "invalid number filter",
+			url:          url + "?attributes.floor=high",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.floor": "attributes.floor must be a number"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectUserAttributes(userAttributeRows()),
+				},
+			},
+		},
+		{
+			name:         "unknown attribute filter",
+			url:          url + "?attributes.nickname=bob",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.nickname": "attributes.nickname is not a known attribute"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectUserAttributes(userAttributeRows()),
+				},
+			},
+		},
+		{
+			name:         "filter by attribute",
+			url:          url + "?attributes.locale=id",
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"count": 1,
+					"items": [{
+						"id": 2,
+						"username": "user-username",
+						"attributes": {"locale": "id"}
+					}],
+					"pagination": {
+						"page": 1,
+						"limit": 25,
+						"totalPages": 1,
+						"links": {
+							"self": "/users?attributes.locale=id&limit=25&page=1",
+							"first": "/users?attributes.locale=id&limit=25&page=1",
+							"last": "/users?attributes.locale=id&limit=25&page=1"
+						}
+					}
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectUserAttributes(userAttributeRows()),
+					{
+						`SELECT .+ FROM .user. WHERE \(json_extract\(attributes, \?\) = \?\)`,
+						sqlmock.NewRows([]string{"id", "username", "attributes"}).
+							AddRow(2, "user-username", `{"locale":"id"}`),
+						false,
+					},
+					{
+						`SELECT count.+ FROM .user. WHERE \(json_extract`,
+						sqlmock.NewRows([]string{"count(*)"}).AddRow(1),
+						false,
+					},
+				},
+			},
+		},
+		{
+			name:         "filter by number attribute",
+			url:          url + "?attributes.floor=3",
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"count": 1,
+					"items": [{
+						"id": 2,
+						"username": "user-username",
+						"attributes": {"floor": 3}
+					}],
+					"pagination": {
+						"page": 1,
+						"limit": 25,
+						"totalPages": 1,
+						"links": {
+							"self": "/users?attributes.floor=3&limit=25&page=1",
+							"first": "/users?attributes.floor=3&limit=25&page=1",
+							"last": "/users?attributes.floor=3&limit=25&page=1"
+						}
+					}
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectUserAttributes(userAttributeRows()),
+					{
+						`SELECT .+ FROM .user. WHERE \(json_extract\(attributes, \?\) = \?\)`,
+						sqlmock.NewRows([]string{"id", "username", "attributes"}).
+							AddRow(2, "user-username", `{"floor":3}`),
+						false,
+					},
+					{
+						`SELECT count.+ FROM .user. WHERE \(json_extract`,
+						sqlmock.NewRows([]string{"count(*)"}).AddRow(1),
+						false,
+					},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
@@ -2,96 +2,207 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 
-	"github.com/frullah/gin-boilerplate/db"
 	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
 	"github.com/gin-gonic/gin"
 )
 
+// systemUserRoles can't be deleted, the application depends on them
+var systemUserRoles = map[string]struct{}{
+	"administrator": {},
+}
+
+var jsonErrSystemUserRole = ResponseError{
+	Status:  "error",
+	Message: "System role can't be deleted",
+}
+
 // UserRoleBody ...
 type UserRoleBody struct {
 	Name    string `json:"name" binding:"required"`
 	Enabled bool   `json:"enabled"`
+	// IsDefault is nil when the update keeps the flag
+	IsDefault *bool `json:"isDefault"`
+}
+
+// UserRoleController handle the user roles
+type UserRoleController struct {
+	*controller
+	store StoreProvider
+	auth  *AuthController
+}
+
+// NewUserRoleController with the dependencies and the repositories of the request
+func NewUserRoleController(deps Dependencies, store StoreProvider) (*UserRoleController, error) {
+	base, err := newController(deps)
+	if err != nil {
+		return nil, err
+	}
+	return newUserRoleController(base, store), nil
+}
+
+func newUserRoleController(base *controller, store StoreProvider) *UserRoleController {
+	return &UserRoleController{base, store, newAuthController(base, store)}
 }
 
-// UserRoleCreateOne handle POST: /user-roles
-func UserRoleCreateOne(ctx *gin.Context) {
+// CreateOne handle POST: /user-roles
+func (c *UserRoleController) CreateOne(ctx *gin.Context) {
 	data := UserRoleBody{}
-	if err := ctx.BindJSON(&data); err != nil {
+	if !c.validation.bindJSON(ctx, &data) {
 		return
 	}
 
-	user := models.UserRole{
+	role := models.UserRole{
 		Name:    data.Name,
 		Enabled: data.Enabled,
 	}
-	if err := db.Get(db.Default).
-		Model(&user).
-		Create(&user).Error; err != nil {
+	roles := c.store(ctx).Roles()
+
+	if err := roles.Create(&role); err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
 	}
-
-	ctx.PureJSON(http.StatusOK, &Response{"success", IntID{int(user.ID)}})
+	if data.IsDefault != nil && *data.IsDefault {
+		if err := roles.SetDefault(role.ID, true); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+	}
+	ctx.PureJSON(http.StatusOK, &Response{"success", IntID{int(role.ID)}})
 }
 
-// UserRoleUpdate handle PUT /user-roles/:id
-func UserRoleUpdate(ctx *gin.Context) {
+// Update handle PUT /user-roles/:id
+func (c *UserRoleController) Update(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 32)
 	if err != nil {
 		return
 	}
 
 	body := UserRoleBody{}
-	ctx.ShouldBindJSON(&body)
+	c.validation.shouldBindJSON(ctx, &body)
 
-	updatedUser := &models.UserRole{
+	updatedRole := &models.UserRole{
 		ID:      uint32(id),
 		Name:    body.Name,
 		Enabled: body.Enabled,
 	}
-	if err := db.Get(db.Default).
-		Model(updatedUser).
-		UpdateColumns(updatedUser).
-		Error; err != nil {
+	roles := c.store(ctx).Roles()
+
+	if err := roles.Update(updatedRole); err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
 	}
-
+	if body.IsDefault != nil {
+		if err := roles.SetDefault(updatedRole.ID, *body.IsDefault); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+	}
 	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
 }
 
-// UserRoleDelete handle DELETE /user-roles/:id
-func UserRoleDelete(ctx *gin.Context) {
+// Delete handle DELETE /user-roles/:id
+// the role which still assigned to users can only be deleted
+// with "reassign_to" query, the users are moved to that role
+func (c *UserRoleController) Delete(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 32)
 	if err != nil {
 		return
 	}
 
-	if err := db.Get(db.Default).
-		Delete(&models.UserRole{}, uint32(id)).
-		Error; err != nil {
+	reassignTo := uint32(0)
+	if value := ctx.Query("reassign_to"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || parsed == 0 || parsed == id {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{
+				"fail",
+				FieldError{"reassign_to": "reassign_to must be the id of another role"},
+			})
+			return
+		}
+		reassignTo = uint32(parsed)
+	}
+
+	store := c.store(ctx)
+
+	role, err := store.Roles().Get(uint32(id))
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	if _, ok := systemUserRoles[role.Name]; ok {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, jsonErrSystemUserRole)
+		return
+	}
+
+	count, err := store.Users().CountByRole(role.ID)
+	if err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
 	}
 
-	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
+	if count > 0 {
+		if reassignTo == 0 {
+			ctx.AbortWithStatusJSON(http.StatusConflict, ResponseError{
+				Status:  "error",
+				Message: "Role is still assigned to users",
+				Data: &struct {
+					Users uint64 `json:"users"`
+				}{count},
+			})
+			return
+		}
+
+		if _, err := store.Roles().Get(reassignTo); err != nil {
+			if err == repositories.ErrNotFound {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{
+					"fail",
+					FieldError{"reassign_to": "reassign_to role is not found"},
+				})
+			} else {
+				ctx.Error(err)
+				ctx.Abort()
+			}
+			return
+		}
+	}
+
+	// the users are moved and the role is deleted as a nested unit of work
+	if err := store.Atomic(func(store repositories.Store) error {
+		if count > 0 {
+			if err := store.Users().ReassignRole(role.ID, reassignTo); err != nil {
+				return err
+			}
+		}
+		return store.Roles().Delete(role)
+	}); err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{"success", &struct {
+		Reassigned uint64 `json:"reassigned"`
+	}{count}})
 }
 
-// UserRoleGetOne handle GET /user-roles/:id
-func UserRoleGetOne(ctx *gin.Context) {
+// GetOne handle GET /user-roles/:id
+func (c *UserRoleController) GetOne(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 32)
 	if err != nil {
 		return
 	}
 
-	userRole := &models.UserRole{}
-	if err := db.Get(db.Default).
-		First(userRole, uint32(id)).
-		Error; err != nil {
+	userRole, err := c.store(ctx).Roles().Get(uint32(id))
+	if err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
@@ -100,23 +211,38 @@ func UserRoleGetOne(ctx *gin.Context) {
 	ctx.PureJSON(http.StatusOK, &Response{"success", userRole})
 }
 
-// UserRoleGetMany handle GET /user-roles
-func UserRoleGetMany(ctx *gin.Context) {
-	userRoles := []models.UserRole{}
-	count := uint64(0)
-	defaultDB := db.Get(db.Default)
+var userRolePagination = PaginationOptions{
+	SortColumns: map[string]string{
+		"id":   "id",
+		"name": "name",
+	},
+	DefaultSort: "id",
+}
 
-	find := defaultDB.Limit(25).Find(&userRoles)
-	if err := find.Error; err != nil {
-		ctx.Error(err)
-		ctx.Abort()
+// GetMany handle GET /user-roles
+// the query accepts "q" to search by name, "enabled" filter
+// and the pagination parameters
+func (c *UserRoleController) GetMany(ctx *gin.Context) {
+	pagination, ok := parsePagination(ctx, userRolePagination)
+	if !ok {
 		return
 	}
 
-	if err := defaultDB.
-		Table("user_role").
-		Count(&count).
-		Error; err != nil {
+	filter := repositories.RoleFilter{Search: ctx.Query("q")}
+	if enabled := ctx.Query("enabled"); enabled != "" {
+		value, err := strconv.ParseBool(enabled)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, Response{
+				"fail",
+				FieldError{"enabled": "enabled must be a boolean value"},
+			})
+			return
+		}
+		filter.Enabled = &value
+	}
+
+	userRoles, count, err := c.store(ctx).Roles().List(filter, pagination.ListOptions())
+	if err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
@@ -124,23 +250,21 @@ func UserRoleGetMany(ctx *gin.Context) {
 
 	ctx.PureJSON(http.StatusOK, &Response{
 		"success",
-		&struct {
-			Count uint64            `json:"count"`
-			Items []models.UserRole `json:"items"`
-		}{count, userRoles},
+		&PageData{count, userRoles, pagination.Meta(ctx, count)},
 	})
 }
 
-// LoadUserRoleRoutes to router
-func LoadUserRoleRoutes(router *gin.Engine) {
-	routes := router.Group("/user-roles")
+// LoadRoutes of the user roles to router,
+// setting a role as default unset the flag of the other roles
+func (c *UserRoleController) LoadRoutes(router *gin.Engine) {
+	routes := router.Group(userRoleURL, c.queryTimeout(QueryTimeoutUserRoles))
 	authorized := routes.Group("")
-	authorized.Use(AuthRolesMiddleware(map[string]struct{}{
+	authorized.Use(c.auth.RolesMiddleware(map[string]struct{}{
 		"administrator": {},
 	}))
-	authorized.GET("/:id", UserRoleGetOne)
-	authorized.GET("", UserRoleGetMany)
-	authorized.PUT("/:id", UserRoleUpdate)
-	authorized.DELETE("/:id", UserRoleDelete)
-	authorized.POST("", UserRoleCreateOne)
+	authorized.GET("/:id", c.GetOne)
+	authorized.GET("", c.GetMany)
+	authorized.PUT("/:id", TransactionMiddleware, c.Update)
+	authorized.DELETE("/:id", TransactionMiddleware, c.Delete)
+	authorized.POST("", TransactionMiddleware, c.CreateOne)
 }
@@ -1,13 +1,21 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/AlekSi/pointer"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
 	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUserRoleGetOne(t *testing.T) {
@@ -114,7 +122,17 @@ func TestUserRoleGetMany(t *testing.T) {
 				"status": "success",
 				"data": {
 					"count": 0,
-					"items": []
+					"items": [],
+					"pagination": {
+						"page": 1,
+						"limit": 25,
+						"totalPages": 1,
+						"links": {
+							"self": "/user-roles?limit=25&page=1",
+							"first": "/user-roles?limit=25&page=1",
+							"last": "/user-roles?limit=25&page=1"
+						}
+					}
 				}
 			}`,
 			header: http.Header{
@@ -138,6 +156,139 @@ func TestUserRoleGetMany(t *testing.T) {
 		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
 	}
 }
+func TestUserRoleGetManyPagination(t *testing.T) {
+	const url = "/user-roles"
+
+	router := SetupRouter()
+	cases := []routeTestCase{
+		// client error cases
+		{
+			name:         "invalid query",
+			url:          url + "?page=0&limit=x&sort=enabled",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"page": "page must be a number greater than 0",
+					"limit": "limit must be a number greater than 0",
+					"sort": "sort must be one of [id name]"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		{
+			name:         "page out of range",
+			url:          url + "?page=9223372036854775807&limit=100",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"page": "page is out of range"
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		{
+			name:         "invalid enabled filter",
+			url:          url + "?enabled=maybe",
+			expectedCode: http.StatusBadRequest,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
+		// success cases
+		{
+			name:         "filtered second page",
+			url:          url + "?q=admin&enabled=true&page=2&limit=1&sort=-name",
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"count": 3,
+					"items": [{"id": 2, "name": "administrator-2", "enabled": true}],
+					"pagination": {
+						"page": 2,
+						"limit": 1,
+						"totalPages": 3,
+						"links": {
+							"self": "/user-roles?enabled=true&limit=1&page=2&q=admin&sort=-name",
+							"first": "/user-roles?enabled=true&limit=1&page=1&q=admin&sort=-name",
+							"prev": "/user-roles?enabled=true&limit=1&page=1&q=admin&sort=-name",
+							"next": "/user-roles?enabled=true&limit=1&page=3&q=admin&sort=-name",
+							"last": "/user-roles?enabled=true&limit=1&page=3&q=admin&sort=-name"
+						}
+					}
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					{
+						`SELECT .+ FROM .user_role. WHERE \(name LIKE \? ESCAPE '!'\) AND \(enabled = \?\) ORDER BY name DESC LIMIT 1 OFFSET 1`,
+						sqlmock.NewRows([]string{"id", "name", "enabled"}).
+							AddRow(uint32(2), "administrator-2", true),
+						false,
+					},
+					{
+						`SELECT count.+ FROM .user_role. WHERE \(name LIKE \? ESCAPE '!'\) AND \(enabled = \?\)`,
+						sqlmock.NewRows([]string{"count(*)"}).AddRow(3),
+						false,
+					},
+				},
+			},
+		},
+		{
+			name:         "limit is capped",
+			url:          url + "?limit=1000",
+			expectedCode: http.StatusOK,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					{
+						`SELECT .+ FROM .user_role. ORDER BY .id. LIMIT 100 OFFSET 0`,
+						sqlmock.NewRows([]string{}),
+						false,
+					},
+					{
+						"SELECT count.+ FROM .user_role.",
+						sqlmock.NewRows([]string{"count(*)"}).AddRow(0),
+						false,
+					},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) { testCase.run(t, router) })
+	}
+}
+
 func TestUserRoleCreateOne(t *testing.T) {
 	const url = "/user-roles"
 	const method = http.MethodPost
@@ -209,6 +360,39 @@ func TestUserRoleCreateOne(t *testing.T) {
 	}
 }
 
+func TestUserRoleCreateDefault(t *testing.T) {
+	router := SetupRouter()
+	sqlMock, teardown := db.SetupTest(db.Default)
+	defer teardown()
+
+	sqlmockExpect(sqlMock, sqlExpectAuthRole("administrator"))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec("INSERT INTO .user_role.").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	sqlMock.ExpectQuery(`SELECT id FROM .user_role. WHERE \(is_default = \?\)`).
+		WithArgs(true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	sqlMock.ExpectExec(`UPDATE .user_role. SET .is_default. = \? WHERE \(is_default = \? AND id <> \?\)`).
+		WithArgs(false, true, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE .user_role. SET .is_default. = \? WHERE \(id = \?\)`).
+		WithArgs(true, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest(
+		http.MethodPost,
+		"/user-roles",
+		strings.NewReader(`{"name": "member", "enabled": true, "isDefault": true}`),
+	)
+	request.Header.Set(AccessTokenHeader, makeAccessToken(1))
+	router.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
 func TestUserRoleUpdate(t *testing.T) {
 	const url = "/user-roles"
 	const method = http.MethodPut
@@ -248,6 +432,25 @@ func TestUserRoleUpdate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:         "unset default",
+			url:          url + "/1",
+			method:       method,
+			expectedCode: http.StatusOK,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			body: `{"name": "member", "isDefault": false}`,
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"UPDATE .user_role. SET .+ .name.", sqlmock.NewResult(1, 1), false},
+					{`UPDATE .user_role. SET .is_default. = \? WHERE \(id = \?\)`, sqlmock.NewResult(1, 1), false},
+					sqlExpectCommit,
+				},
+			},
+		},
 		{
 			name:         "id found",
 			url:          url + "/1",
@@ -279,8 +482,16 @@ func TestUserRoleDelete(t *testing.T) {
 	const url = "/user-roles"
 	const method = http.MethodDelete
 
+	roleRows := func(name string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name"}).AddRow(uint32(1), name)
+	}
+	countRows := func(count int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"count(*)"}).AddRow(count)
+	}
+
 	router := SetupRouter()
 	cases := []routeTestCase{
+		// client error cases
 		{
 			name:         "invalid id param",
 			url:          url + "/x",
@@ -295,6 +506,20 @@ func TestUserRoleDelete(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:         "invalid reassign_to param",
+			url:          url + "/1?reassign_to=1",
+			method:       method,
+			expectedCode: http.StatusBadRequest,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+				},
+			},
+		},
 		{
 			name:         "id not found",
 			url:          url + "/1",
@@ -304,7 +529,7 @@ func TestUserRoleDelete(t *testing.T) {
 				db.Default: {
 					sqlExpectAuthRole("administrator"),
 					{
-						"DELETE FROM .user_role.",
+						"SELECT .+ FROM .user_role.",
 						gorm.ErrRecordNotFound,
 						true,
 					},
@@ -314,6 +539,68 @@ func TestUserRoleDelete(t *testing.T) {
 				AccessTokenHeader: []string{makeAccessToken(1)},
 			},
 		},
+		{
+			name:         "system role",
+			url:          url + "/1",
+			method:       method,
+			expectedCode: http.StatusForbidden,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("administrator"), false},
+					sqlExpectRollback,
+				},
+			},
+		},
+		{
+			name:         "role is assigned to users",
+			url:          url + "/1",
+			method:       method,
+			expectedCode: http.StatusConflict,
+			expectedBody: `{
+				"status": "error",
+				"message": "Role is still assigned to users",
+				"data": {
+					"users": 3
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("member"), false},
+					{"SELECT count.+ FROM .user. WHERE", countRows(3), false},
+					sqlExpectRollback,
+				},
+			},
+		},
+		{
+			name:         "reassign to missing role",
+			url:          url + "/1?reassign_to=2",
+			method:       method,
+			expectedCode: http.StatusBadRequest,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("member"), false},
+					{"SELECT count.+ FROM .user. WHERE", countRows(3), false},
+					{"SELECT .+ FROM .user_role.", sqlmock.NewRows([]string{"id"}), false},
+					sqlExpectRollback,
+				},
+			},
+		},
+		// success cases
 		{
 			name:         "id found",
 			url:          url + "/1",
@@ -325,17 +612,161 @@ func TestUserRoleDelete(t *testing.T) {
 			db: dbMockMap{
 				db.Default: {
 					sqlExpectAuthRole("administrator"),
-					{
-						"DELETE FROM .user_role.",
-						sqlmock.NewResult(1, 1),
-						true,
-					},
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("member"), false},
+					{"SELECT count.+ FROM .user. WHERE", countRows(0), false},
+					{"SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					{"DELETE FROM .user_role.", sqlmock.NewResult(1, 1), false},
+					{"RELEASE SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					sqlExpectCommit,
+				},
+			},
+		},
+		{
+			name:         "reassign users",
+			url:          url + "/1?reassign_to=2",
+			method:       method,
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"reassigned": 3
+				}
+			}`,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("member"), false},
+					{"SELECT count.+ FROM .user. WHERE", countRows(3), false},
+					{"SELECT .+ FROM .user_role.", sqlmock.NewRows([]string{"id"}).AddRow(uint32(2)), false},
+					{"SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					{"UPDATE .user. SET .role_id.", sqlmock.NewResult(0, 3), false},
+					{"DELETE FROM .user_role.", sqlmock.NewResult(1, 1), false},
+					{"RELEASE SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					sqlExpectCommit,
 				},
 			},
 		},
+		{
+			name:         "handle reassign error",
+			url:          url + "/1?reassign_to=2",
+			method:       method,
+			expectedCode: http.StatusInternalServerError,
+			header: http.Header{
+				AccessTokenHeader: []string{makeAccessToken(1)},
+			},
+			db: dbMockMap{
+				db.Default: {
+					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					{"SELECT .+ FROM .user_role.", roleRows("member"), false},
+					{"SELECT count.+ FROM .user. WHERE", countRows(3), false},
+					{"SELECT .+ FROM .user_role.", sqlmock.NewRows([]string{"id"}).AddRow(uint32(2)), false},
+					{"SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					{"UPDATE .user. SET .role_id.", errDummy, false},
+					{"ROLLBACK TO SAVEPOINT sp_1", sqlmock.NewResult(0, 0), false},
+					sqlExpectRollback,
+				},
+			},
+		},
+	}
+
+	for _, handler := range cases {
+		t.Run(handler.name, func(t *testing.T) { handler.run(t, router) })
+	}
+}
+
+func TestUserRoleDeleteMemory(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	router := memoryRouter(store)
+	header := http.Header{AccessTokenHeader: []string{memoryAdministrator(t, store)}}
+
+	member := &models.UserRole{Name: "member", Enabled: true}
+	guest := &models.UserRole{Name: "guest"}
+	for _, role := range []*models.UserRole{member, guest} {
+		require.NoError(t, store.Roles().Create(role))
+	}
+	for _, username := range []string{"alice", "bob"} {
+		require.NoError(t, store.Users().Create(&models.User{
+			Email:    username + "@example.com",
+			Username: username,
+			RoleID:   member.ID,
+		}))
+	}
+
+	memberURL := fmt.Sprintf("/user-roles/%d", member.ID)
+	cases := []routeTestCase{
+		{
+			name:         "role is assigned to users",
+			url:          memberURL,
+			method:       http.MethodDelete,
+			header:       header,
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"status":"error","message":"Role is still assigned to users","data":{"users":2}}`,
+		},
+		{
+			name:         "reassign_to role is not found",
+			url:          memberURL + "?reassign_to=100",
+			method:       http.MethodDelete,
+			header:       header,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "success with reassign",
+			url:          fmt.Sprintf("%s?reassign_to=%d", memberURL, guest.ID),
+			method:       http.MethodDelete,
+			header:       header,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"reassigned":2}}`,
+		},
+		{
+			name:         "deleted role is not found",
+			url:          memberURL,
+			method:       http.MethodDelete,
+			header:       header,
+			expectedCode: http.StatusNotFound,
+		},
 	}
 
 	for _, handler := range cases {
 		t.Run(handler.name, func(t *testing.T) { handler.run(t, router) })
 	}
+
+	count, err := store.Users().CountByRole(guest.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestUserRoleDefaultMemory(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	router := memoryRouter(store)
+	header := http.Header{AccessTokenHeader: []string{memoryAdministrator(t, store)}}
+
+	for _, name := range []string{"member", "guest"} {
+		handler := routeTestCase{
+			name:         name,
+			url:          "/user-roles",
+			method:       http.MethodPost,
+			header:       header,
+			body:         UserRoleBody{Name: name, Enabled: true, IsDefault: pointer.ToBool(true)},
+			expectedCode: http.StatusOK,
+		}
+		handler.run(t, router)
+	}
+
+	// only the last role is flagged as default
+	role, err := store.Roles().GetDefault()
+	require.NoError(t, err)
+	assert.Equal(t, "guest", role.Name)
+
+	handler := routeTestCase{
+		url:          "/user-roles?q=e&enabled=true&sort=-name",
+		header:       header,
+		expectedCode: http.StatusOK,
+	}
+	handler.run(t, router)
 }
@@ -1,48 +1,82 @@
 package controllers
 
 import (
-	"database/sql"
-	"log"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/AlekSi/pointer"
 
-	"github.com/gin-gonic/gin/binding"
-
-	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/repositories"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/frullah/gin-boilerplate/models"
 )
 
-const defaultUserRoleID = 58
+var (
+	errNoDefaultRole = errors.New("no enabled default role for registration")
 
-// LoadUserRoutes to router
-func LoadUserRoutes(engine *gin.Engine) {
-	engine.POST(registerURL, UserRegister)
-	engine.GET("/user-availibility", UserAvailibility)
+	jsonErrNoDefaultRole = ResponseError{
+		Status:  "error",
+		Message: "Registration is not available, no default role is configured",
+	}
+)
+
+// UserController handle the users
+type UserController struct {
+	*controller
+	store StoreProvider
+	auth  *AuthController
+}
+
+// NewUserController with the dependencies and the repositories of the request
+func NewUserController(deps Dependencies, store StoreProvider) (*UserController, error) {
+	base, err := newController(deps)
+	if err != nil {
+		return nil, err
+	}
+	return newUserController(base, store), nil
+}
+
+func newUserController(base *controller, store StoreProvider) *UserController {
+	return &UserController{base, store, newAuthController(base, store)}
+}
+
+// LoadRoutes of the users to router
+func (c *UserController) LoadRoutes(engine *gin.Engine) {
+	public := engine.Group("", c.queryTimeout(QueryTimeoutUsers))
+	public.POST(registerURL, c.rateLimit(RateLimitRegister), TransactionMiddleware, c.Register)
+	public.GET("/user-availibility", c.rateLimit(RateLimitAvailability), c.Availibility)
+
+	me := public.Group(meURL)
+	me.Use(c.auth.RolesMiddleware(nil))
+	me.PUT("avatar", c.AvatarUpload)
+
+	group := public.Group(userURL)
+	group.GET(":id/avatar", c.AvatarGet)
 
-	group := engine.Group(userURL)
 	authorized := group.Group("")
 	authorized.Use(
-		AuthRolesMiddleware(map[string]struct{}{
+		c.auth.RolesMiddleware(map[string]struct{}{
 			"administrator": {},
 		}),
 	)
-	authorized.GET(":id", UserGetOne)
-	authorized.PUT(":id", UserUpdate)
-	authorized.DELETE(":id", UserDelete)
-	authorized.POST("", UserCreateOne)
+	authorized.GET("", c.GetMany)
+	authorized.GET(":id", c.GetOne)
+	authorized.PUT(":id", c.Update)
+	authorized.DELETE(":id", c.Delete)
+	authorized.POST("", TransactionMiddleware, c.CreateOne)
 }
 
-// UserAvailibility check the username or email is available to register
+// Availibility check the username or email is available to register
 // @Success 200 {object} models.User
 // @Failure 401
 // @Failure 403
+// @Failure 429 ResponseError
 // @Router /user-availibility [get]
-func UserAvailibility(c *gin.Context) {
+func (uc *UserController) Availibility(c *gin.Context) {
 	var data interface{}
 	qCtx := c.Query("context")
 	value := c.Query("value")
@@ -67,16 +101,14 @@ func UserAvailibility(c *gin.Context) {
 		return
 	}
 
-	if err := binding.Validator.ValidateStruct(data); err != nil {
+	if err := uc.validation.validate(data); err != nil {
 		c.Error(err).SetType(gin.ErrorTypeBind)
 		c.Abort()
 		return
 	}
 
-	exists := false
-	query := "SELECT 1 FROM `user` WHERE " + qCtx + " = ?"
-	err := db.Get(db.Default).Raw(query, value).Row().Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
+	exists, err := uc.store(c).Users().Exists(strings.ToLower(qCtx), value)
+	if err != nil {
 		c.Error(err)
 		return
 	}
@@ -89,63 +121,108 @@ func UserAvailibility(c *gin.Context) {
 	})
 }
 
-// UserGetOne docs
+// GetOne docs
 // @Success 200 {object} models.User
 // @Failure 401
 // @Failure 403
 // @Router /users [get]
-func UserGetOne(ctx *gin.Context) {
+func (c *UserController) GetOne(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 64)
 	if err != nil {
 		return
 	}
 
-	user := models.User{Role: &models.UserRole{}}
-	if err := db.Get(db.Default).
-		Select("id, email, username, name, enabled").
-		First(&user, id).
-		Related(user.Role, "Role").
-		Error; err != nil {
+	user, err := c.store(ctx).Users().GetWithRole(id)
+	if err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
 	}
 
-	ctx.PureJSON(http.StatusOK, &Response{"success", &user})
+	ctx.PureJSON(http.StatusOK, &Response{"success", user})
+}
+
+var userPagination = PaginationOptions{
+	SortColumns: map[string]string{
+		"id":       "id",
+		"username": "username",
+		"email":    "email",
+		"name":     "name",
+	},
+	DefaultSort: "id",
 }
 
-// UserCreateOne docs
+// GetMany docs
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Param sort query string false "Sort column, prefix with - for descending"
+// @Param attributes.{name} query string false "Filter by the custom attribute"
+// @Success 200 {object} controllers.Response
+// @Failure 400
+// @Failure 401
+// @Failure 403
+// @Router /users [get]
+func (c *UserController) GetMany(ctx *gin.Context) {
+	pagination, ok := parsePagination(ctx, userPagination)
+	if !ok {
+		return
+	}
+
+	attributes, ok := c.userAttributeFilter(ctx)
+	if !ok {
+		return
+	}
+
+	users, count, err := c.store(ctx).Users().List(
+		repositories.UserFilter{Attributes: attributes},
+		pagination.ListOptions(),
+	)
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.PureJSON(http.StatusOK, &Response{
+		"success",
+		&PageData{count, users, pagination.Meta(ctx, count)},
+	})
+}
+
+// CreateOne docs
 // @Accept json
 // @Success 200 {object} models.User
 // @Failure 401
 // @Failure 403
 // @Router /users [post]
-func UserCreateOne(ctx *gin.Context) {
+func (c *UserController) CreateOne(ctx *gin.Context) {
 	data := struct {
-		Email    string `json:"email" binding:"required,email"`
-		Username string `json:"username" binding:"required,username"`
-		Password string `json:"password" binding:"required,password"`
-		Name     string `json:"name" binding:"required,max=64"`
-		RoleID   uint32 `json:"roleId" binding:"required,min=1"`
-		Enabled  bool   `json:"enabled"`
+		Email      string            `json:"email" binding:"required,email"`
+		Username   string            `json:"username" binding:"required,username"`
+		Password   string            `json:"password" binding:"required,password"`
+		Name       string            `json:"name" binding:"required,max=64"`
+		RoleID     uint32            `json:"roleId" binding:"required,min=1"`
+		Enabled    bool              `json:"enabled"`
+		Attributes models.Attributes `json:"attributes"`
 	}{}
-	if err := ctx.BindJSON(&data); err != nil {
+	if !c.validation.bindJSON(ctx, &data) {
+		return
+	}
+	if !c.validateUserAttributes(ctx, data.Attributes) {
 		return
 	}
 
 	user := models.User{
-		Email:    data.Email,
-		Username: data.Username,
-		Password: data.Password,
-		Name:     data.Name,
-		RoleID:   data.RoleID,
-		Enabled:  data.Enabled,
-		Verified: true,
-	}
-	if err := db.Get(db.Default).
-		Model(&user).
-		Create(&user).
-		Error; err != nil {
+		Email:      data.Email,
+		Username:   data.Username,
+		Password:   data.Password,
+		Name:       data.Name,
+		RoleID:     data.RoleID,
+		Enabled:    data.Enabled,
+		Verified:   true,
+		Attributes: data.Attributes,
+	}
+	if err := c.store(ctx).Users().Create(&user); err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
@@ -154,7 +231,8 @@ func UserCreateOne(ctx *gin.Context) {
 	ctx.PureJSON(http.StatusOK, &Response{"success", Uint64ID{*user.ID}})
 }
 
-// UserUpdate docs
+// Update docs, the submitted attributes are merged into the stored ones
+// and a null attribute removes it
 // @Accept json
 // @Param id path int true "User ID"
 // @Param body body models.User true "User ID"
@@ -162,36 +240,49 @@ func UserCreateOne(ctx *gin.Context) {
 // @Failure 401
 // @Failure 403
 // @Router /users/{id} [put]
-func UserUpdate(ctx *gin.Context) {
+func (c *UserController) Update(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 64)
 	if err != nil {
 		return
 	}
 
 	body := struct {
-		Email    string `json:"email,omitempty" binding:"omitempty,email"`
-		Username string `json:"username,omitempty" binding:"omitempty,username"`
-		Password string `json:"password,omitempty" binding:"omitempty,password"`
-		Name     string `json:"name,omitempty" binding:"omitempty,max=64"`
-		RoleID   uint32 `json:"roleId,omitempty" binding:"omitempty,min=1"`
-		Enabled  bool   `json:"enabled,omitempty"`
+		Email      string            `json:"email,omitempty" binding:"omitempty,email"`
+		Username   string            `json:"username,omitempty" binding:"omitempty,username"`
+		Password   string            `json:"password,omitempty" binding:"omitempty,password"`
+		Name       string            `json:"name,omitempty" binding:"omitempty,max=64"`
+		RoleID     uint32            `json:"roleId,omitempty" binding:"omitempty,min=1"`
+		Enabled    bool              `json:"enabled,omitempty"`
+		Attributes models.Attributes `json:"attributes,omitempty"`
 	}{}
-	ctx.ShouldBindJSON(&body)
+	c.validation.shouldBindJSON(ctx, &body)
+
+	store := c.store(ctx)
+	if body.Attributes != nil {
+		stored, err := store.Users().Get(id)
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		body.Attributes = mergeAttributes(stored.Attributes, body.Attributes)
+		if !c.validateUserAttributes(ctx, body.Attributes) {
+			return
+		}
+	}
 
 	updatedUser := models.User{
-		ID:       pointer.ToUint64(id),
-		Email:    body.Email,
-		Username: body.Username,
-		Password: body.Password,
-		Name:     body.Name,
-		RoleID:   body.RoleID,
-		Enabled:  body.Enabled,
-	}
-	if err := db.Get(db.Default).
-		Model(&updatedUser).
-		UpdateColumns(&updatedUser).
-		Error; err != nil {
-		log.Println(err)
+		ID:         pointer.ToUint64(id),
+		Email:      body.Email,
+		Username:   body.Username,
+		Password:   body.Password,
+		Name:       body.Name,
+		RoleID:     body.RoleID,
+		Enabled:    body.Enabled,
+		Attributes: body.Attributes,
+	}
+	if err := store.Users().Update(&updatedUser); err != nil {
+		c.logger(ctx).WithError(err).Debug("updating the user")
 		ctx.Error(err)
 		ctx.Abort()
 		return
@@ -200,21 +291,35 @@ func UserUpdate(ctx *gin.Context) {
 	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
 }
 
-// UserDelete docs
+// mergeAttributes of the update into a copy of the stored attributes
+func mergeAttributes(stored, update models.Attributes) models.Attributes {
+	merged := models.Attributes{}
+	for name, value := range stored {
+		merged[name] = value
+	}
+	for name, value := range update {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// Delete docs
 // @Param id path int true "User ID"
 // @Success 200 {object} models.User
 // @Failure 401
 // @Failure 403
 // @Router /users{id} [delete]
-func UserDelete(ctx *gin.Context) {
+func (c *UserController) Delete(ctx *gin.Context) {
 	id, err := mustParseUintParam(ctx, "id", 64)
 	if err != nil {
 		return
 	}
 
-	if err := db.Get(db.Default).
-		Delete(&models.User{}, id).
-		Error; err != nil {
+	if err := c.store(ctx).Users().Delete(id); err != nil {
 		ctx.Error(err)
 		ctx.Abort()
 		return
@@ -223,34 +328,95 @@ func UserDelete(ctx *gin.Context) {
 	ctx.PureJSON(http.StatusOK, &Response{"success", nil})
 }
 
-// UserRegister docs
+// Register docs
 // @Success 200 {object} models.User
 // @Failure 401
+// @Failure 429 ResponseError
 // @Router /users/register [post]
-func UserRegister(ctx *gin.Context) {
+func (c *UserController) Register(ctx *gin.Context) {
 	data := struct {
-		Email    string `json:"email" binding:"required,email"`
-		Username string `json:"username" binding:"required,username"`
-		Password string `json:"password" binding:"required,password"`
-		Name     string `json:"name" binding:"required,max=64"`
+		Email      string            `json:"email" binding:"required,email"`
+		Username   string            `json:"username" binding:"required,username"`
+		Password   string            `json:"password" binding:"required,password"`
+		Name       string            `json:"name" binding:"required,max=64"`
+		Attributes models.Attributes `json:"attributes"`
 	}{}
-	if err := ctx.BindJSON(&data); err != nil {
+	if !c.validation.bindJSON(ctx, &data) {
+		return
+	}
+	if !c.validateUserAttributes(ctx, data.Attributes) {
+		return
+	}
+
+	store := c.store(ctx)
+	roleID, err := registrationRoleID(c.Config, store.Roles(), data.Email)
+	if err != nil {
+		if err == errNoDefaultRole {
+			c.logger(ctx).WithError(err).Error("registration")
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, jsonErrNoDefaultRole)
+		} else {
+			ctx.Error(err)
+			ctx.Abort()
+		}
 		return
 	}
 
 	newUser := models.User{
-		Email:    data.Email,
-		Username: data.Username,
-		Password: data.Password,
-		Name:     data.Name,
-		RoleID:   defaultUserRoleID,
-	}
-	if err := db.Get(db.Default).
-		Create(&newUser).
-		Error; err != nil {
+		Email:      data.Email,
+		Username:   data.Username,
+		Password:   data.Password,
+		Name:       data.Name,
+		RoleID:     roleID,
+		Attributes: data.Attributes,
+	}
+	if err := store.Users().Create(&newUser); err != nil {
 		ctx.Error(err)
 		return
 	}
 
 	ctx.PureJSON(http.StatusOK, Response{"success", IntID{int(*newUser.ID)}})
 }
+
+// registrationRoleID pick the role for the new user,
+// the first matching email domain rule is used,
+// then the configured default role, then the role flagged as default.
+// The disabled role is not available for registration
+func registrationRoleID(cnf *config.Config, roles repositories.RoleRepository, email string) (uint32, error) {
+	roleName := ""
+	if cnf != nil {
+		roleName = cnf.Registration.DefaultRole
+		domain := strings.ToLower(email[strings.LastIndexByte(email, '@')+1:])
+		for _, rule := range cnf.Registration.Rules {
+			if matchEmailDomain(strings.ToLower(rule.Domain), domain) {
+				roleName = rule.Role
+				break
+			}
+		}
+	}
+
+	var role *models.UserRole
+	var err error
+	if roleName != "" {
+		role, err = roles.GetByName(roleName)
+	} else {
+		role, err = roles.GetDefault()
+	}
+	if err != nil {
+		if err == repositories.ErrNotFound {
+			return 0, errNoDefaultRole
+		}
+		return 0, err
+	}
+	if !role.Enabled {
+		return 0, errNoDefaultRole
+	}
+
+	return role.ID, nil
+}
+
+func matchEmailDomain(pattern, domain string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(domain, pattern[1:])
+	}
+	return pattern == domain
+}
@@ -1,12 +1,18 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 	"testing"
 
 	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/frullah/gin-boilerplate/config"
 	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
 
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
@@ -125,7 +131,7 @@ func TestUserAvailibility(t *testing.T) {
 			expectedCode: http.StatusInternalServerError,
 			db: dbMockMap{
 				db.Default: []sqlExpect{{
-					"SELECT .+ FROM .user. WHERE username = ?",
+					`SELECT 1 FROM .user. WHERE \(username = \?\)`,
 					errDummy,
 					false,
 				}},
@@ -189,7 +195,7 @@ func TestUserAvailibility(t *testing.T) {
 			}`,
 			db: dbMockMap{
 				db.Default: []sqlExpect{{
-					"SELECT .+ FROM .user. WHERE username = ?",
+					`SELECT 1 FROM .user. WHERE \(username = \?\)`,
 					sqlmock.NewRows([]string{"1"}),
 					false,
 				}},
@@ -207,7 +213,7 @@ func TestUserAvailibility(t *testing.T) {
 			}`,
 			db: dbMockMap{
 				db.Default: []sqlExpect{{
-					"SELECT .+ FROM .user. WHERE username = ?",
+					`SELECT 1 FROM .user. WHERE \(username = \?\)`,
 					sqlmock.NewRows([]string{"1"}).
 						AddRow("1"),
 					false,
@@ -264,11 +270,15 @@ func TestUserRegister(t *testing.T) {
 			}`,
 			db: dbMockMap{
 				db.Default: []sqlExpect{
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
+					sqlExpectDefaultRole(2),
 					{
 						"INSERT INTO .user.",
 						&mysql.MySQLError{Number: uint16(1062)},
-						true,
+						false,
 					},
+					sqlExpectRollback,
 				},
 			},
 		},
@@ -286,11 +296,15 @@ func TestUserRegister(t *testing.T) {
 			}`,
 			db: dbMockMap{
 				db.Default: []sqlExpect{
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
+					sqlExpectDefaultRole(2),
 					{
 						"INSERT INTO .user.",
 						sqlmock.NewResult(1, 1),
-						true,
+						false,
 					},
+					sqlExpectCommit,
 				},
 			},
 		},
@@ -301,6 +315,104 @@ func TestUserRegister(t *testing.T) {
 	}
 }
 
+func TestUserRegisterDefaultRole(t *testing.T) {
+	const url = "/register"
+	makeBody := func(email string) string {
+		return `{
+			"email": "` + email + `",
+			"username": "new-usr",
+			"password": "new-user",
+			"name": "new-user"
+		}`
+	}
+
+	cnf := &config.Config{}
+	cnf.Registration.Rules = append(cnf.Registration.Rules, struct {
+		Domain string
+		Role   string
+	}{"*.company.tld", "staff"})
+	deps := testDependencies()
+	deps.Config = cnf
+
+	router := setupRouter(deps)
+	cases := []routeTestCase{
+		{
+			name:         "no default role",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{
+				"status": "error",
+				"message": "Registration is not available, no default role is configured"
+			}`,
+			body: makeBody("new-user@domain.tld"),
+			db: dbMockMap{
+				db.Default: []sqlExpect{
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
+					{
+						`SELECT .+ FROM .user_role. WHERE \(is_default = \?\)`,
+						sqlmock.NewRows([]string{"id"}),
+						false,
+					},
+					sqlExpectRollback,
+				},
+			},
+		},
+		{
+			name:         "role by email domain",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusOK,
+			body:         makeBody("new-user@hq.company.tld"),
+			db: dbMockMap{
+				db.Default: []sqlExpect{
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
+					{
+						`SELECT .+ FROM .user_role. WHERE \(name = \?\)`,
+						sqlmock.NewRows([]string{"id", "enabled"}).AddRow(3, true),
+						false,
+					},
+					{"INSERT INTO .user.", sqlmock.NewResult(1, 1), false},
+					sqlExpectCommit,
+				},
+			},
+		},
+		{
+			name:         "disabled default role",
+			url:          url,
+			method:       http.MethodPost,
+			expectedCode: http.StatusInternalServerError,
+			body:         makeBody("new-user@domain.tld"),
+			db: dbMockMap{
+				db.Default: []sqlExpect{
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
+					{
+						`SELECT .+ FROM .user_role. WHERE \(is_default = \?\)`,
+						sqlmock.NewRows([]string{"id", "enabled"}).AddRow(2, false),
+						false,
+					},
+					sqlExpectRollback,
+				},
+			},
+		},
+	}
+
+	for _, handler := range cases {
+		t.Run(handler.name, func(t *testing.T) { handler.run(t, router) })
+	}
+}
+
+func TestMatchEmailDomain(t *testing.T) {
+	assert.True(t, matchEmailDomain("company.tld", "company.tld"))
+	assert.False(t, matchEmailDomain("company.tld", "hq.company.tld"))
+	assert.True(t, matchEmailDomain("*.company.tld", "hq.company.tld"))
+	assert.False(t, matchEmailDomain("*.company.tld", "company.tld"))
+	assert.False(t, matchEmailDomain("*.company.tld", "evilcompany.tld"))
+}
+
 func TestUserUpdate(t *testing.T) {
 	router := SetupRouter()
 	cases := []routeTestCase{
@@ -371,6 +483,62 @@ func TestUserUpdate(t *testing.T) {
 	}
 }
 
+func TestUserUpdateAttributes(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	router := memoryRouter(store)
+	header := http.Header{AccessTokenHeader: []string{memoryAdministrator(t, store)}}
+
+	member := &models.UserRole{Name: "member", Enabled: true}
+	require.NoError(t, store.Roles().Create(member))
+	user := &models.User{
+		Email:      "alice@example.com",
+		Username:   "alice",
+		RoleID:     member.ID,
+		Attributes: models.Attributes{"phone": "+6281234567", "locale": "en", "floor": float64(3)},
+	}
+	require.NoError(t, store.Users().Create(user))
+	url := fmt.Sprintf("/users/%d", *user.ID)
+
+	cases := []routeTestCase{
+		{
+			name:         "invalid merged attributes",
+			url:          url,
+			method:       http.MethodPut,
+			header:       header,
+			body:         `{"attributes": {"phone": null}}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"status": "fail",
+				"data": {
+					"attributes.phone": "attributes.phone is a required field"
+				}
+			}`,
+			db: dbMockMap{
+				db.Default: {sqlExpectUserAttributes(userAttributeRows())},
+			},
+		},
+		{
+			name:         "update one attribute",
+			url:          url,
+			method:       http.MethodPut,
+			header:       header,
+			body:         `{"attributes": {"locale": "id", "floor": null}}`,
+			expectedCode: http.StatusOK,
+			db: dbMockMap{
+				db.Default: {sqlExpectUserAttributes(userAttributeRows())},
+			},
+		},
+	}
+
+	for _, handler := range cases {
+		t.Run(handler.name, func(t *testing.T) { handler.run(t, router) })
+	}
+
+	stored, err := store.Users().Get(*user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Attributes{"phone": "+6281234567", "locale": "id"}, stored.Attributes)
+}
+
 func TestUserDelete(t *testing.T) {
 	accessToken := makeAccessToken(1)
 
@@ -498,11 +666,14 @@ func TestUserCreateOne(t *testing.T) {
 			db: dbMockMap{
 				db.Default: []sqlExpect{
 					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
 					{
 						`INSERT INTO .user.`,
 						&mysql.MySQLError{Number: uint16(1062)},
-						true,
+						false,
 					},
+					sqlExpectRollback,
 				},
 			},
 		},
@@ -531,11 +702,14 @@ func TestUserCreateOne(t *testing.T) {
 			db: dbMockMap{
 				db.Default: []sqlExpect{
 					sqlExpectAuthRole("administrator"),
+					sqlExpectBegin,
+					sqlExpectUserAttributes(nil),
 					{
 						`INSERT INTO .user.`,
 						sqlmock.NewResult(1, 1),
-						true,
+						false,
 					},
+					sqlExpectCommit,
 				},
 			},
 		},
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// contextCommon is implemented by *sql.DB, *sql.Tx and the resolver
+type contextCommon interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Logger of the statements, it is the logger interface of gorm
+type Logger interface {
+	Print(v ...interface{})
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a context whose bound databases log to the logger,
+// so the statements of a request are logged with its fields
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// contextDB run the statements of gorm with the context
+type contextDB struct {
+	ctx  context.Context
+	base contextCommon
+}
+
+// contextTx run the statements of the transaction with the context
+type contextTx struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+// settingsKey of the settings in the values of gorm.DB, the values are copied by its clones
+const settingsKey = "db:settings"
+
+// settings of a database opened by the package, gorm has no getter for them
+// so they are kept to open the databases bound to a context the same way
+type settings struct {
+	// configured is false for the databases which aren't opened by the package, they keep the defaults of gorm
+	configured    bool
+	singularTable bool
+	logging       bool
+	logger        Logger
+}
+
+// configure the database with the settings and keep them
+func configure(g *gorm.DB, s settings) {
+	if s.configured {
+		g.SingularTable(s.singularTable)
+		g.LogMode(s.logging)
+	}
+	if s.logger != nil {
+		g.SetLogger(s.logger)
+	}
+	g.InstantSet(settingsKey, s)
+}
+
+// settingsOf the database, they aren't configured when it is not opened by the package
+func settingsOf(g *gorm.DB) settings {
+	s, _ := g.Get(settingsKey)
+	value, _ := s.(settings)
+	return value
+}
+
+// WithContext bind the database to the context, the statements are canceled when the context is done.
+// The bound database is opened on the connection wrapped with the context, it has the dialect,
+// the settings and the logging of the database and the default callbacks of gorm.
+// The database is returned as is when its connection doesn't support contexts
+func WithContext(g *gorm.DB, ctx context.Context) *gorm.DB {
+	var common gorm.SQLCommon
+	switch base := g.CommonDB().(type) {
+	case *contextDB:
+		common = &contextDB{ctx, base.base}
+	case *contextTx:
+		common = &contextTx{ctx, base.tx}
+	case *sql.Tx:
+		common = &contextTx{ctx, base}
+	case contextCommon:
+		common = &contextDB{ctx, base}
+	default:
+		return g
+	}
+
+	// the connection isn't a *sql.DB, so gorm doesn't ping it and can't fail
+	bound, err := gorm.Open(g.Dialect().GetName(), common)
+	if err != nil {
+		return g
+	}
+	s := settingsOf(g)
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		s.logger = logger
+	}
+	configure(bound, s)
+	bound.BlockGlobalUpdate(g.HasBlockGlobalUpdate())
+	return bound
+}
+
+// Context of the database, background when it is not bound
+func Context(g *gorm.DB) context.Context {
+	switch common := g.CommonDB().(type) {
+	case *contextDB:
+		return common.ctx
+	case *contextTx:
+		return common.ctx
+	}
+	return context.Background()
+}
+
+// statementError of a statement whose context is done, it wraps the context error and the driver error
+type statementError struct {
+	ctxErr error
+	err    error
+}
+
+func (e *statementError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statementError) Unwrap() []error {
+	return []error{e.ctxErr, e.err}
+}
+
+// wrapContextError of the statement when its context is done,
+// the drivers report the canceled statements with their own errors.
+// The errors of QueryRow are read by Scan, so they are returned as the driver reports them
+func wrapContextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if err == nil || ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	return &statementError{ctxErr, err}
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := c.base.ExecContext(c.ctx, query, args...)
+	return result, wrapContextError(c.ctx, err)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.base.PrepareContext(c.ctx, query)
+	return stmt, wrapContextError(c.ctx, err)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.base.QueryContext(c.ctx, query, args...)
+	return rows, wrapContextError(c.ctx, err)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.base.QueryRowContext(c.ctx, query, args...)
+}
+
+// Begin transaction which is rolled back when the context is done
+func (c *contextDB) Begin() (*sql.Tx, error) {
+	return c.BeginTx(c.ctx, nil)
+}
+
+// BeginTx transaction on the database
+func (c *contextDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if db, ok := c.base.(beginner); ok {
+		return db.BeginTx(ctx, opts)
+	}
+	return nil, gorm.ErrCantStartTransaction
+}
+
+func (c *contextTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := c.tx.ExecContext(c.ctx, query, args...)
+	return result, wrapContextError(c.ctx, err)
+}
+
+func (c *contextTx) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.tx.PrepareContext(c.ctx, query)
+	return stmt, wrapContextError(c.ctx, err)
+}
+
+func (c *contextTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.tx.QueryContext(c.ctx, query, args...)
+	return rows, wrapContextError(c.ctx, err)
+}
+
+func (c *contextTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.tx.QueryRowContext(c.ctx, query, args...)
+}
+
+// Commit the transaction
+func (c *contextTx) Commit() error {
+	return c.tx.Commit()
+}
+
+// Rollback the transaction
+func (c *contextTx) Rollback() error {
+	return c.tx.Rollback()
+}
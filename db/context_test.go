@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithContext(t *testing.T) {
+	gormDB, err := gorm.Open("sqlite3", openNamedDB(t, "primary"))
+	require.NoError(t, err)
+	defer gormDB.Close()
+
+	names := func(g *gorm.DB) ([]string, error) {
+		result := []string{}
+		err := g.Table("source").Order("name").Pluck("name", &result).Error
+		return result, err
+	}
+
+	assert.Equal(t, context.Background(), Context(gormDB))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := WithContext(gormDB, ctx)
+	assert.Equal(t, ctx, Context(bound))
+	result, err := names(bound)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"primary"}, result)
+
+	tx, err := Begin(bound)
+	require.NoError(t, err)
+	assert.Equal(t, ctx, Context(tx.DB))
+	require.NoError(t, tx.Exec("INSERT INTO source (name) VALUES (?)", "a").Error)
+	require.NoError(t, tx.Commit().Error)
+	result, err = names(gormDB)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "primary"}, result)
+
+	cancel()
+	_, err = names(bound)
+	assert.Equal(t, context.Canceled, err)
+	_, err = Begin(bound)
+	assert.Equal(t, context.Canceled, err)
+
+	// rebinding keeps the connection of the database
+	result, err = names(WithContext(bound, context.Background()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "primary"}, result)
+}
+
+func TestWithContextSettings(t *testing.T) {
+	gormDB, err := gorm.Open("sqlite3", openNamedDB(t, "primary"))
+	require.NoError(t, err)
+	defer gormDB.Close()
+
+	configure(gormDB, settings{configured: true, singularTable: true})
+	gormDB.BlockGlobalUpdate(true)
+
+	type Source struct {
+		Name string
+	}
+	bound := WithContext(gormDB, context.Background())
+	sources := []Source{}
+	require.NoError(t, bound.Find(&sources).Error)
+	assert.Equal(t, []Source{{"primary"}}, sources, "the singular table names are kept")
+	assert.Equal(t, gormDB.Dialect().GetName(), bound.Dialect().GetName())
+	assert.True(t, bound.HasBlockGlobalUpdate())
+	assert.Error(t, bound.Delete(&Source{}).Error, "the global delete is blocked")
+
+	// closing the bound database doesn't close the connection it shares
+	assert.Error(t, bound.Close())
+	require.NoError(t, gormDB.DB().Ping())
+}
+
+func TestWrapContextError(t *testing.T) {
+	driverErr := errors.New("canceling statement due to user request")
+	assert.Nil(t, wrapContextError(context.Background(), nil))
+	assert.Equal(t, driverErr, wrapContextError(context.Background(), driverErr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, wrapContextError(ctx, context.Canceled))
+	err := wrapContextError(ctx, driverErr)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, errors.Is(err, driverErr))
+	assert.Equal(t, driverErr.Error(), err.Error())
+}
+
+// printLogger record the values of the gorm logs
+type printLogger struct {
+	values [][]interface{}
+}
+
+func (l *printLogger) Print(v ...interface{}) {
+	l.values = append(l.values, v)
+}
+
+func TestWithContextLogger(t *testing.T) {
+	databases, err := Open([]config.Database{{Name: "default", Type: "sqlite3", DSN: ":memory:", Logging: true}})
+	require.NoError(t, err)
+	defer databases.Close()
+
+	base := &printLogger{}
+	databases.SetLogger(base)
+	require.NoError(t, WithContext(databases.Get(Default), context.Background()).Exec("SELECT 1").Error)
+	require.Len(t, base.values, 1)
+	assert.Equal(t, "sql", base.values[0][0])
+	assert.Equal(t, "SELECT 1", base.values[0][3])
+
+	request := &printLogger{}
+	ctx := ContextWithLogger(context.Background(), request)
+	bound := WithContext(databases.Get(Default), ctx)
+	require.NoError(t, bound.Exec("SELECT 2").Error)
+	// the logger of the context is kept when rebinding
+	require.NoError(t, WithContext(bound, context.Background()).Exec("SELECT 3").Error)
+	assert.Len(t, base.values, 1)
+	require.Len(t, request.values, 2)
+	assert.Equal(t, "SELECT 3", request.values[1][3])
+}
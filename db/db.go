@@ -1,75 +1,403 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/frullah/gin-boilerplate/config"
 	"github.com/jinzhu/gorm"
 )
 
-// Instance code databases
+// Instance code databases, registered from the config by the name
 type Instance byte
 
-// DB instance code
+// Default instance, named "default" in the config
+const Default Instance = 0
+
+// DefaultName of the Default instance
+const DefaultName = "default"
+
+// ErrUnknownInstance returned when the name is not in the config
+var ErrUnknownInstance = errors.New("db: unknown database instance")
+
+// connecting retries at startup
 const (
-	Default Instance = iota
-	InstanceCap
+	defaultRetries = 5
+	defaultBackoff = time.Second
+	maxBackoff     = 30 * time.Second
 )
 
-var dbInstanceMap = map[string]Instance{
-	"default": Default,
+// sleep between the connecting retries, replaced in the tests
+var sleep = time.Sleep
+
+// Databases opened from the config, the instances are looked up by the name
+type Databases struct {
+	db []*gorm.DB
+	// primaries of the instances which have replicas
+	primaries []*gorm.DB
+	sticky    []time.Duration
+	names     map[string]Instance
 }
 
+// global databases of the package functions, kept for the code which doesn't own the databases
 var (
-	db          [InstanceCap]*gorm.DB
+	global      = newDatabases(0)
 	initialized = false
 )
 
-// Init database
+func newDatabases(count int) *Databases {
+	return &Databases{
+		db:        make([]*gorm.DB, count),
+		primaries: make([]*gorm.DB, count),
+		sticky:    make([]time.Duration, count),
+		names:     map[string]Instance{},
+	}
+}
+
+// Open the databases of the config, the opened databases are closed on error
+func Open(dbConfigs []config.Database) (*Databases, error) {
+	instances, err := register(dbConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDatabases(len(instances))
+	for _, dbInstanceConf := range dbConfigs {
+		instance := instances[dbInstanceConf.Name]
+		dbInstance, primary, err := openWithReplicas(dbInstanceConf)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("db: %s: %v", dbInstanceConf.Name, err)
+		}
+		d.db[instance] = dbInstance
+		d.primaries[instance] = primary
+		d.sticky[instance] = dbInstanceConf.Sticky.Duration
+	}
+
+	d.names = instances
+	return d, nil
+}
+
+// Init the global databases from the global config
 func Init() error {
 	if initialized {
 		return nil
 	}
+
+	d, err := Open(config.Get().DB)
+	if err != nil {
+		return err
+	}
+	global = d
+	initialized = true
+	return nil
+}
+
+// Set the global databases, Init is skipped afterwards
+func Set(d *Databases) {
+	global = d
 	initialized = true
+}
+
+// Current global databases
+func Current() *Databases {
+	return global
+}
+
+// register the instance codes by the name, "default" is always the Default instance
+func register(dbConfigs []config.Database) (map[string]Instance, error) {
+	instances := map[string]Instance{}
+	hasDefault := false
+	for _, dbInstanceConf := range dbConfigs {
+		if dbInstanceConf.Name == DefaultName {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		return nil, fmt.Errorf("db: no %q database in the config", DefaultName)
+	}
+
+	instances[DefaultName] = Default
+	next := Default + 1
+	seen := map[string]bool{}
+	for _, dbInstanceConf := range dbConfigs {
+		name := dbInstanceConf.Name
+		switch {
+		case name == "":
+			return nil, errors.New("db: database without name in the config")
+		case seen[name]:
+			return nil, fmt.Errorf("db: duplicate database name %q in the config", name)
+		case !isDriverRegistered(dbInstanceConf.Type):
+			return nil, fmt.Errorf("db: %s: unknown database type %q", name, dbInstanceConf.Type)
+		}
+		seen[name] = true
+
+		if name != DefaultName {
+			instances[name] = next
+			next++
+		}
+	}
+
+	return instances, nil
+}
+
+func isDriverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// openWithReplicas returns the instance which resolve the replicas and its primary,
+// the primary is nil when there is no replica
+func openWithReplicas(dbInstanceConf config.Database) (*gorm.DB, *gorm.DB, error) {
+	sqlDB, err := open(dbInstanceConf, dbInstanceConf.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pingWithRetry(sqlDB, dbInstanceConf); err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	if len(dbInstanceConf.Replicas) == 0 {
+		dbInstance, err := newGorm(dbInstanceConf, sqlDB)
+		return dbInstance, nil, err
+	}
 
-	cnf := config.Get()
-	for _, dbInstanceConf := range cnf.DB {
-		dbInstance, err := gorm.Open(dbInstanceConf.Type, dbInstanceConf.DSN)
+	replicas := []*sql.DB{}
+	for _, dsn := range dbInstanceConf.Replicas {
+		replica, err := open(dbInstanceConf, dsn)
 		if err != nil {
-			return err
+			sqlDB.Close()
+			for _, replica := range replicas {
+				replica.Close()
+			}
+			return nil, nil, err
 		}
-		dbInstance.SingularTable(true)
-		dbInstance.LogMode(dbInstanceConf.Logging)
-		db[dbInstanceMap[dbInstanceConf.Name]] = dbInstance
+		replicas = append(replicas, replica)
 	}
 
-	// userModel := new(models.User)
-	// userRoleModel := new(models.UserRole)
+	// unavailable replicas are ejected by the resolver, they don't stop the startup
+	r := newResolver(sqlDB, replicas, dbInstanceConf.HealthInterval.Duration)
+	for _, replica := range r.replicas {
+		replica.setHealthy(ping(replica.db, dbInstanceConf.Timeout.Duration) == nil)
+	}
 
-	// defaultDB := db[Default]
-	// defaultDB.AutoMigrate(userRoleModel)
-	// defaultDB.AutoMigrate(userModel).
-	// 	AddIndex("role_id__index", "role_id").
-	// 	AddForeignKey("role_id", "user_role(id)", "CASCADE", "CASCADE")
+	dbInstance, err := newGorm(dbInstanceConf, r)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	primary, err := newGorm(dbInstanceConf, sqlDB)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	return dbInstance, primary, nil
+}
 
-	return nil
+func open(dbInstanceConf config.Database, dsn string) (*sql.DB, error) {
+	sqlDB, err := sql.Open(dbInstanceConf.Type, dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(dbInstanceConf.MaxOpenConns)
+	if dbInstanceConf.MaxIdleConns != 0 {
+		sqlDB.SetMaxIdleConns(dbInstanceConf.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(dbInstanceConf.ConnMaxLifetime.Duration)
+	return sqlDB, nil
+}
+
+func ping(sqlDB *sql.DB, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// pingWithRetry the database until it responds or the retries are exhausted
+func pingWithRetry(sqlDB *sql.DB, dbInstanceConf config.Database) error {
+	retries := dbInstanceConf.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	backoff := dbInstanceConf.Backoff.Duration
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+
+	err := ping(sqlDB, dbInstanceConf.Timeout.Duration)
+	for retry := 0; err != nil && retry < retries; retry++ {
+		sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		err = ping(sqlDB, dbInstanceConf.Timeout.Duration)
+	}
+	return err
+}
+
+func newGorm(dbInstanceConf config.Database, sqlDB gorm.SQLCommon) (*gorm.DB, error) {
+	dbInstance, err := gorm.Open(dbInstanceConf.Type, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	configure(dbInstance, settings{configured: true, singularTable: true, logging: dbInstanceConf.Logging})
+	return dbInstance, nil
 }
 
 // Get DB
+func (d *Databases) Get(instance Instance) *gorm.DB {
+	if int(instance) >= len(d.db) {
+		return nil
+	}
+	return d.db[instance]
+}
+
+// Primary DB of the instance, the reads are not sent to the replicas
+func (d *Databases) Primary(instance Instance) *gorm.DB {
+	if int(instance) < len(d.primaries) && d.primaries[instance] != nil {
+		return d.primaries[instance]
+	}
+	return d.Get(instance)
+}
+
+// Sticky duration of reading from the primary after a client writes,
+// zero when the instance has no replica
+func (d *Databases) Sticky(instance Instance) time.Duration {
+	if int(instance) >= len(d.sticky) || d.Primary(instance) == d.Get(instance) {
+		return 0
+	}
+	return d.sticky[instance]
+}
+
+// GetByName DB of the name in the config
+func (d *Databases) GetByName(name string) (*gorm.DB, error) {
+	instance, ok := d.Lookup(name)
+	if !ok || d.Get(instance) == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownInstance, name)
+	}
+	return d.Get(instance), nil
+}
+
+// Lookup the database instance by the name in the config
+func (d *Databases) Lookup(name string) (Instance, bool) {
+	instance, ok := d.names[name]
+	return instance, ok
+}
+
+// Name of the database instance in the config
+func (d *Databases) Name(instance Instance) string {
+	for name, value := range d.names {
+		if value == instance {
+			return name
+		}
+	}
+	return ""
+}
+
+// Names of the database instances, sorted
+func (d *Databases) Names() []string {
+	names := make([]string, 0, len(d.names))
+	for name := range d.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ping the primary of the instance
+func (d *Databases) Ping(ctx context.Context, instance Instance) error {
+	primary := d.Primary(instance)
+	if primary == nil || primary.DB() == nil {
+		return ErrUnknownInstance
+	}
+	return primary.DB().PingContext(ctx)
+}
+
+// SetLogger of the statements of every database, the databases bound to a context
+// log to it unless the context has its own logger
+func (d *Databases) SetLogger(logger Logger) {
+	for _, instances := range [][]*gorm.DB{d.db, d.primaries} {
+		for _, dbInstance := range instances {
+			if dbInstance != nil {
+				s := settingsOf(dbInstance)
+				s.logger = logger
+				configure(dbInstance, s)
+			}
+		}
+	}
+}
+
+// Close all databases connection
+func (d *Databases) Close() {
+	for _, dbInstance := range d.db {
+		if dbInstance != nil {
+			dbInstance.Close()
+		}
+	}
+}
+
+// Get DB of the global databases
 func Get(instance Instance) *gorm.DB {
-	return db[instance]
+	return global.Get(instance)
 }
 
-// Close all databases connectioon
+// Primary DB of the global databases
+func Primary(instance Instance) *gorm.DB {
+	return global.Primary(instance)
+}
+
+// Sticky duration of the global databases
+func Sticky(instance Instance) time.Duration {
+	return global.Sticky(instance)
+}
+
+// GetByName DB of the global databases
+func GetByName(name string) (*gorm.DB, error) {
+	return global.GetByName(name)
+}
+
+// Lookup the instance of the global databases
+func Lookup(name string) (Instance, bool) {
+	return global.Lookup(name)
+}
+
+// Name of the instance of the global databases
+func Name(instance Instance) string {
+	return global.Name(instance)
+}
+
+// Close the global databases
 func Close() {
-	for _, dbInstance := range db {
-		dbInstance.Close()
-	}
+	global.Close()
 }
 
-// SetupTest database
+// SetupTest database, the instance of the global databases is replaced with sqlmock
 func SetupTest(instance Instance) (sqlmock.Sqlmock, func() error) {
 	dbMock, sqlMock, _ := sqlmock.New()
-	db[instance], _ = gorm.Open("sqlite3", dbMock)
-	db[instance].SingularTable(true)
-	return sqlMock, db[instance].Close
+	d := global
+	for int(instance) >= len(d.db) {
+		d.db = append(d.db, nil)
+	}
+	if instance == Default {
+		d.names[DefaultName] = Default
+	}
+	if int(instance) < len(d.primaries) {
+		d.primaries[instance] = nil
+	}
+	d.db[instance], _ = gorm.Open("sqlite3", dbMock)
+	configure(d.db[instance], settings{configured: true, singularTable: true})
+	return sqlMock, d.db[instance].Close
 }
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetTest() {
+	Close()
+	global = newDatabases(0)
+	initialized = false
+}
+
+func TestInit(t *testing.T) {
+	cases := []struct {
+		name      string
+		databases []config.Database
+	}{
+		{"no default", []config.Database{{Name: "reporting", Type: "sqlite3", DSN: ":memory:"}}},
+		{"no name", []config.Database{{Name: "default", Type: "sqlite3", DSN: ":memory:"}, {Type: "sqlite3"}}},
+		{"duplicate name", []config.Database{
+			{Name: "default", Type: "sqlite3", DSN: ":memory:"},
+			{Name: "default", Type: "sqlite3", DSN: ":memory:"},
+		}},
+		{"unknown type", []config.Database{{Name: "default", Type: "oracle"}}},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			resetTest()
+			config.Set(&config.Config{DB: testCase.databases})
+			assert.Error(t, Init())
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		resetTest()
+		defer resetTest()
+		config.Set(&config.Config{DB: []config.Database{
+			{Name: "reporting", Type: "sqlite3", DSN: ":memory:", MaxOpenConns: 1},
+			{
+				Name:            "default",
+				Type:            "sqlite3",
+				DSN:             ":memory:",
+				MaxOpenConns:    4,
+				MaxIdleConns:    2,
+				ConnMaxLifetime: config.Duration{Duration: time.Minute},
+				Timeout:         config.Duration{Duration: time.Second},
+			},
+		}})
+		require.NoError(t, Init())
+
+		instance, ok := Lookup("reporting")
+		require.True(t, ok)
+		assert.NotEqual(t, Default, instance)
+		assert.Equal(t, "reporting", Name(instance))
+		assert.Equal(t, 1, Get(instance).DB().Stats().MaxOpenConnections)
+		assert.Equal(t, 4, Get(Default).DB().Stats().MaxOpenConnections)
+
+		defaultDB, err := GetByName(DefaultName)
+		require.NoError(t, err)
+		assert.Equal(t, Get(Default), defaultDB)
+
+		_, err = GetByName("unknown")
+		assert.True(t, errors.Is(err, ErrUnknownInstance))
+		assert.Nil(t, Get(Instance(10)))
+	})
+}
+
+func TestOpen(t *testing.T) {
+	resetTest()
+	configs := []config.Database{{Name: "default", Type: "sqlite3", DSN: ":memory:"}}
+
+	first, err := Open(configs)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := Open(configs)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// the opened databases are independent of the global databases
+	assert.NotEqual(t, first.Get(Default), second.Get(Default))
+	assert.Nil(t, Get(Default))
+
+	Set(first)
+	defer resetTest()
+	assert.Equal(t, first.Get(Default), Get(Default))
+	assert.NoError(t, Init())
+	assert.Equal(t, first, Current())
+}
+
+func TestPingWithRetry(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	sleeps := []time.Duration{}
+	sleep = func(duration time.Duration) { sleeps = append(sleeps, duration) }
+
+	sqlDB, err := sql.Open("sqlite3", "file:/nonexistent-dir/test.db?mode=ro")
+	require.NoError(t, err)
+	defer sqlDB.Close()
+
+	err = pingWithRetry(sqlDB, config.Database{
+		Retries: 3,
+		Backoff: config.Duration{Duration: 20 * time.Second},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []time.Duration{20 * time.Second, 30 * time.Second, 30 * time.Second}, sleeps)
+
+	sleeps = nil
+	assert.Error(t, pingWithRetry(sqlDB, config.Database{Retries: -1}))
+	assert.Empty(t, sleeps)
+}
+
+func TestStats(t *testing.T) {
+	resetTest()
+	defer resetTest()
+	config.Set(&config.Config{DB: []config.Database{
+		{Name: "default", Type: "sqlite3", DSN: ":memory:", MaxOpenConns: 2, Replicas: []string{":memory:"}},
+		{Name: "reporting", Type: "sqlite3", DSN: ":memory:"},
+	}})
+	require.NoError(t, Init())
+
+	stats := Stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, "default", stats[0].Instance)
+	assert.Equal(t, -1, stats[0].Replica)
+	assert.Equal(t, 2, stats[0].MaxOpen)
+	assert.Equal(t, 0, stats[1].Replica)
+	assert.Equal(t, "reporting", stats[2].Instance)
+}
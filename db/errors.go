@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Database errors independent of the driver
+var (
+	ErrUniqueViolation     = errors.New("db: unique violation")
+	ErrForeignKeyViolation = errors.New("db: foreign key violation")
+	ErrNotNullViolation    = errors.New("db: not null violation")
+	ErrDeadlock            = errors.New("db: deadlock")
+	ErrTimeout             = errors.New("db: timeout")
+)
+
+// MySQL error numbers
+const (
+	mysqlDuplicateEntry        = 1062
+	mysqlNoReferencedRowLegacy = 1216
+	mysqlRowIsReferencedLegacy = 1217
+	mysqlRowIsReferenced       = 1451
+	mysqlNoReferencedRow       = 1452
+	mysqlBadNull               = 1048
+	mysqlNoDefaultForField     = 1364
+	mysqlLockDeadlock          = 1213
+	mysqlLockWaitTimeout       = 1205
+	mysqlQueryTimeout          = 3024
+)
+
+// Postgres error codes
+const (
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	pqNotNullViolation     = "23502"
+	pqDeadlockDetected     = "40P01"
+	pqSerializationFailure = "40001"
+	pqQueryCanceled        = "57014"
+	pqLockNotAvailable     = "55P03"
+)
+
+// Classify the driver error into one of the database errors, the driver error
+// may be wrapped or collected in gorm.Errors. Returns nil when the error is not known
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errs, ok := err.(gorm.Errors); ok {
+		for _, err := range errs {
+			if classified := Classify(err); classified != nil {
+				return classified
+			}
+		}
+		return nil
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return classifyMySQL(mysqlErr.Number)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(string(pqErr.Code))
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return classifySQLite(sqliteErr)
+	}
+
+	for _, known := range []error{ErrUniqueViolation, ErrForeignKeyViolation, ErrNotNullViolation, ErrDeadlock, ErrTimeout} {
+		if errors.Is(err, known) {
+			return known
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+func classifyMySQL(number uint16) error {
+	switch number {
+	case mysqlDuplicateEntry:
+		return ErrUniqueViolation
+	case mysqlRowIsReferenced, mysqlNoReferencedRow, mysqlRowIsReferencedLegacy, mysqlNoReferencedRowLegacy:
+		return ErrForeignKeyViolation
+	case mysqlBadNull, mysqlNoDefaultForField:
+		return ErrNotNullViolation
+	case mysqlLockDeadlock:
+		return ErrDeadlock
+	case mysqlLockWaitTimeout, mysqlQueryTimeout:
+		return ErrTimeout
+	}
+	return nil
+}
+
+func classifyPostgres(code string) error {
+	switch code {
+	case pqUniqueViolation:
+		return ErrUniqueViolation
+	case pqForeignKeyViolation:
+		return ErrForeignKeyViolation
+	case pqNotNullViolation:
+		return ErrNotNullViolation
+	case pqDeadlockDetected, pqSerializationFailure:
+		return ErrDeadlock
+	case pqQueryCanceled, pqLockNotAvailable:
+		return ErrTimeout
+	}
+	return nil
+}
+
+func classifySQLite(err sqlite3.Error) error {
+	switch err.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return ErrUniqueViolation
+	case sqlite3.ErrConstraintForeignKey:
+		return ErrForeignKeyViolation
+	case sqlite3.ErrConstraintNotNull:
+		return ErrNotNullViolation
+	}
+	switch err.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return ErrTimeout
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"nil", nil, nil},
+		{"unknown", gorm.ErrRecordNotFound, nil},
+		{"deadline exceeded", context.DeadlineExceeded, ErrTimeout},
+		{"mysql unique", &mysql.MySQLError{Number: 1062}, ErrUniqueViolation},
+		{"mysql foreign key", &mysql.MySQLError{Number: 1451}, ErrForeignKeyViolation},
+		{"mysql not null", &mysql.MySQLError{Number: 1048}, ErrNotNullViolation},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, ErrDeadlock},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, ErrTimeout},
+		{"mysql unknown", &mysql.MySQLError{Number: 1064}, nil},
+		{"postgres unique", &pq.Error{Code: "23505"}, ErrUniqueViolation},
+		{"postgres foreign key", &pq.Error{Code: "23503"}, ErrForeignKeyViolation},
+		{"postgres not null", &pq.Error{Code: "23502"}, ErrNotNullViolation},
+		{"postgres deadlock", &pq.Error{Code: "40P01"}, ErrDeadlock},
+		{"postgres canceled", &pq.Error{Code: "57014"}, ErrTimeout},
+		{"postgres unknown", &pq.Error{Code: "42601"}, nil},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, ErrTimeout},
+		{"wrapped mysql", fmt.Errorf("create user: %w", &mysql.MySQLError{Number: 1062}), ErrUniqueViolation},
+		{"wrapped postgres", fmt.Errorf("create user: %w", &pq.Error{Code: "23503"}), ErrForeignKeyViolation},
+		{"wrapped sqlite", fmt.Errorf("create user: %w", sqlite3.Error{Code: sqlite3.ErrLocked}), ErrTimeout},
+		{"wrapped deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), ErrTimeout},
+		{"wrapped database error", fmt.Errorf("query: %w", ErrDeadlock), ErrDeadlock},
+		{"gorm errors", gorm.Errors{gorm.ErrRecordNotFound, &mysql.MySQLError{Number: 1062}}, ErrUniqueViolation},
+		{"gorm errors unknown", gorm.Errors{gorm.ErrRecordNotFound}, nil},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, Classify(testCase.err))
+		})
+	}
+}
+
+func TestClassifySQLite(t *testing.T) {
+	sqlDB := openNamedDB(t, "primary")
+	defer sqlDB.Close()
+
+	_, err := sqlDB.Exec(`
+		CREATE TABLE parent (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+		CREATE TABLE child (parent_id INTEGER REFERENCES parent(id));
+		PRAGMA foreign_keys = ON;
+		INSERT INTO parent (id, name) VALUES (1, 'parent');`)
+	require.NoError(t, err)
+
+	_, err = sqlDB.Exec("INSERT INTO parent (name) VALUES ('parent')")
+	assert.Equal(t, ErrUniqueViolation, Classify(err))
+	_, err = sqlDB.Exec("INSERT INTO parent (name) VALUES (NULL)")
+	assert.Equal(t, ErrNotNullViolation, Classify(err))
+	_, err = sqlDB.Exec("INSERT INTO child (parent_id) VALUES (2)")
+	assert.Equal(t, ErrForeignKeyViolation, Classify(err))
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHealthInterval of pinging the replicas
+const defaultHealthInterval = 10 * time.Second
+
+// resolver send the read-only queries to the healthy replicas round-robin,
+// writes and transactions go to the primary
+type resolver struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     uint32
+	done     chan struct{}
+	closed   sync.Once
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func newResolver(primary *sql.DB, replicas []*sql.DB, healthInterval time.Duration) *resolver {
+	r := &resolver{
+		primary: primary,
+		done:    make(chan struct{}),
+	}
+	for _, replicaDB := range replicas {
+		r.replicas = append(r.replicas, &replica{db: replicaDB, healthy: 1})
+	}
+
+	if healthInterval == 0 {
+		healthInterval = defaultHealthInterval
+	}
+	go r.checkHealth(healthInterval)
+
+	return r
+}
+
+// checkHealth eject the replicas which fail to ping, and restore them once they respond
+func (r *resolver) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.checkHealthOnce(interval)
+		}
+	}
+}
+
+func (r *resolver) checkHealthOnce(timeout time.Duration) {
+	for _, replica := range r.replicas {
+		replica.setHealthy(ping(replica.db, timeout) == nil)
+	}
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	value := int32(0)
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&r.healthy, value)
+}
+
+// replica picks the next healthy replica, nil when there is none
+func (r *resolver) replica() *replica {
+	count := uint32(len(r.replicas))
+	for i := uint32(0); i < count; i++ {
+		replica := r.replicas[atomic.AddUint32(&r.next, 1)%count]
+		if replica.isHealthy() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// Exec on the primary
+func (r *resolver) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext on the primary
+func (r *resolver) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+// Prepare on the primary
+func (r *resolver) Prepare(query string) (*sql.Stmt, error) {
+	return r.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext on the primary
+func (r *resolver) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return r.primary.PrepareContext(ctx, query)
+}
+
+// Query on a replica when the query is read-only,
+// the query is retried on the primary when the replica connection fails
+func (r *resolver) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext on a replica when the query is read-only
+func (r *resolver) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if isReadOnly(query) {
+		if replica := r.replica(); replica != nil {
+			rows, err := replica.db.QueryContext(ctx, query, args...)
+			if !isConnectionError(err) {
+				return rows, err
+			}
+			replica.setHealthy(false)
+		}
+	}
+	return r.primary.QueryContext(ctx, query, args...)
+}
+
+// QueryRow on a replica when the query is read-only
+func (r *resolver) QueryRow(query string, args ...interface{}) *sql.Row {
+	return r.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext on a replica when the query is read-only,
+// the query is retried on the primary when the replica connection fails
+func (r *resolver) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if isReadOnly(query) {
+		if replica := r.replica(); replica != nil {
+			row := replica.db.QueryRowContext(ctx, query, args...)
+			if !isConnectionError(row.Err()) {
+				return row
+			}
+			replica.setHealthy(false)
+		}
+	}
+	return r.primary.QueryRowContext(ctx, query, args...)
+}
+
+// Begin transaction on the primary
+func (r *resolver) Begin() (*sql.Tx, error) {
+	return r.primary.Begin()
+}
+
+// BeginTx transaction on the primary
+func (r *resolver) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.primary.BeginTx(ctx, opts)
+}
+
+// Close the primary and the replicas
+func (r *resolver) Close() error {
+	r.closed.Do(func() { close(r.done) })
+
+	err := r.primary.Close()
+	for _, replica := range r.replicas {
+		if replicaErr := replica.db.Close(); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
+
+// isReadOnly is true for SELECT without locking clauses
+func isReadOnly(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(query, "SELECT") {
+		return false
+	}
+	return !strings.Contains(query, " FOR UPDATE") &&
+		!strings.Contains(query, " FOR SHARE") &&
+		!strings.Contains(query, " LOCK IN SHARE MODE")
+}
+
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openNamedDB(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	_, err = sqlDB.Exec("CREATE TABLE source (name TEXT)")
+	require.NoError(t, err)
+	_, err = sqlDB.Exec("INSERT INTO source (name) VALUES (?)", name)
+	require.NoError(t, err)
+	return sqlDB
+}
+
+func TestResolver(t *testing.T) {
+	primary := openNamedDB(t, "primary")
+	r := newResolver(primary, []*sql.DB{
+		openNamedDB(t, "replica-1"),
+		openNamedDB(t, "replica-2"),
+	}, time.Hour)
+	defer r.Close()
+
+	gormDB, err := gorm.Open("sqlite3", r)
+	require.NoError(t, err)
+
+	source := func(query *gorm.DB) string {
+		var name string
+		require.NoError(t, query.Raw("SELECT name FROM source").Row().Scan(&name))
+		return name
+	}
+	find := func(query *gorm.DB) string {
+		names := []string{}
+		require.NoError(t, query.Table("source").Pluck("name", &names).Error)
+		return names[0]
+	}
+
+	t.Run("round-robin reads", func(t *testing.T) {
+		assert.Equal(t, "replica-2", source(gormDB))
+		assert.Equal(t, "replica-1", find(gormDB))
+		assert.Equal(t, "replica-2", source(gormDB))
+	})
+
+	t.Run("writes and transactions on primary", func(t *testing.T) {
+		require.NoError(t, gormDB.Exec("UPDATE source SET name = ?", "primary-updated").Error)
+		tx := gormDB.Begin()
+		assert.Equal(t, "primary-updated", source(tx))
+		tx.Rollback()
+	})
+
+	t.Run("locking reads on primary", func(t *testing.T) {
+		assert.False(t, isReadOnly("SELECT * FROM source FOR UPDATE"))
+		assert.False(t, isReadOnly("select * from source lock in share mode"))
+		assert.False(t, isReadOnly("INSERT INTO source (name) VALUES ('x')"))
+		assert.True(t, isReadOnly("  select * from source"))
+	})
+
+	t.Run("ejected replicas", func(t *testing.T) {
+		r.replicas[0].setHealthy(false)
+		assert.Equal(t, "replica-2", source(gormDB))
+		assert.Equal(t, "replica-2", source(gormDB))
+
+		r.replicas[1].setHealthy(false)
+		assert.Equal(t, "primary-updated", source(gormDB))
+
+		r.replicas[0].db.Close()
+		r.replicas[0].setHealthy(true)
+		r.checkHealthOnce(time.Second)
+		assert.False(t, r.replicas[0].isHealthy())
+		assert.True(t, r.replicas[1].isHealthy())
+	})
+}
+
+func TestResolverQueryRow(t *testing.T) {
+	replicaDB, replicaMock, err := sqlmock.New()
+	require.NoError(t, err)
+	r := newResolver(openNamedDB(t, "primary"), []*sql.DB{replicaDB}, time.Hour)
+	defer r.Close()
+
+	source := func() (string, error) {
+		var name string
+		err := r.QueryRowContext(context.Background(), "SELECT name FROM source").Scan(&name)
+		return name, err
+	}
+
+	// the row is read on the replica
+	replicaMock.ExpectQuery("SELECT name FROM source").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("replica"))
+	name, err := source()
+	require.NoError(t, err)
+	assert.Equal(t, "replica", name)
+
+	// the errors of the query keep the replica
+	replicaMock.ExpectQuery("SELECT name FROM source").WillReturnError(sql.ErrNoRows)
+	_, err = source()
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.True(t, r.replicas[0].isHealthy())
+
+	// the connection errors eject the replica and the row is read on the primary
+	replicaMock.ExpectQuery("SELECT name FROM source").
+		WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	name, err = source()
+	require.NoError(t, err)
+	assert.Equal(t, "primary", name)
+	assert.False(t, r.replicas[0].isHealthy())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+}
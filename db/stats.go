@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolStats of a connection pool
+type PoolStats struct {
+	Instance string `json:"instance"`
+	// Replica index, -1 for the primary
+	Replica           int           `json:"replica"`
+	MaxOpen           int           `json:"maxOpen"`
+	Open              int           `json:"open"`
+	InUse             int           `json:"inUse"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"waitCount"`
+	WaitDuration      time.Duration `json:"waitDuration"`
+	MaxIdleClosed     int64         `json:"maxIdleClosed"`
+	MaxLifetimeClosed int64         `json:"maxLifetimeClosed"`
+}
+
+// Stats of the connection pools of the global databases
+func Stats() []PoolStats {
+	return global.Stats()
+}
+
+// Stats of the connection pools of every instance, ordered by the instance name
+func (d *Databases) Stats() []PoolStats {
+	stats := []PoolStats{}
+	for _, name := range d.Names() {
+		instance := d.names[name]
+		if d.Get(instance) == nil {
+			continue
+		}
+
+		if r, ok := d.Get(instance).CommonDB().(*resolver); ok {
+			stats = append(stats, newPoolStats(name, -1, r.primary))
+			for i, replica := range r.replicas {
+				stats = append(stats, newPoolStats(name, i, replica.db))
+			}
+		} else if sqlDB := d.Get(instance).DB(); sqlDB != nil {
+			stats = append(stats, newPoolStats(name, -1, sqlDB))
+		}
+	}
+	return stats
+}
+
+func newPoolStats(name string, replica int, sqlDB *sql.DB) PoolStats {
+	stats := sqlDB.Stats()
+	return PoolStats{
+		Instance:          name,
+		Replica:           replica,
+		MaxOpen:           stats.MaxOpenConnections,
+		Open:              stats.OpenConnections,
+		InUse:             stats.InUse,
+		Idle:              stats.Idle,
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      stats.WaitDuration,
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}
+}
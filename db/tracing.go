@@ -0,0 +1,77 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName of the query spans
+	tracerName = "github.com/frullah/gin-boilerplate/db"
+	// spanKey of the query span in the scope
+	spanKey = "tracing:span"
+)
+
+// dbSystems of the dialects, named by the semantic conventions
+var dbSystems = map[string]attribute.KeyValue{
+	"mysql":    semconv.DBSystemMySQL,
+	"postgres": semconv.DBSystemPostgreSQL,
+	"sqlite3":  semconv.DBSystemSqlite,
+}
+
+// the callbacks of gorm are shared by every database, so they are registered once
+func init() {
+	registerTracing(gorm.DefaultCallback)
+}
+
+// registerTracing trace the queries of the databases bound to a context with a span,
+// the span of the query is a child of it and uses its tracer provider
+func registerTracing(callback *gorm.Callback) {
+	callback.Create().Before("gorm:begin_transaction").Register("tracing:before_create", startSpan("gorm.create"))
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("tracing:after_create", endSpan)
+	callback.Update().Before("gorm:begin_transaction").Register("tracing:before_update", startSpan("gorm.update"))
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("tracing:after_update", endSpan)
+	callback.Delete().Before("gorm:begin_transaction").Register("tracing:before_delete", startSpan("gorm.delete"))
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("tracing:after_delete", endSpan)
+	callback.Query().Before("gorm:query").Register("tracing:before_query", startSpan("gorm.query"))
+	callback.Query().After("gorm:after_query").Register("tracing:after_query", endSpan)
+	callback.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startSpan("gorm.row_query"))
+	callback.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", endSpan)
+}
+
+func startSpan(name string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		ctx := Context(scope.DB())
+		parent := trace.SpanFromContext(ctx)
+		if !parent.SpanContext().IsValid() {
+			return
+		}
+		_, span := parent.TracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+// endSpan with the statement, its values aren't recorded since they may be passwords
+func endSpan(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		semconv.DBStatementKey.String(scope.SQL),
+		semconv.DBSQLTableKey.String(scope.TableName()),
+		attribute.Int64("db.rows_affected", scope.DB().RowsAffected),
+	)
+	if system, ok := dbSystems[scope.Dialect().GetName()]; ok {
+		span.SetAttributes(system)
+	}
+	if err := scope.DB().Error; err != nil && err != gorm.ErrRecordNotFound {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedRow struct {
+	ID   uint64
+	Name string
+}
+
+func TestTracing(t *testing.T) {
+	databases, err := Open([]config.Database{{Name: "default", Type: "sqlite3", DSN: ":memory:", MaxOpenConns: 1}})
+	require.NoError(t, err)
+	defer databases.Close()
+	primary := databases.Primary(Default)
+	require.NoError(t, primary.AutoMigrate(&tracedRow{}).Error)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// the queries without a span in their context aren't traced
+	require.NoError(t, WithContext(primary, context.Background()).Create(&tracedRow{Name: "untraced"}).Error)
+	assert.Empty(t, exporter.GetSpans())
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	g := WithContext(primary, ctx)
+	require.NoError(t, g.Create(&tracedRow{Name: "traced"}).Error)
+	rows := []tracedRow{}
+	require.NoError(t, g.Where("name = ?", "traced").Find(&rows).Error)
+	require.Len(t, rows, 1)
+	assert.Error(t, g.Table("missing").Find(&rows).Error)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	parentID := spans[3].SpanContext.SpanID()
+	assert.Equal(t, "parent", spans[3].Name)
+
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		result := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			result[kv.Key] = kv.Value
+		}
+		return result
+	}
+	for i, name := range []string{"gorm.create", "gorm.query", "gorm.query"} {
+		assert.Equal(t, name, spans[i].Name)
+		assert.Equal(t, parentID, spans[i].Parent.SpanID())
+		assert.Equal(t, trace.SpanKindClient, spans[i].SpanKind)
+		assert.Equal(t, "sqlite", attributes(spans[i])["db.system"].AsString())
+	}
+
+	create := attributes(spans[0])
+	assert.Equal(t, "traced_row", create["db.sql.table"].AsString())
+	assert.Equal(t, `INSERT  INTO "traced_row" ("name") VALUES (?)`, create["db.statement"].AsString())
+	assert.Equal(t, int64(1), create["db.rows_affected"].AsInt64())
+
+	assert.Equal(t, "Error", spans[2].Status.Code.String())
+	assert.NotEmpty(t, spans[2].Events)
+}
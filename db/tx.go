@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Tx is a unit of work, the nested units are savepoints of the transaction
+type Tx struct {
+	*gorm.DB
+	depth int
+}
+
+// Begin the unit of work on the database,
+// the transaction of a database bound to a context is rolled back when the context is done
+func Begin(g *gorm.DB) (*Tx, error) {
+	ctx := Context(g)
+	tx := g.BeginTx(ctx, &sql.TxOptions{})
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+	if ctx != context.Background() {
+		tx = WithContext(tx, ctx)
+	}
+	return &Tx{DB: tx}, nil
+}
+
+// Transaction run fn in a unit of work,
+// committed when fn returns nil and rolled back on error or panic
+func Transaction(g *gorm.DB, fn func(tx *Tx) error) error {
+	tx, err := Begin(g)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Savepoint run fn in a nested unit of work,
+// the changes of fn are rolled back to the savepoint when it returns an error or panics
+func (tx *Tx) Savepoint(fn func(tx *Tx) error) error {
+	tx.depth++
+	defer func() { tx.depth-- }()
+
+	name := fmt.Sprintf("sp_%d", tx.depth)
+	if err := tx.Exec("SAVEPOINT " + name).Error; err != nil {
+		return err
+	}
+
+	done := false
+	defer func() {
+		if !done {
+			tx.Exec("ROLLBACK TO SAVEPOINT " + name)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		done = true
+		if rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT " + name).Error; rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	done = true
+	return tx.Exec("RELEASE SAVEPOINT " + name).Error
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction(t *testing.T) {
+	gormDB, err := gorm.Open("sqlite3", openNamedDB(t, "primary"))
+	require.NoError(t, err)
+	defer gormDB.Close()
+
+	names := func() []string {
+		result := []string{}
+		require.NoError(t, gormDB.Table("source").Order("name").Pluck("name", &result).Error)
+		return result
+	}
+	insert := func(tx *Tx, name string) error {
+		return tx.Exec("INSERT INTO source (name) VALUES (?)", name).Error
+	}
+	errFailed := errors.New("failed")
+
+	err = Transaction(gormDB, func(tx *Tx) error {
+		require.NoError(t, insert(tx, "a"))
+		// rolled back to the savepoint, the outer unit continues
+		assert.Equal(t, errFailed, tx.Savepoint(func(tx *Tx) error {
+			require.NoError(t, insert(tx, "b"))
+			return errFailed
+		}))
+		return tx.Savepoint(func(tx *Tx) error {
+			require.NoError(t, insert(tx, "c"))
+			return tx.Savepoint(func(tx *Tx) error {
+				return insert(tx, "d")
+			})
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "d", "primary"}, names())
+
+	err = Transaction(gormDB, func(tx *Tx) error {
+		require.NoError(t, insert(tx, "e"))
+		return errFailed
+	})
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, []string{"a", "c", "d", "primary"}, names())
+
+	assert.Panics(t, func() {
+		Transaction(gormDB, func(tx *Tx) error {
+			tx.Savepoint(func(tx *Tx) error {
+				insert(tx, "f")
+				panic("savepoint panic")
+			})
+			return nil
+		})
+	})
+	assert.Equal(t, []string{"a", "c", "d", "primary"}, names())
+}
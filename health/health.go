@@ -0,0 +1,156 @@
+// Package health run the readiness checks of the subsystems,
+// a subsystem registers its checker into the registry of the app
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/db"
+)
+
+// DefaultTimeout of a check
+const DefaultTimeout = 2 * time.Second
+
+// Status of a check
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Unavailable is the error of the down checks in the report, the errors of the checkers
+// can hold the addresses and the credentials of the subsystems so they are only logged
+const Unavailable = "unavailable"
+
+// ErrShuttingDown reported by the shutdown checker
+var ErrShuttingDown = errors.New("health: shutting down")
+
+// Checker of a subsystem, it returns nil when the subsystem is ready
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapt a function to Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check call f
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Result of a check
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Latency of the check, such as "1.2ms"
+	Latency string `json:"latency"`
+	// Error is Unavailable when the check is down
+	Error string `json:"error,omitempty"`
+	// Err of the checker, it is not reported to the clients
+	Err error `json:"-"`
+}
+
+// Report of every check, the status is down when a check is down
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type namedChecker struct {
+	name    string
+	checker Checker
+}
+
+// Registry of the checkers
+type Registry struct {
+	// Timeout of every check, DefaultTimeout when zero
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	checkers []namedChecker
+}
+
+// NewRegistry without checker
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{Timeout: timeout}
+}
+
+// Register the checker, the checker with the same name is replaced
+func (r *Registry) Register(name string, checker Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.checkers {
+		if r.checkers[i].name == name {
+			r.checkers[i].checker = checker
+			return
+		}
+	}
+	r.checkers = append(r.checkers, namedChecker{name, checker})
+}
+
+// Check every checker concurrently, the results keep the registration order
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	checkers := append([]namedChecker{}, r.checkers...)
+	r.mu.RUnlock()
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(checkers))}
+	wg := sync.WaitGroup{}
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func(i int, checker namedChecker) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, checker, timeout)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, checker namedChecker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	// a checker which ignores the context doesn't hold the report
+	go func() { errs <- checker.checker.Check(ctx) }()
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Name: checker.name, Status: StatusUp, Latency: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = Unavailable
+		result.Err = err
+	}
+	return result
+}
+
+// RegisterDatabases register a ping checker of every database instance, named "db:<name>"
+func (r *Registry) RegisterDatabases(databases *db.Databases) {
+	for _, name := range databases.Names() {
+		instance, _ := databases.Lookup(name)
+		r.Register("db:"+name, CheckerFunc(func(ctx context.Context) error {
+			return databases.Ping(ctx, instance)
+		}))
+	}
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry(20 * time.Millisecond)
+	registry.Register("up", CheckerFunc(func(ctx context.Context) error { return nil }))
+	registry.Register("down", CheckerFunc(func(ctx context.Context) error { return errors.New("failed") }))
+	registry.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	report := registry.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, "up", report.Checks[0].Name)
+	assert.Equal(t, StatusUp, report.Checks[0].Status)
+	assert.NotEmpty(t, report.Checks[0].Latency)
+	assert.Equal(t, Unavailable, report.Checks[1].Error)
+	assert.EqualError(t, report.Checks[1].Err, "failed")
+	assert.Equal(t, Unavailable, report.Checks[2].Error)
+	assert.Equal(t, context.DeadlineExceeded, report.Checks[2].Err)
+
+	// replaced by the name
+	registry.Register("down", CheckerFunc(func(ctx context.Context) error { return nil }))
+	registry.Register("slow", CheckerFunc(func(ctx context.Context) error { return nil }))
+	report = registry.Check(context.Background())
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Len(t, report.Checks, 3)
+}
+
+func TestRegisterDatabases(t *testing.T) {
+	databases, err := db.Open([]config.Database{
+		{Name: "default", Type: "sqlite3", DSN: ":memory:"},
+		{Name: "reporting", Type: "sqlite3", DSN: ":memory:"},
+	})
+	require.NoError(t, err)
+
+	registry := NewRegistry(0)
+	registry.RegisterDatabases(databases)
+	report := registry.Check(context.Background())
+	assert.Equal(t, StatusUp, report.Status)
+	require.Len(t, report.Checks, 2)
+	assert.Equal(t, "db:default", report.Checks[0].Name)
+	assert.Equal(t, "db:reporting", report.Checks[1].Name)
+
+	databases.Close()
+	report = registry.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+}
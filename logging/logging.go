@@ -0,0 +1,178 @@
+// Package logging build the structured logger of the app from the config,
+// the log lines of a request carry its request ID
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader of the request ID, it is honoured when the client sends it and generated otherwise
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	// requestIDKey of the request ID in the gin context
+	requestIDKey = "requestID"
+	// maxRequestIDLength of the request IDs sent by the clients, the longer ones are replaced
+	maxRequestIDLength = 128
+)
+
+// New logger of the config, the production environment logs JSON unless the format is set
+func New(cnf config.Log, environment string, out io.Writer) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.Out = out
+
+	if cnf.Level != "" {
+		level, err := logrus.ParseLevel(cnf.Level)
+		if err != nil {
+			return nil, err
+		}
+		logger.Level = level
+	}
+
+	format := cnf.Format
+	if format == "" {
+		format = "text"
+		if environment == "production" {
+			format = "json"
+		}
+	}
+	switch format {
+	case "json":
+		logger.Formatter = &logrus.JSONFormatter{}
+	case "text":
+		logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+	return logger, nil
+}
+
+type entryKey struct{}
+
+// NewContext returns a context carrying the entry
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext returns the entry of the context
+func FromContext(ctx context.Context) (*logrus.Entry, bool) {
+	entry, ok := ctx.Value(entryKey{}).(*logrus.Entry)
+	return entry, ok
+}
+
+// RequestID of the request, empty when Middleware doesn't run before
+func RequestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
+
+// Middleware give every request an ID and an entry with the ID into its context,
+// the statements of the databases bound to the context are logged with it.
+// The request is logged once it is handled and the panics are logged then responded with 500
+func Middleware(logger *logrus.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		requestID := ctx.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		ctx.Set(requestIDKey, requestID)
+		ctx.Header(RequestIDHeader, requestID)
+
+		entry := logger.WithField("request_id", requestID)
+		requestCtx := NewContext(ctx.Request.Context(), entry)
+		ctx.Request = ctx.Request.WithContext(db.ContextWithLogger(requestCtx, GormLogger{entry}))
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				entry.WithFields(logrus.Fields{
+					"panic": fmt.Sprint(recovered),
+					"stack": string(debug.Stack()),
+				}).Error("panic recovered")
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+			}
+
+			status := ctx.Writer.Status()
+			fields := entry.WithFields(logrus.Fields{
+				"method":     ctx.Request.Method,
+				"path":       ctx.Request.URL.Path,
+				"status":     status,
+				"latency_ms": milliseconds(time.Since(start)),
+				"client_ip":  ctx.ClientIP(),
+				"bytes":      ctx.Writer.Size(),
+			})
+			if status >= http.StatusInternalServerError {
+				fields.Error("request")
+			} else {
+				fields.Info("request")
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// validRequestID is true when the ID is printable ASCII without spaces and not too long
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// GormLogger log the statements of gorm to the entry, the values of the statements
+// aren't logged since they may be passwords
+type GormLogger struct {
+	Entry *logrus.Entry
+}
+
+// Print the values of gorm, they are the level, the source then the message or the statement
+func (l GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		l.Entry.Info(values...)
+		return
+	}
+
+	entry := l.Entry.WithField("source", values[1])
+	switch values[0] {
+	case "sql":
+		if len(values) == 6 {
+			duration, _ := values[2].(time.Duration)
+			entry.WithFields(logrus.Fields{
+				"sql":         values[3],
+				"duration_ms": milliseconds(duration),
+				"rows":        values[5],
+			}).Info("query")
+			return
+		}
+	case "error":
+		entry.Error(values[2:]...)
+		return
+	}
+	entry.Info(values[2:]...)
+}
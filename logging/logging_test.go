@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/gin-gonic/gin"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestNew(t *testing.T) {
+	logger, err := New(config.Log{}, "development", &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, logrus.InfoLevel, logger.Level)
+	assert.IsType(t, &logrus.TextFormatter{}, logger.Formatter)
+
+	logger, err = New(config.Log{Level: "debug"}, "production", &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, logrus.DebugLevel, logger.Level)
+	assert.IsType(t, &logrus.JSONFormatter{}, logger.Formatter)
+
+	logger, err = New(config.Log{Format: "text"}, "production", &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.IsType(t, &logrus.TextFormatter{}, logger.Formatter)
+
+	_, err = New(config.Log{Level: "trace level"}, "", &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = New(config.Log{Format: "xml"}, "", &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+// logLines decode the JSON lines of the logs
+func logLines(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		fields := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestMiddleware(t *testing.T) {
+	databases, err := db.Open([]config.Database{{Name: "default", Type: "sqlite3", DSN: ":memory:", Logging: true}})
+	require.NoError(t, err)
+	defer databases.Close()
+
+	logs := &bytes.Buffer{}
+	logger, err := New(config.Log{Format: "json"}, "", logs)
+	require.NoError(t, err)
+	router := gin.New()
+	router.Use(Middleware(logger))
+	router.GET("/query", func(ctx *gin.Context) {
+		entry, ok := FromContext(ctx.Request.Context())
+		require.True(t, ok)
+		entry.Info("handling")
+		db.WithContext(databases.Get(db.Default), ctx.Request.Context()).Exec("SELECT ?", "secret")
+		ctx.String(http.StatusOK, RequestID(ctx))
+	})
+	router.GET("/panic", func(ctx *gin.Context) { panic("failed") })
+
+	serve := func(url, requestID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		if requestID != "" {
+			request.Header.Set(RequestIDHeader, requestID)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("honoured request ID", func(t *testing.T) {
+		logs.Reset()
+		response := serve("/query", "client-id")
+		assert.Equal(t, "client-id", response.Header().Get(RequestIDHeader))
+		assert.Equal(t, "client-id", response.Body.String())
+
+		lines := logLines(t, logs)
+		require.Len(t, lines, 3)
+		for _, line := range lines {
+			assert.Equal(t, "client-id", line["request_id"])
+		}
+		assert.Equal(t, "handling", lines[0]["msg"])
+		assert.Equal(t, "query", lines[1]["msg"])
+		assert.Equal(t, "SELECT ?", lines[1]["sql"])
+		assert.NotContains(t, logs.String(), "secret", "the values of the statements aren't logged")
+		assert.Equal(t, "request", lines[2]["msg"])
+		assert.Equal(t, float64(http.StatusOK), lines[2]["status"])
+		assert.Equal(t, "/query", lines[2]["path"])
+	})
+
+	t.Run("generated request ID", func(t *testing.T) {
+		for _, requestID := range []string{"", "with space", strings.Repeat("a", maxRequestIDLength+1)} {
+			response := serve("/query", requestID)
+			assert.Len(t, response.Header().Get(RequestIDHeader), 32)
+			assert.NotEqual(t, requestID, response.Header().Get(RequestIDHeader))
+		}
+		assert.NotEqual(t, serve("/query", "").Body.String(), serve("/query", "").Body.String())
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		logs.Reset()
+		response := serve("/panic", "panic-id")
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+
+		lines := logLines(t, logs)
+		require.Len(t, lines, 2)
+		assert.Equal(t, "failed", lines[0]["panic"])
+		assert.Equal(t, "error", lines[0]["level"])
+		assert.Equal(t, "panic-id", lines[0]["request_id"])
+		assert.Equal(t, "error", lines[1]["level"])
+	})
+}
@@ -0,0 +1,77 @@
+// Package metrics collect the metrics which client_golang doesn't provide,
+// a subsystem registers its collectors into the Prometheus registry of the app
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolMetric of the pool stats
+type poolMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(pool *db.PoolStats) float64
+}
+
+func newPoolMetric(name, help string, valueType prometheus.ValueType, value func(pool *db.PoolStats) float64) poolMetric {
+	return poolMetric{prometheus.NewDesc(name, help, []string{"db", "replica"}, nil), valueType, value}
+}
+
+var poolMetrics = []poolMetric{
+	newPoolMetric("db_pool_max_open_connections", "Maximum number of open connections of the pool.", prometheus.GaugeValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.MaxOpen) }),
+	newPoolMetric("db_pool_open_connections", "Number of open connections of the pool.", prometheus.GaugeValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.Open) }),
+	newPoolMetric("db_pool_in_use_connections", "Number of connections in use.", prometheus.GaugeValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.InUse) }),
+	newPoolMetric("db_pool_idle_connections", "Number of idle connections.", prometheus.GaugeValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.Idle) }),
+	newPoolMetric("db_pool_wait_count_total", "Total number of connections waited for.", prometheus.CounterValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.WaitCount) }),
+	newPoolMetric("db_pool_wait_duration_seconds_total", "Total time blocked waiting for a connection.", prometheus.CounterValue,
+		func(pool *db.PoolStats) float64 { return pool.WaitDuration.Seconds() }),
+	newPoolMetric("db_pool_max_idle_closed_total", "Total number of connections closed by the idle limit.", prometheus.CounterValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.MaxIdleClosed) }),
+	newPoolMetric("db_pool_max_lifetime_closed_total", "Total number of connections closed by the lifetime limit.", prometheus.CounterValue,
+		func(pool *db.PoolStats) float64 { return float64(pool.MaxLifetimeClosed) }),
+}
+
+// poolCollector collect the pool stats on every scrape
+type poolCollector struct {
+	stats func() []db.PoolStats
+}
+
+// PoolCollector collect the connection pool stats on every scrape,
+// the samples are labeled with the database name and the replica, "primary" or its index.
+// The label isn't "instance" since Prometheus labels the scraped target with it
+func PoolCollector(stats func() []db.PoolStats) prometheus.Collector {
+	return &poolCollector{stats}
+}
+
+// Describe implements prometheus.Collector
+func (c *poolCollector) Describe(descs chan<- *prometheus.Desc) {
+	for _, metric := range poolMetrics {
+		descs <- metric.desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *poolCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, pool := range c.stats() {
+		replica := "primary"
+		if pool.Replica >= 0 {
+			replica = strconv.Itoa(pool.Replica)
+		}
+		for _, metric := range poolMetrics {
+			metrics <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(&pool), pool.Instance, replica)
+		}
+	}
+}
+
+// RegisterDatabases register the pool stats of every database instance
+func RegisterDatabases(registerer prometheus.Registerer, databases *db.Databases) error {
+	return registerer.Register(PoolCollector(databases.Stats))
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolCollector(t *testing.T) {
+	collector := PoolCollector(func() []db.PoolStats {
+		return []db.PoolStats{
+			{Instance: "default", Replica: -1, MaxOpen: 10, Open: 2, InUse: 1, Idle: 1, WaitDuration: 1500 * time.Millisecond},
+			{Instance: "default", Replica: 0, Open: 1},
+		}
+	})
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+	assert.Error(t, registry.Register(PoolCollector(nil)), "the pool metrics are registered once")
+
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP db_pool_open_connections Number of open connections of the pool.
+# TYPE db_pool_open_connections gauge
+db_pool_open_connections{db="default",replica="primary"} 2
+db_pool_open_connections{db="default",replica="0"} 1
+# HELP db_pool_wait_duration_seconds_total Total time blocked waiting for a connection.
+# TYPE db_pool_wait_duration_seconds_total counter
+db_pool_wait_duration_seconds_total{db="default",replica="primary"} 1.5
+db_pool_wait_duration_seconds_total{db="default",replica="0"} 0
+`), "db_pool_open_connections", "db_pool_wait_duration_seconds_total"))
+	assert.Equal(t, 16, testutil.CollectAndCount(collector))
+}
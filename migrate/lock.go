@@ -0,0 +1,112 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Locker prevent several instances migrating at the same time
+type Locker interface {
+	// Lock block until the lock is acquired, unlock must be called after done
+	Lock() (unlock func() error, err error)
+}
+
+// LockTimeout of waiting the other instance to finish migrating
+var LockTimeout = time.Minute
+
+const (
+	lockName = "schema_migrations"
+	// lockKey of the postgres advisory lock, any number shared by the instances
+	lockKey = int64(1734367390)
+)
+
+// ErrLockTimeout returned when the lock is not acquired before the LockTimeout
+var ErrLockTimeout = errors.New("migrate: timeout waiting for the migration lock")
+
+// NewLocker make the advisory locker by the dialect of the database,
+// dialects without advisory lock are assumed to be used by a single instance
+func NewLocker(db *gorm.DB) Locker {
+	sqlDB, ok := db.CommonDB().(*sql.DB)
+	if !ok {
+		return noLocker{}
+	}
+
+	switch db.Dialect().GetName() {
+	case "mysql":
+		return &advisoryLocker{
+			db:          sqlDB,
+			lockQuery:   "SELECT GET_LOCK(?, ?)",
+			lockArgs:    []interface{}{lockName, int(LockTimeout / time.Second)},
+			unlockQuery: "SELECT RELEASE_LOCK(?)",
+			unlockArgs:  []interface{}{lockName},
+		}
+	case "postgres":
+		return &advisoryLocker{
+			db:          sqlDB,
+			lockQuery:   "SELECT 1 FROM pg_advisory_lock($1)",
+			lockArgs:    []interface{}{lockKey},
+			unlockQuery: "SELECT pg_advisory_unlock($1)",
+			unlockArgs:  []interface{}{lockKey},
+			timeout:     LockTimeout,
+		}
+	default:
+		return noLocker{}
+	}
+}
+
+type noLocker struct{}
+
+func (noLocker) Lock() (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// advisoryLocker hold the lock on a dedicated connection,
+// the lock is released by the database when the connection is lost
+type advisoryLocker struct {
+	db          *sql.DB
+	lockQuery   string
+	lockArgs    []interface{}
+	unlockQuery string
+	unlockArgs  []interface{}
+	// timeout of the lock query, for databases which wait forever
+	timeout time.Duration
+}
+
+func (l *advisoryLocker) Lock() (func() error, error) {
+	ctx := context.Background()
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockCtx := ctx
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	// MySQL returns 1 when locked and 0 when timeout
+	var result sql.NullInt64
+	if err := conn.QueryRowContext(lockCtx, l.lockQuery, l.lockArgs...).Scan(&result); err != nil {
+		conn.Close()
+		if lockCtx.Err() == context.DeadlineExceeded {
+			return nil, ErrLockTimeout
+		}
+		return nil, err
+	}
+	if result.Valid && result.Int64 != 1 {
+		conn.Close()
+		return nil, ErrLockTimeout
+	}
+
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(ctx, l.unlockQuery, l.unlockArgs...)
+		return err
+	}, nil
+}
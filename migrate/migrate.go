@@ -0,0 +1,260 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/jinzhu/gorm"
+)
+
+// Migration changes the schema from the previous version,
+// Up and Down run inside a transaction when the database supports it
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// Down is nil when the migration is irreversible
+	Down func(tx *gorm.DB) error
+}
+
+// Status of the migration
+type Status struct {
+	Version   uint64
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	// Missing is true when the applied version is not known by the code
+	Missing bool
+}
+
+// SchemaMigration model, the applied migrations
+type SchemaMigration struct {
+	Version   uint64    `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"size:255;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// Migrator apply the migrations into a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	locker     Locker
+}
+
+// TableName of the applied migrations
+const TableName = "schema_migrations"
+
+// Errors
+var (
+	ErrIrreversible = errors.New("migrate: migration is irreversible")
+	ErrNoMigration  = errors.New("migrate: no migration to redo")
+)
+
+// TableName of the SchemaMigration model
+func (SchemaMigration) TableName() string {
+	return TableName
+}
+
+// New migrator, the migrations are sorted by the version
+func New(db *gorm.DB, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	for i, migration := range sorted {
+		if migration.Version == 0 {
+			return nil, fmt.Errorf("migrate: %q has no version", migration.Name)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migrate: %d_%s has no up migration", migration.Version, migration.Name)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", migration.Version)
+		}
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+		locker:     NewLocker(db),
+	}, nil
+}
+
+// SetLocker replace the default locker
+func (m *Migrator) SetLocker(locker Locker) {
+	m.locker = locker
+}
+
+// Up apply every pending migration, returns the applied migrations
+func (m *Migrator) Up() ([]Migration, error) {
+	applied := []Migration{}
+	err := m.withLock(func(versions map[uint64]SchemaMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(migration); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down revert the last applied migrations, returns the reverted migrations
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	reverted := []Migration{}
+	err := m.withLock(func(versions map[uint64]SchemaMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(migration); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Redo revert and apply the last applied migration again
+func (m *Migrator) Redo() (*Migration, error) {
+	var redone *Migration
+	err := m.withLock(func(versions map[uint64]SchemaMigration) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(migration); err != nil {
+				return err
+			}
+			if err := m.apply(migration); err != nil {
+				return err
+			}
+			redone = &migration
+			return nil
+		}
+		return ErrNoMigration
+	})
+
+	return redone, err
+}
+
+// Status of every known and applied migration, ordered by the version
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if applied, ok := versions[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &applied.AppliedAt
+			delete(versions, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, applied := range versions {
+		appliedAt := applied.AppliedAt
+		statuses = append(statuses, Status{
+			Version:   version,
+			Name:      applied.Name,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+func (m *Migrator) withLock(fn func(versions map[uint64]SchemaMigration) error) error {
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	unlock, err := m.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// read after locked, another instance may have migrated while waiting
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return err
+	}
+
+	return fn(versions)
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.AutoMigrate(&SchemaMigration{}).Error
+}
+
+func (m *Migrator) appliedVersions() (map[uint64]SchemaMigration, error) {
+	rows := []SchemaMigration{}
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	versions := map[uint64]SchemaMigration{}
+	for _, row := range rows {
+		versions[row.Version] = row
+	}
+	return versions, nil
+}
+
+func (m *Migrator) apply(migration Migration) error {
+	return m.transaction(func(tx *gorm.DB) error {
+		if err := migration.Up(tx); err != nil {
+			return fmt.Errorf("migrate: up %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		return tx.Create(&SchemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+		}).Error
+	})
+}
+
+func (m *Migrator) revert(migration Migration) error {
+	if migration.Down == nil {
+		return fmt.Errorf("%v: %d_%s", ErrIrreversible, migration.Version, migration.Name)
+	}
+
+	return m.transaction(func(tx *gorm.DB) error {
+		if err := migration.Down(tx); err != nil {
+			return fmt.Errorf("migrate: down %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+	})
+}
+
+func (m *Migrator) transaction(fn func(tx *gorm.DB) error) error {
+	return db.Transaction(m.db, func(tx *db.Tx) error {
+		return fn(tx.DB)
+	})
+}
@@ -0,0 +1,220 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// every connection of the in-memory database is a new database
+	db.DB().SetMaxOpenConns(1)
+	return db
+}
+
+func createTable(name string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec("CREATE TABLE " + name + " (id INTEGER PRIMARY KEY)").Error
+	}
+}
+
+func dropTable(name string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec("DROP TABLE " + name).Error
+	}
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 2, Name: "create_b", Up: createTable("b"), Down: dropTable("b")},
+		{Version: 1, Name: "create_a", Up: createTable("a"), Down: dropTable("a")},
+	}
+}
+
+type countLocker struct {
+	locked   int
+	unlocked int
+}
+
+func (l *countLocker) Lock() (func() error, error) {
+	l.locked++
+	return func() error {
+		l.unlocked++
+		return nil
+	}, nil
+}
+
+func TestNew(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	cases := []struct {
+		name       string
+		migrations []Migration
+	}{
+		{"no version", []Migration{{Name: "a", Up: createTable("a")}}},
+		{"no up", []Migration{{Version: 1, Name: "a"}}},
+		{"duplicate version", []Migration{
+			{Version: 1, Name: "a", Up: createTable("a")},
+			{Version: 1, Name: "b", Up: createTable("b")},
+		}},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := New(db, testCase.migrations)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrator, err := New(db, testMigrations())
+	require.NoError(t, err)
+	locker := &countLocker{}
+	migrator.SetLocker(locker)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+	assert.Equal(t, uint64(1), applied[0].Version)
+	assert.True(t, db.HasTable("a"))
+	assert.True(t, db.HasTable("b"))
+
+	applied, err = migrator.Up()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	redone, err := migrator.Redo()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), redone.Version)
+	assert.True(t, db.HasTable("b"))
+
+	reverted, err := migrator.Down(1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	assert.Equal(t, uint64(2), reverted[0].Version)
+	assert.False(t, db.HasTable("b"))
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.False(t, statuses[1].Applied)
+
+	reverted, err = migrator.Down(5)
+	require.NoError(t, err)
+	assert.Len(t, reverted, 1)
+	assert.False(t, db.HasTable("a"))
+
+	_, err = migrator.Redo()
+	assert.Equal(t, ErrNoMigration, err)
+
+	assert.Equal(t, 6, locker.locked)
+	assert.Equal(t, locker.locked, locker.unlocked)
+}
+
+func TestMigratorFailure(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrations := append(testMigrations(), Migration{
+		Version: 3,
+		Name:    "broken",
+		Up: func(tx *gorm.DB) error {
+			if err := createTable("c")(tx); err != nil {
+				return err
+			}
+			return errors.New("broken")
+		},
+	})
+	migrator, err := New(db, migrations)
+	require.NoError(t, err)
+
+	applied, err := migrator.Up()
+	assert.Error(t, err)
+	assert.Len(t, applied, 2)
+	// the failed migration is rolled back
+	assert.False(t, db.HasTable("c"))
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	assert.False(t, statuses[2].Applied)
+
+	// irreversible migration stops reverting
+	migrator.migrations[2].Up = createTable("c")
+	_, err = migrator.Up()
+	require.NoError(t, err)
+	reverted, err := migrator.Down(3)
+	assert.Error(t, err)
+	assert.Empty(t, reverted)
+}
+
+func TestMigratorMissing(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	migrator, err := New(db, testMigrations())
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	migrator, err = New(db, testMigrations()[1:])
+	require.NoError(t, err)
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "create_b", statuses[1].Name)
+	assert.True(t, statuses[1].Missing)
+}
+
+func TestLoadSQL(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	migrations, err := LoadSQL(fs, "migrations/default")
+	require.NoError(t, err)
+	assert.Empty(t, migrations)
+
+	files := map[string]string{
+		"migrations/default/0001_create_a.up.sql":   "CREATE TABLE a (id INTEGER PRIMARY KEY);\nINSERT INTO a (id) VALUES (1);\n",
+		"migrations/default/0001_create_a.down.sql": "DROP TABLE a;",
+		"migrations/default/0002_create_b.up.sql":   "CREATE TABLE b (id INTEGER PRIMARY KEY)",
+		"migrations/default/README.md":              "not a migration",
+	}
+	for name, content := range files {
+		require.NoError(t, afero.WriteFile(fs, name, []byte(content), 0644))
+	}
+
+	migrations, err = LoadSQL(fs, "migrations/default")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "create_a", migrations[0].Name)
+	assert.NotNil(t, migrations[0].Down)
+	assert.Nil(t, migrations[1].Down)
+
+	db := openTestDB(t)
+	defer db.Close()
+	migrator, err := New(db, migrations)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, db.Table("a").Count(&count).Error)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, afero.WriteFile(fs, "migrations/default/0002_create_c.down.sql", []byte(""), 0644))
+	_, err = LoadSQL(fs, "migrations/default")
+	assert.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package migrate
+
+import (
+	"path"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/spf13/afero"
+)
+
+// SQLDir is the default directory of the SQL migrations, one directory per database instance name
+const SQLDir = "migrations"
+
+var registry = map[db.Instance][]Migration{}
+
+// Register Go migrations of the database instance, usually called from init
+func Register(instance db.Instance, migrations ...Migration) {
+	registry[instance] = append(registry[instance], migrations...)
+}
+
+// Registered Go migrations of the database instance
+func Registered(instance db.Instance) []Migration {
+	return registry[instance]
+}
+
+// ForInstance make the migrator of the primary of the database instance,
+// from the registered Go migrations and the SQL migrations in "<dir>/<instance name>"
+func ForInstance(fs afero.Fs, dir string, databases *db.Databases, instance db.Instance) (*Migrator, error) {
+	migrations, err := LoadSQL(fs, path.Join(dir, databases.Name(instance)))
+	if err != nil {
+		return nil, err
+	}
+
+	return New(databases.Primary(instance), append(migrations, Registered(instance)...))
+}
@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+)
+
+// sqlFilePattern match "<version>_<name>.up.sql" and "<version>_<name>.down.sql"
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_([\w-]+)\.(up|down)\.sql$`)
+
+// statementSeparator split the statements, a statement ends with ";" at the end of the line
+var statementSeparator = regexp.MustCompile(`;\s*(\n|$)`)
+
+// LoadSQL migrations from the directory,
+// a missing directory is not an error since it means there is no SQL migration
+func LoadSQL(fs afero.Fs, dir string) ([]Migration, error) {
+	exists, err := afero.DirExists(fs, dir)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	infos, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint64]*Migration{}
+	versions := []uint64{}
+	for _, info := range infos {
+		matches := sqlFilePattern.FindStringSubmatch(info.Name())
+		if info.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version of %s: %v", info.Name(), err)
+		}
+
+		content, err := afero.ReadFile(fs, path.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+			versions = append(versions, version)
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d has different names %q and %q", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = execSQL(string(content))
+		} else {
+			migration.Down = execSQL(string(content))
+		}
+	}
+
+	migrations := []Migration{}
+	for _, version := range versions {
+		migrations = append(migrations, *byVersion[version])
+	}
+	return migrations, nil
+}
+
+func execSQL(content string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, statement := range statementSeparator.Split(content, -1) {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
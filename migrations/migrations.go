@@ -0,0 +1,67 @@
+// Package migrations register the schema migrations of the application,
+// SQL migrations are placed in the directory named by the database instance
+package migrations
+
+import (
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/migrate"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	migrate.Register(db.Default,
+		migrate.Migration{
+			Version: 1,
+			Name:    "create_users",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&models.UserRole{}).Error; err != nil {
+					return err
+				}
+				if err := tx.AutoMigrate(&models.User{}, &models.UserAttribute{}).Error; err != nil {
+					return err
+				}
+				user := tx.Model(&models.User{})
+				if err := user.AddIndex("role_id__index", "role_id").Error; err != nil {
+					return err
+				}
+				if tx.Dialect().GetName() == "sqlite3" {
+					// sqlite can't add a foreign key into an existing table
+					return nil
+				}
+				return user.AddForeignKey("role_id", "user_role(id)", "RESTRICT", "CASCADE").Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.DropTableIfExists(&models.UserAttribute{}, &models.User{}, &models.UserRole{}).Error
+			},
+		},
+		migrate.Migration{
+			Version: 2,
+			Name:    "single_default_role",
+			Up: func(tx *gorm.DB) error {
+				// keep the first default role of the existing rows
+				if err := tx.Exec(`UPDATE user_role SET is_default = false
+					WHERE is_default = true AND id <> (
+						SELECT id FROM (SELECT MIN(id) AS id FROM user_role WHERE is_default = true) AS first_default
+					)`).Error; err != nil {
+					return err
+				}
+				if tx.Dialect().GetName() == "mysql" {
+					// mysql has no partial index, the generated column is null for the other roles
+					return tx.Exec(`ALTER TABLE user_role
+						ADD COLUMN default_flag TINYINT AS (IF(is_default, 1, NULL)) STORED,
+						ADD UNIQUE INDEX user_role__single_default (default_flag)`).Error
+				}
+				return tx.Exec("CREATE UNIQUE INDEX user_role__single_default ON user_role (is_default) WHERE is_default").Error
+			},
+			Down: func(tx *gorm.DB) error {
+				if tx.Dialect().GetName() == "mysql" {
+					return tx.Exec(`ALTER TABLE user_role
+						DROP INDEX user_role__single_default,
+						DROP COLUMN default_flag`).Error
+				}
+				return tx.Exec("DROP INDEX user_role__single_default").Error
+			},
+		},
+	)
+}
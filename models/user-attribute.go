@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+)
+
+// UserAttribute types
+const (
+	UserAttributeString  = "string"
+	UserAttributeNumber  = "number"
+	UserAttributeBoolean = "boolean"
+)
+
+// userAttributeNamePattern restrict the attribute names to the safe characters,
+// they are used in the JSON paths of the filter queries
+var userAttributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ValidUserAttributeName is true for the lowercase names of letters, digits and underscores
+func ValidUserAttributeName(name string) bool {
+	return userAttributeNamePattern.MatchString(name)
+}
+
+// UserAttribute model, definition of the custom profile attribute
+type UserAttribute struct {
+	ID       uint32     `json:"id,omitempty"`
+	Name     string     `json:"name,omitempty" gorm:"unique_index;size:64;not null"`
+	Type     string     `json:"type,omitempty" gorm:"size:16;not null"`
+	Required bool       `json:"required,omitempty" gorm:"not null"`
+	Pattern  string     `json:"pattern,omitempty" gorm:"size:255"`
+	Enum     StringList `json:"enum,omitempty" gorm:"type:text"`
+}
+
+// Attributes of the user, stored as JSON
+type Attributes map[string]interface{}
+
+// StringList stored as JSON array
+type StringList []string
+
+var errInvalidJSONColumn = errors.New("models: JSON column must be a string or bytes")
+
+// Value implements driver.Valuer
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return jsonValue(a)
+}
+
+// Scan implements sql.Scanner
+func (a *Attributes) Scan(src interface{}) error {
+	return jsonScan(src, a)
+}
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return jsonValue(l)
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(src interface{}) error {
+	return jsonScan(src, l)
+}
+
+func jsonValue(v interface{}) (driver.Value, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+func jsonScan(src interface{}, dst interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(value, dst)
+	case string:
+		return json.Unmarshal([]byte(value), dst)
+	default:
+		return errInvalidJSONColumn
+	}
+}
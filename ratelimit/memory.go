@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval of removing the full buckets of the memory store
+const sweepInterval = time.Minute
+
+// MemoryStore keep the buckets in the memory of the process
+type MemoryStore struct {
+	// now is the clock of the buckets, replaced by the tests
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	// full is the time when the bucket is full again, it is removed after
+	full time.Time
+}
+
+// NewMemoryStore without bucket
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, buckets: map[string]*memoryBucket{}}
+}
+
+// Take a token of the bucket of the key
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	result := take(&b.bucket, now, limit)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep the buckets which are full, they are created full again on the next request.
+// The caller holds the lock
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < sweepInterval {
+		return
+	}
+	s.swept = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
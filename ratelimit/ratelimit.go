@@ -0,0 +1,125 @@
+// Package ratelimit limit the requests with token buckets kept in a store,
+// the buckets are kept in memory or in Redis so the instances of the app share them
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+)
+
+// Store types
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// Limit of a bucket, it holds Burst tokens and Requests tokens are added every Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// ErrInvalidLimit is returned for the limit without requests or period, no token would ever be added
+var ErrInvalidLimit = errors.New("ratelimit: requests and period must be positive")
+
+// NewLimit of the config, the burst is the requests when it is zero
+func NewLimit(cnf config.Limit) (Limit, error) {
+	if cnf.Requests <= 0 || cnf.Period.Duration <= 0 || cnf.Burst < 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	limit := Limit{Requests: cnf.Requests, Period: cnf.Period.Duration, Burst: cnf.Burst}
+	if limit.Burst == 0 {
+		limit.Burst = limit.Requests
+	}
+	return limit, nil
+}
+
+// interval between two tokens
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result of taking a token
+type Result struct {
+	Allowed bool
+	// Limit of the tokens of the bucket, it is the burst
+	Limit int
+	// Remaining tokens once the token is taken
+	Remaining int
+	// RetryAfter is the time until the next token, zero when the request is allowed
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full
+	Reset time.Duration
+}
+
+// Store of the buckets
+type Store interface {
+	// Take a token of the bucket of the key, the bucket is created full
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// New store of the config, the redis store must be closed.
+// The limits of the groups are checked so an invalid group fails at startup
+func New(cnf config.RateLimit) (Store, error) {
+	for name, limit := range cnf.Groups {
+		if _, err := NewLimit(limit); err != nil {
+			return nil, fmt.Errorf("ratelimit: invalid limit of the group %q, requests and period must be positive", name)
+		}
+	}
+
+	switch cnf.Store {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreRedis:
+		return NewRedisStore(RedisConfig{
+			Addr:     cnf.Redis.Addr,
+			Password: cnf.Redis.Password,
+			DB:       cnf.Redis.DB,
+			Prefix:   cnf.Redis.Prefix,
+		}), nil
+	}
+	return nil, fmt.Errorf("ratelimit: unknown store %q", cnf.Store)
+}
+
+// bucket of tokens, the tokens are added when a token is taken
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take a token of the bucket at now, the missing bucket is full
+func take(b *bucket, now time.Time, limit Limit) Result {
+	if b.updated.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(elapsed)/float64(limit.interval()))
+	}
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(allowed, b.tokens, limit)
+}
+
+// newResult of the tokens left in the bucket
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	interval := float64(limit.interval())
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(limit.Burst) - tokens) * interval)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration(math.Ceil((1 - tokens) * interval))
+	}
+	return result
+}
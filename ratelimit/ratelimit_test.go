@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore take the tokens of a bucket of 2 tokens which gets a token every second
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	t.Helper()
+	ctx := context.Background()
+	limit := Limit{Requests: 1, Period: time.Second, Burst: 2}
+	takeToken := func(key string) Result {
+		t.Helper()
+		result, err := store.Take(ctx, key, limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, takeToken("a"))
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, takeToken("a"))
+	assert.Equal(t, Result{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: time.Second, Reset: 2 * time.Second}, takeToken("a"))
+	// the buckets are independent
+	assert.True(t, takeToken("b").Allowed)
+
+	advance(500 * time.Millisecond)
+	denied := takeToken("a")
+	assert.False(t, denied.Allowed)
+	assert.Equal(t, 500*time.Millisecond, denied.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, denied.Reset)
+
+	advance(500 * time.Millisecond)
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, takeToken("a"))
+
+	// the bucket isn't filled above the burst
+	advance(time.Hour)
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, takeToken("a"))
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	// the full buckets are swept, "b" is swept once the hour passed
+	assert.Len(t, store.buckets, 1)
+	now = now.Add(sweepInterval)
+	_, err := store.Take(context.Background(), "c", Limit{Requests: 1, Period: time.Second, Burst: 1})
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+	assert.Contains(t, store.buckets, "c")
+}
+
+func TestNewLimit(t *testing.T) {
+	limit, err := NewLimit(config.Limit{Requests: 10, Period: config.Duration{Duration: time.Minute}})
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Requests: 10, Period: time.Minute, Burst: 10}, limit)
+	assert.Equal(t, 6*time.Second, limit.interval())
+
+	limit, err = NewLimit(config.Limit{Requests: 10, Period: config.Duration{Duration: time.Minute}, Burst: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3, limit.Burst)
+
+	// the interval of the tokens would divide by zero
+	_, err = NewLimit(config.Limit{Period: config.Duration{Duration: time.Minute}})
+	assert.Equal(t, ErrInvalidLimit, err)
+	_, err = NewLimit(config.Limit{Requests: 10})
+	assert.Equal(t, ErrInvalidLimit, err)
+}
+
+func TestNew(t *testing.T) {
+	store, err := New(config.RateLimit{})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	cnf := config.RateLimit{Store: StoreRedis}
+	cnf.Redis.Addr = "localhost:6379"
+	store, err = New(cnf)
+	require.NoError(t, err)
+	assert.IsType(t, &RedisStore{}, store)
+	store.(*RedisStore).Close()
+
+	_, err = New(config.RateLimit{Store: "memcached"})
+	assert.Error(t, err)
+
+	_, err = New(config.RateLimit{Groups: map[string]config.Limit{"login": {Period: config.Duration{Duration: time.Minute}}}})
+	assert.EqualError(t, err, `ratelimit: invalid limit of the group "login", requests and period must be positive`)
+}
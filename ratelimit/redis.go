@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const defaultRedisPrefix = "ratelimit:"
+
+// RedisConfig of the redis store
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix of the bucket keys, "ratelimit:" by default
+	Prefix string
+}
+
+// takeScript take a token of the bucket atomically, the bucket is a hash of its tokens and its update time
+// in microseconds. It expires once it is full, so a missing bucket is full.
+// It returns 1 when the token is taken and the tokens left, as a string since Redis truncates the numbers
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+elseif now > updated then
+	tokens = math.min(burst, tokens + (now - updated) / interval)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) * interval / 1000))
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore keep the buckets in Redis, so the instances of the app share them.
+// The buckets are updated with the clock of the app, the clocks of the instances must be synchronized
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	// now is the clock of the buckets, replaced by the tests
+	now func() time.Time
+}
+
+// NewRedisStore connected to the Redis of the config, it connects on the first request
+func NewRedisStore(cnf RedisConfig) *RedisStore {
+	prefix := cnf.Prefix
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	client := redis.NewClient(&redis.Options{Addr: cnf.Addr, Password: cnf.Password, DB: cnf.DB})
+	return &RedisStore{client: client, prefix: prefix, now: time.Now}
+}
+
+// Take a token of the bucket of the key
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	interval := float64(limit.interval()) / float64(time.Microsecond)
+	now := s.now().UnixNano() / int64(time.Microsecond)
+	reply, err := takeScript.Run(s.client.WithContext(ctx), []string{s.prefix + key}, limit.Burst, interval, now).Result()
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	tokensValue, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensValue, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: unexpected tokens %q", tokensValue)
+	}
+	return newResult(allowed == 1, tokens, limit), nil
+}
+
+// Ping Redis
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.WithContext(ctx).Ping().Err()
+}
+
+// Close the connections to Redis
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStore(t *testing.T) {
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+	defer server.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewRedisStore(RedisConfig{Addr: server.Addr(), Prefix: "test:"})
+	defer store.Close()
+	store.now = func() time.Time { return now }
+	require.NoError(t, store.Ping(context.Background()))
+
+	testStore(t, store, func(d time.Duration) {
+		now = now.Add(d)
+		server.FastForward(d)
+	})
+
+	// the bucket expires once it is full
+	assert.True(t, server.Exists("test:a"))
+	assert.Equal(t, time.Second, server.TTL("test:a"))
+	server.FastForward(time.Second)
+	assert.False(t, server.Exists("test:a"))
+
+	server.Close()
+	_, err = store.Take(context.Background(), "a", Limit{Requests: 1, Period: time.Second, Burst: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,336 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/jinzhu/gorm"
+)
+
+type gormStore struct {
+	db *gorm.DB
+	// tx is set when the store runs in a transaction, the nested units use its savepoints
+	tx *db.Tx
+}
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+type gormRoleRepository struct {
+	db *gorm.DB
+}
+
+// NewGormStore of the database session
+func NewGormStore(g *gorm.DB) Store {
+	return &gormStore{db: g}
+}
+
+// NewTxStore of the transaction
+func NewTxStore(tx *db.Tx) Store {
+	return &gormStore{db: tx.DB, tx: tx}
+}
+
+func (s *gormStore) Users() UserRepository {
+	return &gormUserRepository{s.db}
+}
+
+func (s *gormStore) Roles() RoleRepository {
+	return &gormRoleRepository{s.db}
+}
+
+// Atomic run fn in a savepoint of the transaction,
+// or in a new transaction when the store doesn't run in one
+func (s *gormStore) Atomic(fn func(store Store) error) error {
+	run := func(tx *db.Tx) error {
+		return fn(NewTxStore(tx))
+	}
+	if s.tx != nil {
+		return s.tx.Savepoint(run)
+	}
+	return db.Transaction(s.db, run)
+}
+
+func (r *gormUserRepository) Get(id uint64) (*models.User, error) {
+	user := &models.User{}
+	if err := r.db.First(user, id).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) GetWithRole(id uint64) (*models.User, error) {
+	user := &models.User{Role: &models.UserRole{}}
+	if err := r.db.
+		Select("id, email, username, name, enabled, attributes, role_id").
+		First(user, id).
+		Error; err != nil {
+		return nil, err
+	}
+	if err := r.db.
+		Model(user).
+		Related(user.Role, "Role").
+		Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) GetByUsername(username string) (*models.User, error) {
+	user := &models.User{}
+	if err := r.db.First(user, "username = ?", username).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *gormUserRepository) Exists(field, value string) (bool, error) {
+	if _, ok := userLookupFields[field]; !ok {
+		return false, ErrInvalidField
+	}
+
+	exists := false
+	err := r.db.
+		Model(&models.User{}).
+		Select("1").
+		Where(field+" = ?", value).
+		Row().
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (r *gormUserRepository) List(filter UserFilter, options ListOptions) ([]models.User, uint64, error) {
+	query := r.db.Model(&models.User{})
+	for _, name := range sortedKeys(filter.Attributes) {
+		condition, args, err := attributeCondition(query.Dialect().GetName(), name, filter.Attributes[name])
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(condition, args...)
+	}
+
+	users := []models.User{}
+	if err := applyListOptions(query, options).
+		Select("id, email, username, name, enabled, verified, attributes").
+		Find(&users).
+		Error; err != nil {
+		return nil, 0, err
+	}
+
+	count := uint64(0)
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, count, nil
+}
+
+func (r *gormUserRepository) Create(user *models.User) error {
+	return r.db.Model(user).Create(user).Error
+}
+
+func (r *gormUserRepository) Update(user *models.User) error {
+	return r.db.Model(user).UpdateColumns(user).Error
+}
+
+func (r *gormUserRepository) SetEnabled(id uint64, enabled bool) error {
+	return r.db.
+		Model(&models.User{}).
+		Where("id = ?", id).
+		UpdateColumn("enabled", enabled).
+		Error
+}
+
+func (r *gormUserRepository) Delete(id uint64) error {
+	return r.db.Delete(&models.User{}, id).Error
+}
+
+func (r *gormUserRepository) CountByRole(roleID uint32) (uint64, error) {
+	count := uint64(0)
+	err := r.db.
+		Model(&models.User{}).
+		Where("role_id = ?", roleID).
+		Count(&count).
+		Error
+	return count, err
+}
+
+func (r *gormUserRepository) ReassignRole(fromID, toID uint32) error {
+	return r.db.
+		Model(&models.User{}).
+		Where("role_id = ?", fromID).
+		UpdateColumn("role_id", toID).
+		Error
+}
+
+func (r *gormRoleRepository) Get(id uint32) (*models.UserRole, error) {
+	role := &models.UserRole{}
+	if err := r.db.First(role, id).Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *gormRoleRepository) GetByName(name string) (*models.UserRole, error) {
+	role := &models.UserRole{}
+	if err := r.db.Where("name = ?", name).First(role).Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *gormRoleRepository) GetDefault() (*models.UserRole, error) {
+	role := &models.UserRole{}
+	if err := r.db.Where("is_default = ?", true).First(role).Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *gormRoleRepository) GetByUser(userID uint64) (*models.UserRole, error) {
+	role := &models.UserRole{}
+	userTable := r.db.Dialect().Quote("user")
+	if err := r.db.
+		Table("user_role").
+		Select("user_role.name").
+		Joins("INNER JOIN "+userTable+" ON user_role.id = "+userTable+".role_id").
+		Where(userTable+".id = ?", userID).
+		First(role).
+		Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *gormRoleRepository) List(filter RoleFilter, options ListOptions) ([]models.UserRole, uint64, error) {
+	query := r.db.Model(&models.UserRole{})
+	if filter.Search != "" {
+		query = query.Where("name LIKE ? ESCAPE '"+likeEscape+"'", "%"+escapeLike(filter.Search)+"%")
+	}
+	if filter.Enabled != nil {
+		query = query.Where("enabled = ?", *filter.Enabled)
+	}
+
+	roles := []models.UserRole{}
+	if err := applyListOptions(query, options).
+		Find(&roles).
+		Error; err != nil {
+		return nil, 0, err
+	}
+
+	count := uint64(0)
+	if err := query.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	return roles, count, nil
+}
+
+func (r *gormRoleRepository) Create(role *models.UserRole) error {
+	return r.db.Model(role).Create(role).Error
+}
+
+func (r *gormRoleRepository) Update(role *models.UserRole) error {
+	return r.db.Model(role).UpdateColumns(role).Error
+}
+
+func (r *gormRoleRepository) Delete(role *models.UserRole) error {
+	return r.db.Delete(role).Error
+}
+
+func (r *gormRoleRepository) SetDefault(id uint32, isDefault bool) error {
+	if isDefault {
+		// the concurrent transactions wait for the lock of the current default role,
+		// the unique index of the flag rejects them when there is no role to lock
+		if err := forUpdate(r.db).
+			Select("id").
+			Where("is_default = ?", true).
+			Find(&[]models.UserRole{}).
+			Error; err != nil {
+			return err
+		}
+
+		if err := r.db.
+			Model(&models.UserRole{}).
+			Where("is_default = ? AND id <> ?", true, id).
+			UpdateColumn("is_default", false).
+			Error; err != nil {
+			return err
+		}
+	}
+
+	return r.db.
+		Model(&models.UserRole{}).
+		Where("id = ?", id).
+		UpdateColumn("is_default", isDefault).
+		Error
+}
+
+func (r *gormRoleRepository) Lock(id uint32) error {
+	return forUpdate(r.db).
+		Select("id").
+		Where("id = ?", id).
+		First(&models.UserRole{}).
+		Error
+}
+
+// forUpdate lock the selected rows, sqlite has no row lock
+// since its transactions lock the database
+func forUpdate(query *gorm.DB) *gorm.DB {
+	if query.Dialect().GetName() == "sqlite3" {
+		return query
+	}
+	return query.Set("gorm:query_option", "FOR UPDATE")
+}
+
+func applyListOptions(query *gorm.DB, options ListOptions) *gorm.DB {
+	if options.Limit > 0 {
+		query = query.Offset(options.Offset).Limit(options.Limit)
+	}
+	if options.Sort != "" {
+		order := options.Sort
+		if options.Desc {
+			order += " DESC"
+		}
+		query = query.Order(order)
+	}
+	return query
+}
+
+// attributeCondition of the attribute value, the JSON path of the attribute is a bound parameter.
+// The strings are compared as text, the numbers and the booleans as JSON values
+func attributeCondition(dialect, name string, value interface{}) (string, []interface{}, error) {
+	if err := checkAttribute(name, value); err != nil {
+		return "", nil, err
+	}
+	var encoded []byte
+	if _, ok := value.(string); !ok {
+		encoded, _ = json.Marshal(value)
+	}
+
+	path := "$." + name
+	switch dialect {
+	case "postgres":
+		if encoded == nil {
+			return "attributes->>? = ?", []interface{}{name, value}, nil
+		}
+		return "(attributes->?)::jsonb = ?::jsonb", []interface{}{name, string(encoded)}, nil
+	case "sqlite3":
+		// json_extract returns the JSON booleans as 1 and 0
+		if boolean, ok := value.(bool); ok {
+			value = 0
+			if boolean {
+				value = 1
+			}
+		}
+		return "json_extract(attributes, ?) = ?", []interface{}{path, value}, nil
+	default:
+		if encoded == nil {
+			return "JSON_UNQUOTE(JSON_EXTRACT(attributes, ?)) = ?", []interface{}{path, value}, nil
+		}
+		return "JSON_EXTRACT(attributes, ?) = CAST(? AS JSON)", []interface{}{path, string(encoded)}, nil
+	}
+}
@@ -0,0 +1,478 @@
+package repositories
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/models"
+)
+
+// memoryData is shared by the repositories of the memory store
+type memoryData struct {
+	mu         sync.RWMutex
+	users      map[uint64]models.User
+	roles      map[uint32]models.UserRole
+	lastUserID uint64
+	lastRoleID uint32
+}
+
+type memoryStore struct {
+	data *memoryData
+}
+
+type memoryUserRepository struct {
+	data *memoryData
+}
+
+type memoryRoleRepository struct {
+	data *memoryData
+}
+
+// NewMemoryStore keeps the records in memory, it is meant for the tests
+func NewMemoryStore() Store {
+	return &memoryStore{&memoryData{
+		users: map[uint64]models.User{},
+		roles: map[uint32]models.UserRole{},
+	}}
+}
+
+func (s *memoryStore) Users() UserRepository {
+	return &memoryUserRepository{s.data}
+}
+
+func (s *memoryStore) Roles() RoleRepository {
+	return &memoryRoleRepository{s.data}
+}
+
+// Atomic restore the records when fn fails
+func (s *memoryStore) Atomic(fn func(store Store) error) error {
+	s.data.mu.RLock()
+	users := make(map[uint64]models.User, len(s.data.users))
+	for id, user := range s.data.users {
+		users[id] = user
+	}
+	roles := make(map[uint32]models.UserRole, len(s.data.roles))
+	for id, role := range s.data.roles {
+		roles[id] = role
+	}
+	s.data.mu.RUnlock()
+
+	if err := fn(s); err != nil {
+		s.data.mu.Lock()
+		s.data.users = users
+		s.data.roles = roles
+		s.data.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) Get(id uint64) (*models.User, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	user, ok := r.data.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *memoryUserRepository) GetWithRole(id uint64) (*models.User, error) {
+	user, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	role, ok := r.data.roles[user.RoleID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user.Role = &role
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetByUsername(username string) (*models.User, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	for _, user := range r.data.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUserRepository) Exists(field, value string) (bool, error) {
+	if _, ok := userLookupFields[field]; !ok {
+		return false, ErrInvalidField
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	for _, user := range r.data.users {
+		if (field == "email" && user.Email == value) ||
+			(field == "username" && user.Username == value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserRepository) List(filter UserFilter, options ListOptions) ([]models.User, uint64, error) {
+	for name, value := range filter.Attributes {
+		if err := checkAttribute(name, value); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	users := []models.User{}
+	for _, user := range r.data.users {
+		if matchAttributes(user.Attributes, filter.Attributes) {
+			users = append(users, user)
+		}
+	}
+
+	count := uint64(len(users))
+	sort.Slice(users, lessBy(options, func(i int) interface{} {
+		return userSortValue(&users[i], options.Sort)
+	}))
+	start, end := pageRange(len(users), options)
+	return users[start:end], count, nil
+}
+
+func (r *memoryUserRepository) Create(user *models.User) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	for _, existing := range r.data.users {
+		if existing.Email == user.Email || existing.Username == user.Username {
+			return db.ErrUniqueViolation
+		}
+	}
+	if _, ok := r.data.roles[user.RoleID]; !ok {
+		return db.ErrForeignKeyViolation
+	}
+
+	r.data.lastUserID++
+	id := r.data.lastUserID
+	user.ID = &id
+	stored := *user
+	stored.Role = nil
+	r.data.users[id] = stored
+	return nil
+}
+
+func (r *memoryUserRepository) Update(user *models.User) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	if user.ID == nil {
+		return ErrNotFound
+	}
+	stored, ok := r.data.users[*user.ID]
+	if !ok {
+		// gorm doesn't report the missing row of the update
+		return nil
+	}
+	if user.Email != "" {
+		stored.Email = user.Email
+	}
+	if user.Username != "" {
+		stored.Username = user.Username
+	}
+	if user.Password != "" {
+		stored.Password = user.Password
+	}
+	if user.Name != "" {
+		stored.Name = user.Name
+	}
+	if user.RoleID != 0 {
+		if _, ok := r.data.roles[user.RoleID]; !ok {
+			return db.ErrForeignKeyViolation
+		}
+		stored.RoleID = user.RoleID
+	}
+	if user.Enabled {
+		stored.Enabled = true
+	}
+	if user.Verified {
+		stored.Verified = true
+	}
+	if user.Attributes != nil {
+		stored.Attributes = user.Attributes
+	}
+	r.data.users[*user.ID] = stored
+	return nil
+}
+
+func (r *memoryUserRepository) SetEnabled(id uint64, enabled bool) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	if user, ok := r.data.users[id]; ok {
+		user.Enabled = enabled
+		r.data.users[id] = user
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) Delete(id uint64) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	delete(r.data.users, id)
+	return nil
+}
+
+func (r *memoryUserRepository) CountByRole(roleID uint32) (uint64, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	count := uint64(0)
+	for _, user := range r.data.users {
+		if user.RoleID == roleID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryUserRepository) ReassignRole(fromID, toID uint32) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	if _, ok := r.data.roles[toID]; !ok {
+		return db.ErrForeignKeyViolation
+	}
+	for id, user := range r.data.users {
+		if user.RoleID == fromID {
+			user.RoleID = toID
+			r.data.users[id] = user
+		}
+	}
+	return nil
+}
+
+func (r *memoryRoleRepository) Get(id uint32) (*models.UserRole, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	role, ok := r.data.roles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &role, nil
+}
+
+func (r *memoryRoleRepository) GetByName(name string) (*models.UserRole, error) {
+	return r.find(func(role *models.UserRole) bool {
+		return role.Name == name
+	})
+}
+
+func (r *memoryRoleRepository) GetDefault() (*models.UserRole, error) {
+	return r.find(func(role *models.UserRole) bool {
+		return role.IsDefault
+	})
+}
+
+func (r *memoryRoleRepository) GetByUser(userID uint64) (*models.UserRole, error) {
+	r.data.mu.RLock()
+	user, ok := r.data.users[userID]
+	r.data.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.Get(user.RoleID)
+}
+
+func (r *memoryRoleRepository) List(filter RoleFilter, options ListOptions) ([]models.UserRole, uint64, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	roles := []models.UserRole{}
+	for _, role := range r.data.roles {
+		if filter.Search != "" && !strings.Contains(strings.ToLower(role.Name), strings.ToLower(filter.Search)) {
+			continue
+		}
+		if filter.Enabled != nil && role.Enabled != *filter.Enabled {
+			continue
+		}
+		roles = append(roles, role)
+	}
+
+	count := uint64(len(roles))
+	sort.Slice(roles, lessBy(options, func(i int) interface{} {
+		return roleSortValue(&roles[i], options.Sort)
+	}))
+	start, end := pageRange(len(roles), options)
+	return roles[start:end], count, nil
+}
+
+func (r *memoryRoleRepository) Create(role *models.UserRole) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	for _, existing := range r.data.roles {
+		if existing.Name == role.Name {
+			return db.ErrUniqueViolation
+		}
+	}
+
+	r.data.lastRoleID++
+	role.ID = r.data.lastRoleID
+	r.data.roles[role.ID] = *role
+	return nil
+}
+
+func (r *memoryRoleRepository) Update(role *models.UserRole) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	stored, ok := r.data.roles[role.ID]
+	if !ok {
+		return nil
+	}
+	if role.Name != "" {
+		stored.Name = role.Name
+	}
+	if role.Enabled {
+		stored.Enabled = true
+	}
+	if role.IsDefault {
+		stored.IsDefault = true
+	}
+	r.data.roles[role.ID] = stored
+	return nil
+}
+
+func (r *memoryRoleRepository) Delete(role *models.UserRole) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	for _, user := range r.data.users {
+		if user.RoleID == role.ID {
+			return db.ErrForeignKeyViolation
+		}
+	}
+	delete(r.data.roles, role.ID)
+	return nil
+}
+
+func (r *memoryRoleRepository) SetDefault(id uint32, isDefault bool) error {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	for roleID, role := range r.data.roles {
+		switch {
+		case roleID == id:
+			role.IsDefault = isDefault
+		case isDefault:
+			role.IsDefault = false
+		default:
+			continue
+		}
+		r.data.roles[roleID] = role
+	}
+	return nil
+}
+
+// Lock only check the role exists, the memory store has no concurrent transaction
+func (r *memoryRoleRepository) Lock(id uint32) error {
+	_, err := r.Get(id)
+	return err
+}
+
+func (r *memoryRoleRepository) find(match func(role *models.UserRole) bool) (*models.UserRole, error) {
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	// the lowest id wins, like the first row of the table
+	var found *models.UserRole
+	for _, role := range r.data.roles {
+		role := role
+		if match(&role) && (found == nil || role.ID < found.ID) {
+			found = &role
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// matchAttributes compare the attribute values with their type
+func matchAttributes(attributes models.Attributes, filter map[string]interface{}) bool {
+	for name, value := range filter {
+		attribute, ok := attributes[name]
+		if !ok || attribute != value {
+			return false
+		}
+	}
+	return true
+}
+
+func userSortValue(user *models.User, column string) interface{} {
+	switch column {
+	case "username":
+		return user.Username
+	case "email":
+		return user.Email
+	case "name":
+		return user.Name
+	default:
+		return *user.ID
+	}
+}
+
+func roleSortValue(role *models.UserRole, column string) interface{} {
+	switch column {
+	case "name":
+		return role.Name
+	default:
+		return uint64(role.ID)
+	}
+}
+
+// lessBy the sort column of the options, value returns the column of the record
+func lessBy(options ListOptions, value func(i int) interface{}) func(i, j int) bool {
+	return func(i, j int) bool {
+		if options.Desc {
+			i, j = j, i
+		}
+		switch a := value(i).(type) {
+		case string:
+			return a < value(j).(string)
+		case uint64:
+			return a < value(j).(uint64)
+		}
+		return false
+	}
+}
+
+// pageRange of the records by the offset and limit
+func pageRange(length int, options ListOptions) (int, int) {
+	if options.Limit <= 0 {
+		return 0, length
+	}
+	start := options.Offset
+	if start > length {
+		start = length
+	}
+	end := start + options.Limit
+	if end > length {
+		end = length
+	}
+	return start, end
+}
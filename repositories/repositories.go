@@ -0,0 +1,133 @@
+// Package repositories hold the data access of the users and roles,
+// the handlers depend on the interfaces so the storage can be replaced
+package repositories
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrNotFound returned when the record doesn't exist,
+// it is the gorm error so the existing handling keeps working
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// ErrInvalidField returned when the field can't be used to lookup
+var ErrInvalidField = errors.New("repositories: invalid field")
+
+// ErrInvalidAttribute returned when the attribute name of the filter is not valid
+// or its value is not a string, a number or a boolean
+var ErrInvalidAttribute = errors.New("repositories: invalid attribute filter")
+
+// ListOptions of the list methods
+type ListOptions struct {
+	Offset int
+	Limit  int
+	// Sort column, no ordering when empty
+	Sort string
+	Desc bool
+}
+
+// UserFilter of the users list
+type UserFilter struct {
+	// Attributes match the custom attribute values, the values are a string, a float64 or a bool
+	// by the type of the attribute definition and they are compared with that type
+	Attributes map[string]interface{}
+}
+
+// RoleFilter of the roles list
+type RoleFilter struct {
+	// Search the name containing the value
+	Search  string
+	Enabled *bool
+}
+
+// Store of the repositories sharing the same database session
+type Store interface {
+	Users() UserRepository
+	Roles() RoleRepository
+	// Atomic run fn in a nested unit of work,
+	// the changes made through the given store are discarded when fn fails
+	Atomic(fn func(store Store) error) error
+}
+
+// UserRepository access the users
+type UserRepository interface {
+	Get(id uint64) (*models.User, error)
+	// GetWithRole returns the user with the Role loaded
+	GetWithRole(id uint64) (*models.User, error)
+	GetByUsername(username string) (*models.User, error)
+	// Exists check the "email" or "username" field has the value
+	Exists(field, value string) (bool, error)
+	List(filter UserFilter, options ListOptions) ([]models.User, uint64, error)
+	Create(user *models.User) error
+	// Update the non-zero fields of the user
+	Update(user *models.User) error
+	// SetEnabled of the user, Update can't disable it
+	SetEnabled(id uint64, enabled bool) error
+	Delete(id uint64) error
+	CountByRole(roleID uint32) (uint64, error)
+	// ReassignRole move the users of the role into another role
+	ReassignRole(fromID, toID uint32) error
+}
+
+// RoleRepository access the user roles
+type RoleRepository interface {
+	Get(id uint32) (*models.UserRole, error)
+	GetByName(name string) (*models.UserRole, error)
+	// GetDefault returns the role flagged as default
+	GetDefault() (*models.UserRole, error)
+	// GetByUser returns the name of the user role
+	GetByUser(userID uint64) (*models.UserRole, error)
+	List(filter RoleFilter, options ListOptions) ([]models.UserRole, uint64, error)
+	Create(role *models.UserRole) error
+	// Update the non-zero fields of the role
+	Update(role *models.UserRole) error
+	Delete(role *models.UserRole) error
+	// SetDefault flag of the role, flagging it unset the flag of the other roles.
+	// The caller runs it in a transaction, the default role is locked until the end of it
+	SetDefault(id uint32, isDefault bool) error
+	// Lock the role until the end of the transaction the caller runs it in,
+	// the concurrent transactions locking it wait for that end
+	Lock(id uint32) error
+}
+
+// likeEscape is the escape character of the LIKE pattern,
+// backslash is not used since its meaning in string literals depends on the database
+const likeEscape = "!"
+
+// userLookupFields can be used in UserRepository.Exists
+var userLookupFields = map[string]struct{}{
+	"email":    {},
+	"username": {},
+}
+
+// escapeLike escape the wildcard characters of the LIKE pattern,
+// the condition must declare "ESCAPE '!'"
+func escapeLike(value string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(value)
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkAttribute of the filter, the name is used in the JSON path of the queries
+func checkAttribute(name string, value interface{}) error {
+	if !models.ValidUserAttributeName(name) {
+		return ErrInvalidAttribute
+	}
+	switch value.(type) {
+	case string, float64, bool:
+		return nil
+	}
+	return ErrInvalidAttribute
+}
@@ -0,0 +1,353 @@
+package repositories
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlekSi/pointer"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/frullah/gin-boilerplate/db"
+	"github.com/frullah/gin-boilerplate/migrate"
+	_ "github.com/frullah/gin-boilerplate/migrations"
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// every connection of the in-memory database is a new database
+	db.DB().SetMaxOpenConns(1)
+	db.SingularTable(true)
+	require.NoError(t, db.AutoMigrate(&models.UserRole{}, &models.User{}).Error)
+	return db
+}
+
+// TestStores run the same cases against every implementation,
+// so the memory store behaves like the database
+func TestStores(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	stores := map[string]Store{
+		"gorm":   NewGormStore(db),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testRoles(t, store)
+			testUsers(t, store)
+			testAtomic(t, store)
+		})
+	}
+}
+
+func testRoles(t *testing.T, store Store) {
+	roles := store.Roles()
+	for _, role := range []*models.UserRole{
+		{Name: "administrator", Enabled: true},
+		{Name: "member", Enabled: true, IsDefault: true},
+		{Name: "guest_user"},
+		{Name: "guests"},
+	} {
+		require.NoError(t, roles.Create(role))
+		assert.NotZero(t, role.ID)
+	}
+
+	role, err := roles.GetByName("member")
+	require.NoError(t, err)
+	assert.True(t, role.IsDefault)
+
+	role, err = roles.GetDefault()
+	require.NoError(t, err)
+	assert.Equal(t, "member", role.Name)
+
+	_, err = roles.Get(100)
+	assert.Equal(t, ErrNotFound, err)
+
+	// the wildcard of the search is escaped
+	list, count, err := roles.List(RoleFilter{Search: "_"}, ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, "guest_user", list[0].Name)
+
+	list, count, err = roles.List(
+		RoleFilter{Enabled: pointer.ToBool(true)},
+		ListOptions{Limit: 1, Sort: "name", Desc: true},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	require.Len(t, list, 1)
+	assert.Equal(t, "member", list[0].Name)
+
+	require.NoError(t, roles.Update(&models.UserRole{ID: role.ID, Name: "members"}))
+	role, err = roles.Get(role.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "members", role.Name)
+	assert.True(t, role.Enabled)
+
+	guests, err := roles.GetByName("guests")
+	require.NoError(t, err)
+	require.NoError(t, roles.SetDefault(guests.ID, true))
+	role, err = roles.GetDefault()
+	require.NoError(t, err)
+	assert.Equal(t, "guests", role.Name)
+	role, err = roles.GetByName("members")
+	require.NoError(t, err)
+	assert.False(t, role.IsDefault)
+
+	require.NoError(t, roles.SetDefault(guests.ID, false))
+	_, err = roles.GetDefault()
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, roles.Lock(guests.ID))
+	require.NoError(t, roles.Delete(guests))
+	_, err = roles.Get(guests.ID)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, roles.Lock(guests.ID))
+}
+
+func testUsers(t *testing.T, store Store) {
+	users := store.Users()
+	member, err := store.Roles().GetByName("members")
+	require.NoError(t, err)
+
+	for _, user := range []*models.User{
+		{Email: "a@example.com", Username: "alice", Name: "Alice", RoleID: member.ID, Enabled: true,
+			Attributes: models.Attributes{"locale": "id"}},
+		{Email: "b@example.com", Username: "bob", Name: "Bob", RoleID: member.ID,
+			Attributes: models.Attributes{"locale": "en"}},
+	} {
+		require.NoError(t, users.Create(user))
+		require.NotNil(t, user.ID)
+	}
+
+	user, err := users.GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", user.Email)
+
+	user, err = users.GetWithRole(*user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, user.Role)
+	assert.Equal(t, "members", user.Role.Name)
+
+	role, err := store.Roles().GetByUser(*user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "members", role.Name)
+
+	exists, err := users.Exists("username", "bob")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = users.Exists("email", "c@example.com")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, err = users.Exists("password", "x")
+	assert.Equal(t, ErrInvalidField, err)
+
+	list, count, err := users.List(UserFilter{}, ListOptions{Offset: 1, Limit: 1, Sort: "id"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	require.Len(t, list, 1)
+	assert.Equal(t, "bob", list[0].Username)
+
+	require.NoError(t, users.Update(&models.User{ID: user.ID, Name: "Alice Liddell"}))
+	user, err = users.Get(*user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice Liddell", user.Name)
+	assert.Equal(t, "alice", user.Username)
+
+	require.NoError(t, users.SetEnabled(*user.ID, false))
+	user, err = users.Get(*user.ID)
+	require.NoError(t, err)
+	assert.False(t, user.Enabled)
+
+	administrator, err := store.Roles().GetByName("administrator")
+	require.NoError(t, err)
+	require.NoError(t, users.ReassignRole(member.ID, administrator.ID))
+	count, err = users.CountByRole(administrator.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	require.NoError(t, users.Delete(*user.ID))
+	_, err = users.Get(*user.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func testAtomic(t *testing.T, store Store) {
+	errAtomic := errors.New("atomic")
+	err := store.Atomic(func(store Store) error {
+		if err := store.Roles().Create(&models.UserRole{Name: "discarded"}); err != nil {
+			return err
+		}
+		return errAtomic
+	})
+	assert.Equal(t, errAtomic, err)
+	_, err = store.Roles().GetByName("discarded")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, store.Atomic(func(store Store) error {
+		return store.Roles().Create(&models.UserRole{Name: "kept"})
+	}))
+	_, err = store.Roles().GetByName("kept")
+	assert.NoError(t, err)
+}
+
+// TestMemoryStoreAttributes covers the attribute filter of the memory store,
+// the JSON functions of SQLite need the sqlite_json1 build tag,
+// so the query of the gorm store is checked by TestGormStoreAttributes
+func TestMemoryStoreAttributes(t *testing.T) {
+	store := NewMemoryStore()
+	role := &models.UserRole{Name: "member"}
+	require.NoError(t, store.Roles().Create(role))
+	for _, user := range []*models.User{
+		{Email: "a@example.com", Username: "alice", RoleID: role.ID,
+			Attributes: models.Attributes{"locale": "id", "age": float64(20), "verified": true}},
+		{Email: "b@example.com", Username: "bob", RoleID: role.ID,
+			Attributes: models.Attributes{"locale": "en", "age": float64(30), "verified": false}},
+		{Email: "c@example.com", Username: "carol", RoleID: role.ID},
+	} {
+		require.NoError(t, store.Users().Create(user))
+	}
+
+	cases := []struct {
+		name     string
+		filter   map[string]interface{}
+		expected []string
+	}{
+		{"no filter", nil, []string{"alice", "bob", "carol"}},
+		{"string", map[string]interface{}{"locale": "en"}, []string{"bob"}},
+		{"number", map[string]interface{}{"age": float64(20)}, []string{"alice"}},
+		{"boolean", map[string]interface{}{"verified": false}, []string{"bob"}},
+		{"number is not a string", map[string]interface{}{"age": "20"}, []string{}},
+		{"missing", map[string]interface{}{"locale": "fr"}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			list, count, err := store.Users().List(
+				UserFilter{Attributes: c.filter},
+				ListOptions{Sort: "username"},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(len(c.expected)), count)
+			usernames := []string{}
+			for _, user := range list {
+				usernames = append(usernames, user.Username)
+			}
+			assert.Equal(t, c.expected, usernames)
+		})
+	}
+
+	for name, filter := range map[string]map[string]interface{}{
+		"invalid name":  {"locale')": "en"},
+		"invalid value": {"age": []string{"20"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := store.Users().List(UserFilter{Attributes: filter}, ListOptions{})
+			assert.Equal(t, ErrInvalidAttribute, err)
+		})
+	}
+}
+
+// TestGormStoreAttributes checks the JSON path and the value of the filter are bound
+// as the arguments of the query, the value is compared by its type
+func TestGormStoreAttributes(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter map[string]interface{}
+		args   []driver.Value
+	}{
+		{"string", map[string]interface{}{"locale": "en"}, []driver.Value{"$.locale", "en"}},
+		{"number", map[string]interface{}{"age": float64(20)}, []driver.Value{"$.age", float64(20)}},
+		{"boolean", map[string]interface{}{"verified": true}, []driver.Value{"$.verified", int64(1)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			g, err := gorm.Open("sqlite3", mockDB)
+			require.NoError(t, err)
+			defer g.Close()
+			g.SingularTable(true)
+
+			mock.ExpectQuery(`SELECT .+ FROM .user. WHERE \(json_extract\(attributes, \?\) = \?\)`).
+				WithArgs(c.args...).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "alice"))
+			mock.ExpectQuery(`SELECT count.+ FROM .user. WHERE \(json_extract\(attributes, \?\) = \?\)`).
+				WithArgs(c.args...).
+				WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+
+			list, count, err := NewGormStore(g).Users().List(UserFilter{Attributes: c.filter}, ListOptions{})
+			require.NoError(t, err)
+			assert.Len(t, list, 1)
+			assert.Equal(t, uint64(1), count)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("invalid name", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		g, err := gorm.Open("sqlite3", mockDB)
+		require.NoError(t, err)
+		defer g.Close()
+
+		_, _, err = NewGormStore(g).Users().List(
+			UserFilter{Attributes: map[string]interface{}{"locale') = 'en' OR ('": "en"}},
+			ListOptions{},
+		)
+		assert.Equal(t, ErrInvalidAttribute, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestSetDefaultConcurrent flag the roles from concurrent transactions,
+// the schema of the migrations keeps one default role
+func TestSetDefaultConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repositories")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	g, err := gorm.Open("sqlite3", filepath.Join(dir, "roles.db")+"?_busy_timeout=5000&_txlock=immediate")
+	require.NoError(t, err)
+	defer g.Close()
+	g.SingularTable(true)
+	migrator, err := migrate.New(g, migrate.Registered(db.Default))
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	const count = 8
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			errs <- db.Transaction(g, func(tx *db.Tx) error {
+				roles := NewTxStore(tx).Roles()
+				role := &models.UserRole{Name: fmt.Sprintf("role-%d", i), Enabled: true}
+				if err := roles.Create(role); err != nil {
+					return err
+				}
+				return roles.SetDefault(role.ID, true)
+			})
+		}(i)
+	}
+	for i := 0; i < count; i++ {
+		require.NoError(t, <-errs)
+	}
+
+	defaults := 0
+	require.NoError(t, g.Model(&models.UserRole{}).Where("is_default = ?", true).Count(&defaults).Error)
+	assert.Equal(t, 1, defaults)
+
+	// the unique index rejects the default role which is not flagged by SetDefault
+	err = g.Create(&models.UserRole{Name: "unlocked", IsDefault: true}).Error
+	assert.Equal(t, db.ErrUniqueViolation, db.Classify(err))
+}
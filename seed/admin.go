@@ -0,0 +1,110 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+)
+
+// AdministratorRole name, the administrator routes require it
+const AdministratorRole = "administrator"
+
+// ErrAdminExists returned when an administrator exists and the creation isn't forced
+var ErrAdminExists = errors.New("seed: an administrator already exists")
+
+// Admin to create
+type Admin struct {
+	Email    string
+	Username string
+	Password string
+	Name     string
+}
+
+// Validate the admin like the user routes validate the users
+func (a *Admin) Validate() error {
+	if _, err := mail.ParseAddress(a.Email); err != nil {
+		return fmt.Errorf("seed: invalid email %q", a.Email)
+	}
+	if len(a.Username) < 5 || len(a.Username) > 64 {
+		return errors.New("seed: the username must have 5 to 64 characters")
+	}
+	if len(a.Password) < 5 || len(a.Password) > 64 {
+		return errors.New("seed: the password must have 5 to 64 characters")
+	}
+	if len(a.Name) > 64 {
+		return errors.New("seed: the name must have at most 64 characters")
+	}
+	return nil
+}
+
+// HasAdmin is true when a user has the administrator role
+func HasAdmin(store repositories.Store) (bool, error) {
+	role, err := store.Roles().GetByName(AdministratorRole)
+	if err == repositories.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	count, err := store.Users().CountByRole(role.ID)
+	return count > 0, err
+}
+
+// CreateAdmin create the administrator role when it is missing and the admin user.
+// ErrAdminExists is returned when an administrator exists, unless force is true
+func CreateAdmin(store repositories.Store, admin Admin, force bool) (*models.User, error) {
+	if err := admin.Validate(); err != nil {
+		return nil, err
+	}
+
+	user := &models.User{}
+	err := store.Atomic(func(store repositories.Store) error {
+		roles := store.Roles()
+		role, err := roles.GetByName(AdministratorRole)
+		if err == repositories.ErrNotFound {
+			role = &models.UserRole{Name: AdministratorRole, Enabled: true}
+			err = roles.Create(role)
+		} else if err == nil && !role.Enabled {
+			err = roles.Update(&models.UserRole{ID: role.ID, Enabled: true})
+		}
+		if err != nil {
+			return err
+		}
+
+		if !force {
+			// the concurrent creations wait for the lock, so the second one counts the first administrator
+			if err := roles.Lock(role.ID); err != nil {
+				return err
+			}
+			count, err := store.Users().CountByRole(role.ID)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrAdminExists
+			}
+		}
+
+		password, err := HashPassword(admin.Password)
+		if err != nil {
+			return err
+		}
+		*user = models.User{
+			Email:    admin.Email,
+			Username: admin.Username,
+			Password: password,
+			Name:     admin.Name,
+			RoleID:   role.ID,
+			Enabled:  true,
+			Verified: true,
+		}
+		return store.Users().Create(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,185 @@
+// Package seed load the declarative fixtures and upsert them into the store,
+// the same fixtures build the known states of the tests
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Dir of the fixtures, the fixtures of an environment are placed in "<Dir>/<environment>"
+const Dir = "fixtures"
+
+// Fixtures of the roles and the users
+type Fixtures struct {
+	Roles []Role `json:"roles" yaml:"roles"`
+	Users []User `json:"users" yaml:"users"`
+}
+
+// Role fixture, looked up by the name
+type Role struct {
+	Name    string `json:"name" yaml:"name"`
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Default bool   `json:"default" yaml:"default"`
+}
+
+// User fixture, looked up by the username
+type User struct {
+	Email    string `json:"email" yaml:"email"`
+	Username string `json:"username" yaml:"username"`
+	// Password in plain text, it is hashed before it is stored,
+	// it is refused in production so it must not be committed
+	Password string `json:"password" yaml:"password"`
+	// PasswordEnv name of the environment variable of the password, used when Password is empty
+	PasswordEnv string `json:"passwordEnv" yaml:"passwordEnv"`
+	// PasswordHash bcrypt hash of the password, used when Password and PasswordEnv are empty
+	PasswordHash string                 `json:"passwordHash" yaml:"passwordHash"`
+	Name         string                 `json:"name" yaml:"name"`
+	Role         string                 `json:"role" yaml:"role"`
+	Enabled      bool                   `json:"enabled" yaml:"enabled"`
+	Verified     bool                   `json:"verified" yaml:"verified"`
+	Attributes   map[string]interface{} `json:"attributes" yaml:"attributes"`
+}
+
+// Load the fixtures of the directory then the fixtures of the environment,
+// the records of the environment replace the records with the same name
+func Load(fileSystem afero.Fs, dir, env string) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	dirs := []string{dir}
+	if env != "" {
+		dirs = append(dirs, path.Join(dir, env))
+	}
+
+	found := false
+	for _, fixturesDir := range dirs {
+		files, err := afero.ReadDir(fileSystem, fixturesDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+		for _, file := range files {
+			if !file.IsDir() && isFixtureFile(file.Name()) {
+				names = append(names, file.Name())
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			data, err := afero.ReadFile(fileSystem, path.Join(fixturesDir, name))
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := Parse(name, data)
+			if err != nil {
+				return nil, err
+			}
+			fixtures.Merge(parsed)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("seed: no fixture in %q", dir)
+	}
+	return fixtures, nil
+}
+
+// Parse the fixtures of the file, the format is picked by the extension
+func Parse(name string, data []byte) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	var err error
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		err = json.Unmarshal(data, fixtures)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, fixtures)
+	default:
+		err = fmt.Errorf("unknown format %q", path.Ext(name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("seed: %s: %v", name, err)
+	}
+
+	for i := range fixtures.Users {
+		// the attributes are stored as JSON, so they are compared as JSON
+		if fixtures.Users[i].Attributes, err = normalizeAttributes(fixtures.Users[i].Attributes); err != nil {
+			return nil, fmt.Errorf("seed: %s: %s: %v", name, fixtures.Users[i].Username, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// Merge the other fixtures, its records replace the records with the same name
+func (f *Fixtures) Merge(other *Fixtures) {
+	for _, role := range other.Roles {
+		replaced := false
+		for i := range f.Roles {
+			if f.Roles[i].Name == role.Name {
+				f.Roles[i], replaced = role, true
+			}
+		}
+		if !replaced {
+			f.Roles = append(f.Roles, role)
+		}
+	}
+
+	for _, user := range other.Users {
+		replaced := false
+		for i := range f.Users {
+			if f.Users[i].Username == user.Username {
+				f.Users[i], replaced = user, true
+			}
+		}
+		if !replaced {
+			f.Users = append(f.Users, user)
+		}
+	}
+}
+
+// CheckProduction refuse the fixtures with a password in plain text,
+// the production passwords are given by PasswordEnv or PasswordHash
+func (f *Fixtures) CheckProduction() error {
+	for _, user := range f.Users {
+		if user.Password != "" {
+			return fmt.Errorf("seed: %s: the password in plain text is refused in production, use passwordEnv or passwordHash", user.Username)
+		}
+	}
+	return nil
+}
+
+func isFixtureFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func normalizeAttributes(attributes map[string]interface{}) (map[string]interface{}, error) {
+	if attributes == nil {
+		return nil, nil
+	}
+	for name, value := range attributes {
+		if _, ok := value.(map[interface{}]interface{}); ok {
+			return nil, fmt.Errorf("attribute %q must be a string, number or boolean", name)
+		}
+	}
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	normalized := map[string]interface{}{}
+	err = json.Unmarshal(encoded, &normalized)
+	return normalized, err
+}
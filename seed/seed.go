@@ -0,0 +1,181 @@
+package seed
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptCost of the hashed passwords, the tests lower it
+var BcryptCost = 12
+
+// Result counts of the seeded records
+type Result struct {
+	Created   int
+	Updated   int
+	Unchanged int
+}
+
+// Apply the fixtures to the store in one unit, the roles before the users.
+// The existing records are updated like the repositories update them,
+// so the fields are set but a seeded record is never disabled.
+// Applying the same fixtures again leaves the records unchanged
+func Apply(store repositories.Store, fixtures *Fixtures) (Result, error) {
+	result := Result{}
+	err := store.Atomic(func(store repositories.Store) error {
+		result = Result{}
+		for i := range fixtures.Roles {
+			if err := applyRole(store.Roles(), &fixtures.Roles[i], &result); err != nil {
+				return fmt.Errorf("seed: role %s: %v", fixtures.Roles[i].Name, err)
+			}
+		}
+		for i := range fixtures.Users {
+			if err := applyUser(store, &fixtures.Users[i], &result); err != nil {
+				return fmt.Errorf("seed: user %s: %v", fixtures.Users[i].Username, err)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func applyRole(roles repositories.RoleRepository, fixture *Role, result *Result) error {
+	role, err := roles.GetByName(fixture.Name)
+	switch {
+	case err == repositories.ErrNotFound:
+		role = &models.UserRole{Name: fixture.Name, Enabled: fixture.Enabled}
+		if err := roles.Create(role); err != nil {
+			return err
+		}
+		result.Created++
+	case err != nil:
+		return err
+	default:
+		enable := fixture.Enabled && !role.Enabled
+		if !enable && (!fixture.Default || role.IsDefault) {
+			result.Unchanged++
+			return nil
+		}
+		if enable {
+			if err := roles.Update(&models.UserRole{ID: role.ID, Enabled: true}); err != nil {
+				return err
+			}
+		}
+		result.Updated++
+	}
+
+	if fixture.Default {
+		return roles.SetDefault(role.ID, true)
+	}
+	return nil
+}
+
+func applyUser(store repositories.Store, fixture *User, result *Result) error {
+	role, err := store.Roles().GetByName(fixture.Role)
+	if err == repositories.ErrNotFound {
+		return fmt.Errorf("unknown role %q", fixture.Role)
+	}
+	if err != nil {
+		return err
+	}
+
+	users := store.Users()
+	user, err := users.GetByUsername(fixture.Username)
+	if err == repositories.ErrNotFound {
+		password, err := hashPassword(fixture, "")
+		if err != nil {
+			return err
+		}
+		user = &models.User{
+			Email:      fixture.Email,
+			Username:   fixture.Username,
+			Password:   password,
+			Name:       fixture.Name,
+			RoleID:     role.ID,
+			Enabled:    fixture.Enabled,
+			Verified:   fixture.Verified,
+			Attributes: fixture.Attributes,
+		}
+		if err := users.Create(user); err != nil {
+			return err
+		}
+		result.Created++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	changes := &models.User{ID: user.ID}
+	changed := false
+	if fixture.Email != "" && fixture.Email != user.Email {
+		changes.Email, changed = fixture.Email, true
+	}
+	if fixture.Name != "" && fixture.Name != user.Name {
+		changes.Name, changed = fixture.Name, true
+	}
+	if role.ID != user.RoleID {
+		changes.RoleID, changed = role.ID, true
+	}
+	if fixture.Enabled && !user.Enabled {
+		changes.Enabled, changed = true, true
+	}
+	if fixture.Verified && !user.Verified {
+		changes.Verified, changed = true, true
+	}
+	if fixture.Attributes != nil && !reflect.DeepEqual(map[string]interface{}(user.Attributes), fixture.Attributes) {
+		changes.Attributes, changed = fixture.Attributes, true
+	}
+	password, err := hashPassword(fixture, user.Password)
+	if err != nil {
+		return err
+	}
+	if password != user.Password {
+		changes.Password, changed = password, true
+	}
+
+	if !changed {
+		result.Unchanged++
+		return nil
+	}
+	if err := users.Update(changes); err != nil {
+		return err
+	}
+	result.Updated++
+	return nil
+}
+
+// hashPassword of the fixture, the current hash is kept when it matches the password
+func hashPassword(fixture *User, current string) (string, error) {
+	password := fixture.Password
+	if password == "" && fixture.PasswordEnv != "" {
+		if password = os.Getenv(fixture.PasswordEnv); password == "" {
+			return "", fmt.Errorf("missing password, %s is not set", fixture.PasswordEnv)
+		}
+	}
+
+	if password == "" {
+		if fixture.PasswordHash == "" {
+			return current, nil
+		}
+		if _, err := bcrypt.Cost([]byte(fixture.PasswordHash)); err != nil {
+			return "", fmt.Errorf("invalid password hash: %v", err)
+		}
+		return fixture.PasswordHash, nil
+	}
+
+	if current != "" && bcrypt.CompareHashAndPassword([]byte(current), []byte(password)) == nil {
+		return current, nil
+	}
+	return HashPassword(password)
+}
+
+// HashPassword with bcrypt, like the login compares it
+func HashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	return string(hashed), err
+}
@@ -0,0 +1,216 @@
+package seed
+
+import (
+	"os"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/models"
+	"github.com/frullah/gin-boilerplate/repositories"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	BcryptCost = bcrypt.MinCost
+}
+
+const (
+	rolesYAML = `
+roles:
+  - name: administrator
+    enabled: true
+  - name: member
+    enabled: true
+    default: true
+`
+	usersJSON = `{
+  "users": [
+    {"email": "admin@example.com", "username": "admin", "password": "secret",
+     "name": "Admin", "role": "administrator", "enabled": true, "verified": true}
+  ]
+}`
+	developmentYAML = `
+users:
+  - email: admin@example.com
+    username: admin
+    password: development
+    name: Developer
+    role: administrator
+    enabled: true
+  - email: member@example.com
+    username: member
+    role: member
+    attributes:
+      locale: id
+      age: 20
+`
+)
+
+func testFS(t *testing.T) afero.Fs {
+	t.Helper()
+	fileSystem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/roles.yaml", []byte(rolesYAML), 0644))
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/users.json", []byte(usersJSON), 0644))
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/README.md", []byte("ignored"), 0644))
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/development/users.yml", []byte(developmentYAML), 0644))
+	return fileSystem
+}
+
+func TestLoad(t *testing.T) {
+	fileSystem := testFS(t)
+
+	fixtures, err := Load(fileSystem, Dir, "production")
+	require.NoError(t, err)
+	assert.Len(t, fixtures.Roles, 2)
+	require.Len(t, fixtures.Users, 1)
+	assert.Equal(t, "secret", fixtures.Users[0].Password)
+
+	// the environment replace the admin and add the member
+	fixtures, err = Load(fileSystem, Dir, "development")
+	require.NoError(t, err)
+	require.Len(t, fixtures.Users, 2)
+	assert.Equal(t, "development", fixtures.Users[0].Password)
+	assert.Equal(t, "Developer", fixtures.Users[0].Name)
+	assert.Equal(t, map[string]interface{}{"locale": "id", "age": float64(20)}, fixtures.Users[1].Attributes)
+
+	_, err = Load(fileSystem, "missing", "development")
+	assert.Error(t, err)
+
+	require.NoError(t, afero.WriteFile(fileSystem, "fixtures/broken/roles.yaml", []byte("roles:\n  - nme: typo\n"), 0644))
+	_, err = Load(fileSystem, Dir, "broken")
+	assert.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	_, err := Parse("roles.toml", []byte(""))
+	assert.Error(t, err)
+
+	_, err = Parse("users.yaml", []byte("users:\n  - username: a\n    attributes:\n      nested:\n        a: 1\n"))
+	assert.Error(t, err)
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// every connection of the in-memory database is a new database
+	db.DB().SetMaxOpenConns(1)
+	db.SingularTable(true)
+	require.NoError(t, db.AutoMigrate(&models.UserRole{}, &models.User{}).Error)
+	return db
+}
+
+func TestApply(t *testing.T) {
+	fixtures, err := Load(testFS(t), Dir, "development")
+	require.NoError(t, err)
+
+	gormDB := openTestDB(t)
+	defer gormDB.Close()
+	stores := map[string]repositories.Store{
+		"gorm":   repositories.NewGormStore(gormDB),
+		"memory": repositories.NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.Roles().Create(&models.UserRole{Name: "user", IsDefault: true}))
+
+			result, err := Apply(store, fixtures)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Created: 4}, result)
+
+			// idempotent
+			result, err = Apply(store, fixtures)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Unchanged: 4}, result)
+
+			role, err := store.Roles().GetDefault()
+			require.NoError(t, err)
+			assert.Equal(t, "member", role.Name)
+
+			admin, err := store.Users().GetByUsername("admin")
+			require.NoError(t, err)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("development")))
+			assert.True(t, admin.Enabled)
+
+			changed := *fixtures
+			changed.Users = []User{fixtures.Users[0]}
+			changed.Users[0].Password = "changed"
+			changed.Users[0].Role = "member"
+			result, err = Apply(store, &changed)
+			require.NoError(t, err)
+			assert.Equal(t, Result{Updated: 1, Unchanged: 2}, result)
+			admin, err = store.Users().GetByUsername("admin")
+			require.NoError(t, err)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("changed")))
+			assert.Equal(t, role.ID, admin.RoleID)
+
+			// nothing is applied when a record fails
+			_, err = Apply(store, &Fixtures{
+				Roles: []Role{{Name: "discarded"}},
+				Users: []User{{Email: "x@example.com", Username: "x", Role: "missing"}},
+			})
+			assert.Error(t, err)
+			_, err = store.Roles().GetByName("discarded")
+			assert.Equal(t, repositories.ErrNotFound, err)
+		})
+	}
+}
+
+func TestPasswordEnv(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	require.NoError(t, store.Roles().Create(&models.UserRole{Name: "member", Enabled: true}))
+	fixtures := &Fixtures{Users: []User{
+		{Email: "admin@example.com", Username: "admin", PasswordEnv: "SEED_TEST_PASSWORD", Role: "member"},
+	}}
+	assert.NoError(t, fixtures.CheckProduction())
+
+	os.Unsetenv("SEED_TEST_PASSWORD")
+	_, err := Apply(store, fixtures)
+	assert.Error(t, err)
+
+	os.Setenv("SEED_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("SEED_TEST_PASSWORD")
+	_, err = Apply(store, fixtures)
+	require.NoError(t, err)
+	admin, err := store.Users().GetByUsername("admin")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("from-env")))
+
+	fixtures.Users[0].Password = "plain"
+	assert.Error(t, fixtures.CheckProduction())
+}
+
+func TestCreateAdmin(t *testing.T) {
+	store := repositories.NewMemoryStore()
+	admin := Admin{Email: "admin@example.com", Username: "admin", Password: "secret"}
+
+	_, err := CreateAdmin(store, Admin{Email: "invalid", Username: "admin", Password: "secret"}, false)
+	assert.Error(t, err)
+
+	exists, err := HasAdmin(store)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	user, err := CreateAdmin(store, admin, false)
+	require.NoError(t, err)
+	assert.True(t, user.Enabled)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("secret")))
+	role, err := store.Roles().GetByName(AdministratorRole)
+	require.NoError(t, err)
+	assert.True(t, role.Enabled)
+	assert.Equal(t, role.ID, user.RoleID)
+
+	exists, err = HasAdmin(store)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	second := Admin{Email: "root@example.com", Username: "superuser", Password: "secret"}
+	_, err = CreateAdmin(store, second, false)
+	assert.Equal(t, ErrAdminExists, err)
+	_, err = CreateAdmin(store, second, true)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// LocalConfig of the local storage
+type LocalConfig struct {
+	// Dir where the files stored, relative to the file system root
+	Dir string
+	// Secret used to sign presigned URLs
+	Secret []byte
+	// BaseURL where the Local handler is mounted
+	BaseURL string
+}
+
+// Local storage on top of the afero file system
+type Local struct {
+	fs     afero.Fs
+	config LocalConfig
+}
+
+const (
+	defaultLocalDir     = "uploads"
+	defaultLocalBaseURL = "/files"
+	localDirPermission  = 0750
+	localFilePermission = 0640
+)
+
+// NewLocal storage
+func NewLocal(fs afero.Fs, config LocalConfig) *Local {
+	if config.Dir == "" {
+		config.Dir = defaultLocalDir
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultLocalBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &Local{fs, config}
+}
+
+// BaseURL where the Local handler should be mounted
+func (l *Local) BaseURL() string {
+	return l.config.BaseURL
+}
+
+// Put content into a file
+func (l *Local) Put(ctx context.Context, key string, content io.Reader, options PutOptions) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := l.fs.MkdirAll(path.Dir(filePath), localDirPermission); err != nil {
+		return err
+	}
+
+	// write to a temporary file of the same directory first, so readers never see
+	// a partial file and the concurrent writers of the key don't share it
+	file, err := afero.TempFile(l.fs, path.Dir(filePath), "."+path.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		l.fs.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		l.fs.Remove(tempPath)
+		return err
+	}
+	if err := l.fs.Chmod(tempPath, localFilePermission); err != nil {
+		l.fs.Remove(tempPath)
+		return err
+	}
+
+	if err := l.fs.Rename(tempPath, filePath); err != nil {
+		l.fs.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// Get content from a file
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := l.fs.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, &Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  contentTypeByKey(key),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// Delete a file
+func (l *Local) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := l.fs.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Presign make a URL to the Local handler which valid until expired
+func (l *Local) Presign(ctx context.Context, method, key string, expires time.Duration) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   []string{expiresAt},
+		"signature": []string{l.sign(method, cleaned, expiresAt)},
+	}
+
+	return l.config.BaseURL + "/" + cleaned + "?" + query.Encode(), nil
+}
+
+// ServeHTTP serve files from presigned GET URLs,
+// the request path must be relative to the BaseURL
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := cleanKey(r.URL.Path)
+	if err != nil || !l.verify(r.Method, key, r.URL.Query()) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	reader, object, err := l.Get(r.Context(), key)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), object.LastModified, seeker)
+		return
+	}
+	io.Copy(w, reader)
+}
+
+func (l *Local) path(key string) (string, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(l.config.Dir, cleaned), nil
+}
+
+func (l *Local) sign(method, key, expiresAt string) string {
+	mac := hmac.New(sha256.New, l.config.Secret)
+	mac.Write([]byte(method + "\n" + key + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) verify(method, key string, query url.Values) bool {
+	expiresAt := query.Get("expires")
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	// HEAD request is allowed by GET signature
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	expected := l.sign(method, key, expiresAt)
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+func contentTypeByKey(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		return contentType
+	}
+
+	return defaultContentType
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocal(afero.NewMemMapFs(), LocalConfig{Secret: []byte("secret")})
+
+	t.Run("get missing key", func(t *testing.T) {
+		_, _, err := local.Get(ctx, "missing.txt")
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("reject escaping key", func(t *testing.T) {
+		err := local.Put(ctx, "../outside.txt", bytes.NewBufferString("x"), PutOptions{})
+		assert.Equal(t, ErrInvalidKey, err)
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		require.NoError(t, local.Put(ctx, "dir/file.txt", bytes.NewBufferString("content"), PutOptions{}))
+
+		reader, object, err := local.Get(ctx, "dir/file.txt")
+		require.NoError(t, err)
+		defer reader.Close()
+
+		content, _ := ioutil.ReadAll(reader)
+		assert.Equal(t, "content", string(content))
+		assert.Equal(t, int64(len("content")), object.Size)
+		assert.True(t, strings.HasPrefix(object.ContentType, "text/plain"))
+	})
+
+	t.Run("replace without temporary file", func(t *testing.T) {
+		require.NoError(t, local.Put(ctx, "dir/file.txt", bytes.NewBufferString("replaced"), PutOptions{}))
+
+		files, err := afero.ReadDir(local.fs, "uploads/dir")
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "file.txt", files[0].Name())
+		assert.Equal(t, os.FileMode(localFilePermission), files[0].Mode().Perm())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, local.Delete(ctx, "dir/file.txt"))
+		require.NoError(t, local.Delete(ctx, "dir/file.txt"))
+
+		_, _, err := local.Get(ctx, "dir/file.txt")
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(afero.NewMemMapFs(), config.Storage{Type: TypeLocal})
+	assert.Equal(t, ErrMissingSecret, err)
+
+	blob, err := New(afero.NewMemMapFs(), config.Storage{Type: TypeLocal, Secret: "secret"})
+	require.NoError(t, err)
+	assert.IsType(t, &Local{}, blob)
+}
+
+func TestLocalPresign(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocal(afero.NewMemMapFs(), LocalConfig{Secret: []byte("secret")})
+	require.NoError(t, local.Put(ctx, "file.txt", bytes.NewBufferString("content"), PutOptions{}))
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		response := httptest.NewRecorder()
+		http.StripPrefix(local.BaseURL(), local).ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		url, err := local.Presign(ctx, http.MethodGet, "file.txt", time.Minute)
+		require.NoError(t, err)
+
+		response := serve(url)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "content", response.Body.String())
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		url, err := local.Presign(ctx, http.MethodGet, "file.txt", -time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, serve(url).Code)
+	})
+
+	t.Run("signed for another method", func(t *testing.T) {
+		url, err := local.Presign(ctx, http.MethodPut, "file.txt", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, serve(url).Code)
+	})
+
+	t.Run("tampered key", func(t *testing.T) {
+		url, err := local.Presign(ctx, http.MethodGet, "file.txt", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, serve(strings.Replace(url, "file.txt", "other.txt", 1)).Code)
+	})
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config of the S3 compatible storage
+type S3Config struct {
+	// Endpoint of the API, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle put the bucket name in the path instead of the host,
+	// needed by most of self-hosted S3 compatible servers
+	PathStyle bool
+}
+
+// S3 compatible storage, requests are signed with AWS signature version 4
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3Service        = "s3"
+	s3DateFormat     = "20060102"
+	s3DateTimeFormat = "20060102T150405Z"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3DefaultRegion  = "us-east-1"
+	s3MaxPresign     = 7 * 24 * time.Hour
+	s3ErrorBodyLimit = 1024
+)
+
+// NewS3 storage
+func NewS3(config S3Config) (*S3, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("storage: S3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = s3DefaultRegion
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid S3 endpoint %q", config.Endpoint)
+	}
+
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   http.DefaultClient,
+		now:      time.Now,
+	}, nil
+}
+
+// Put upload an object
+func (s *S3) Put(ctx context.Context, key string, content io.Reader, options PutOptions) error {
+	body, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	request, err := s.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	if options.ContentType != "" {
+		request.Header.Set("Content-Type", options.ContentType)
+	}
+	s.sign(request, body)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	return s.checkResponse(response)
+}
+
+// Get download an object
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	request, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.sign(request, nil)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkResponse(response); err != nil {
+		response.Body.Close()
+		return nil, nil, err
+	}
+
+	object := &Object{
+		Key:         key,
+		Size:        response.ContentLength,
+		ContentType: response.Header.Get("Content-Type"),
+	}
+	if object.ContentType == "" {
+		object.ContentType = defaultContentType
+	}
+	if lastModified, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		object.LastModified = lastModified
+	}
+
+	return response.Body, object, nil
+}
+
+// Delete an object
+func (s *S3) Delete(ctx context.Context, key string) error {
+	request, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(request, nil)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := s.checkResponse(response); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Presign make a URL signed with the query string authentication
+func (s *S3) Presign(ctx context.Context, method, key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > s3MaxPresign {
+		return "", fmt.Errorf("storage: presign expiration must be between 1s and %s", s3MaxPresign)
+	}
+
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.config.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3DateTimeFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		s3EscapePath(objectURL.Path),
+		s3CanonicalQuery(query),
+		"host:" + objectURL.Host + "\n",
+		"host",
+		s3UnsignedBody,
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, canonicalRequest))
+
+	objectURL.RawQuery = s3CanonicalQuery(query)
+	return objectURL.String(), nil
+}
+
+func (s *S3) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return request.WithContext(ctx), nil
+}
+
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	cleaned, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	objectURL := *s.endpoint
+	basePath := strings.TrimSuffix(objectURL.Path, "/")
+	if s.config.PathStyle {
+		objectURL.Path = basePath + "/" + s.config.Bucket + "/" + cleaned
+	} else {
+		objectURL.Host = s.config.Bucket + "." + objectURL.Host
+		objectURL.Path = basePath + "/" + cleaned
+	}
+	objectURL.RawPath = s3EscapePath(objectURL.Path)
+
+	return &objectURL, nil
+}
+
+// sign the request with the Authorization header
+func (s *S3) sign(request *http.Request, body []byte) {
+	now := s.now().UTC()
+	payloadHash := sha256Hex(body)
+	request.Header.Set("X-Amz-Date", now.Format(s3DateTimeFormat))
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headerNames := []string{"host"}
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		headerNames = append(headerNames, lowerName)
+		headers[lowerName] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(headerNames)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3EscapePath(request.URL.Path),
+		s3CanonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm,
+		s.config.AccessKey,
+		s.scope(now),
+		signedHeaders,
+		s.signature(now, canonicalRequest),
+	))
+}
+
+func (s *S3) scope(now time.Time) string {
+	return strings.Join([]string{
+		now.Format(s3DateFormat),
+		s.config.Region,
+		s3Service,
+		"aws4_request",
+	}, "/")
+}
+
+func (s *S3) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3DateTimeFormat),
+		s.scope(now),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s *S3) checkResponse(response *http.Response) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, s3ErrorBodyLimit))
+	return fmt.Errorf("storage: S3 responded %d: %s", response.StatusCode, bytes.TrimSpace(message))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape encode the string as described in the signature version 4,
+// only the unreserved characters are kept
+func s3Escape(value string, keepSlash bool) string {
+	buff := strings.Builder{}
+	for _, char := range []byte(value) {
+		switch {
+		case 'A' <= char && char <= 'Z',
+			'a' <= char && char <= 'z',
+			'0' <= char && char <= '9',
+			char == '-', char == '_', char == '.', char == '~',
+			keepSlash && char == '/':
+			buff.WriteByte(char)
+		default:
+			fmt.Fprintf(&buff, "%%%02X", char)
+		}
+	}
+	return buff.String()
+}
+
+func s3EscapePath(value string) string {
+	if value == "" {
+		return "/"
+	}
+	return s3Escape(value, true)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
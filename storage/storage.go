@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/frullah/gin-boilerplate/fs"
+)
+
+// Blob storage of uploaded files
+type Blob interface {
+	// Put store the content of the reader under the key, replacing the old one
+	Put(ctx context.Context, key string, content io.Reader, options PutOptions) error
+	// Get the content of the key, the caller must close the returned reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete the key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Presign make a temporary URL to access the key without credentials
+	Presign(ctx context.Context, method, key string, expires time.Duration) (string, error)
+}
+
+// PutOptions of the stored content
+type PutOptions struct {
+	// ContentType of the content, local storage guess it from the key extension
+	ContentType string
+}
+
+// Object information of stored content
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Storage types
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// Errors
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrMissingSecret of the local storage, the presigned URLs can't be signed without it
+	ErrMissingSecret = errors.New("storage: missing secret of the local storage")
+)
+
+var blob Blob
+
+// Init the global storage from the global config
+func Init() error {
+	b, err := New(fs.FS, config.Get().Storage)
+	if err != nil {
+		return err
+	}
+	blob = b
+	return nil
+}
+
+// New storage of the config, the local storage keeps the files in the file system
+func New(fileSystem afero.Fs, cnf config.Storage) (Blob, error) {
+	switch cnf.Type {
+	case "", TypeLocal:
+		if cnf.Secret == "" {
+			return nil, ErrMissingSecret
+		}
+		return NewLocal(fileSystem, LocalConfig{
+			Dir:     cnf.Dir,
+			Secret:  []byte(cnf.Secret),
+			BaseURL: cnf.BaseURL,
+		}), nil
+	case TypeS3:
+		s3, err := NewS3(S3Config{
+			Endpoint:  cnf.S3.Endpoint,
+			Region:    cnf.S3.Region,
+			Bucket:    cnf.S3.Bucket,
+			AccessKey: cnf.S3.AccessKey,
+			SecretKey: cnf.S3.SecretKey,
+			PathStyle: cnf.S3.PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("storage: unknown type %q", cnf.Type)
+	}
+}
+
+// InitAsMemory storage, used for testing
+func InitAsMemory() {
+	blob = NewLocal(afero.NewMemMapFs(), LocalConfig{
+		Secret: []byte("storage-secret"),
+	})
+}
+
+// Get storage
+func Get() Blob {
+	return blob
+}
+
+// Set storage
+func Set(b Blob) {
+	blob = b
+}
+
+// cleanKey normalize the key and reject keys which escape the root
+func cleanKey(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || strings.Contains(key, "..") {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
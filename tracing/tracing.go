@@ -0,0 +1,84 @@
+// Package tracing build the OpenTelemetry tracer provider of the app from the config,
+// the spans are exported to stdout or to an OTLP/HTTP collector
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	defaultServiceName = "gin-boilerplate"
+	defaultEndpoint    = "http://localhost:4318/v1/traces"
+)
+
+// Propagator of the trace context between the services, W3C Trace Context and Baggage
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// New tracer provider of the config, it is nil when tracing is disabled.
+// The stdout exporter writes to out
+func New(cnf config.Tracing, out io.Writer) (*sdktrace.TracerProvider, error) {
+	serviceName := cnf.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	}
+
+	switch cnf.Exporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithSyncer(exporter))
+	case "otlp":
+		exporter, err := newOTLPExporter(cnf)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", cnf.Exporter)
+	}
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// newOTLPExporter of the OTLP/HTTP collector, the endpoint URL is split into the options of otlptracehttp
+func newOTLPExporter(cnf config.Tracing) (*otlptrace.Exporter, error) {
+	endpoint := cnf.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("tracing: invalid endpoint %q", endpoint)
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpointURL.Host),
+		otlptracehttp.WithHeaders(cnf.Headers),
+	}
+	if endpointURL.Path != "" {
+		options = append(options, otlptracehttp.WithURLPath(endpointURL.Path))
+	}
+	if endpointURL.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	// the HTTP client connects on the first export, so the context only bounds the creation
+	return otlptracehttp.New(context.Background(), options...)
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/frullah/gin-boilerplate/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		provider, err := New(config.Tracing{}, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, provider)
+	})
+
+	t.Run("unknown exporter", func(t *testing.T) {
+		_, err := New(config.Tracing{Exporter: "jaeger"}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		provider, err := New(config.Tracing{Exporter: "stdout", ServiceName: "api"}, out)
+		require.NoError(t, err)
+		_, span := provider.Tracer("test").Start(context.Background(), "request")
+		span.End()
+		require.NoError(t, provider.Shutdown(context.Background()))
+
+		assert.Contains(t, out.String(), `"Name":"request"`)
+		assert.Contains(t, out.String(), `"Value":"api"`)
+	})
+
+	t.Run("otlp", func(t *testing.T) {
+		requests := []*http.Request{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+		}))
+		defer server.Close()
+
+		provider, err := New(config.Tracing{
+			Exporter: "otlp",
+			Endpoint: server.URL + "/collector/traces",
+			Headers:  map[string]string{"Authorization": "Bearer secret"},
+		}, nil)
+		require.NoError(t, err)
+		_, span := provider.Tracer("test").Start(context.Background(), "request")
+		span.End()
+		// the batch is exported on shutdown
+		require.NoError(t, provider.Shutdown(context.Background()))
+		require.Len(t, requests, 1)
+		assert.Equal(t, "/collector/traces", requests[0].URL.Path)
+		assert.Equal(t, "Bearer secret", requests[0].Header.Get("Authorization"))
+		assert.Equal(t, "application/x-protobuf", requests[0].Header.Get("Content-Type"))
+	})
+
+	t.Run("invalid otlp endpoint", func(t *testing.T) {
+		_, err := New(config.Tracing{Exporter: "otlp", Endpoint: "localhost"}, nil)
+		assert.Error(t, err)
+	})
+}